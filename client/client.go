@@ -0,0 +1,381 @@
+// Package client is a Go client for fhirterm terminology operations.
+// Responses are decoded into fhirterm types, failed operations are
+// returned as *OperationError.
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/mlapshin/fhirterm"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type Client struct {
+	BaseUrl    *url.URL
+	HttpClient *http.Client
+}
+
+// Base URL is the address fhirterm is served on, like
+// http://localhost:3000/
+func New(baseUrl string) (*Client, error) {
+	u, err := url.Parse(baseUrl)
+	if err != nil {
+		return nil, fmt.Errorf("invalid fhirterm base URL %s: %s", baseUrl, err)
+	}
+
+	if u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("invalid fhirterm base URL %s: scheme and host are required", baseUrl)
+	}
+
+	// relative operation paths are resolved against base path
+	if !strings.HasSuffix(u.Path, "/") {
+		u.Path += "/"
+	}
+
+	return &Client{BaseUrl: u, HttpClient: http.DefaultClient}, nil
+}
+
+type Issue struct {
+	Severity    string `json:"severity"`
+	Code        string `json:"code,omitempty"`
+	Details     string `json:"details,omitempty"`
+	Diagnostics string `json:"diagnostics,omitempty"`
+}
+
+// Returned when server responds with error status, issues are taken
+// from OperationOutcome in response body (if any)
+type OperationError struct {
+	StatusCode int
+	Issues     []Issue
+}
+
+func (e *OperationError) Error() string {
+	messages := make([]string, 0, len(e.Issues))
+	for _, i := range e.Issues {
+		if i.Details != "" {
+			messages = append(messages, i.Details)
+		} else if i.Diagnostics != "" {
+			messages = append(messages, i.Diagnostics)
+		}
+	}
+
+	if len(messages) == 0 {
+		return fmt.Sprintf("fhirterm responded with %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+	}
+
+	return fmt.Sprintf("fhirterm responded with %d: %s", e.StatusCode, strings.Join(messages, "; "))
+}
+
+// Unknown value sets, code systems and codes are reported by server
+// with 404 status
+func IsNotFound(err error) bool {
+	e, ok := err.(*OperationError)
+	return ok && e.StatusCode == http.StatusNotFound
+}
+
+func (c *Client) get(ctx context.Context, path string, query url.Values, result interface{}) error {
+	ref, err := url.Parse(path)
+	if err != nil {
+		return err
+	}
+
+	ref.RawQuery = query.Encode()
+	req, err := http.NewRequest("GET", c.BaseUrl.ResolveReference(ref).String(), nil)
+	if err != nil {
+		return err
+	}
+
+	req = req.WithContext(ctx)
+	req.Header.Set("Accept", "application/json+fhir")
+
+	resp, err := c.HttpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		var outcome struct {
+			ResourceType string  `json:"resourceType"`
+			Issue        []Issue `json:"issue"`
+		}
+
+		opErr := &OperationError{StatusCode: resp.StatusCode}
+		if json.Unmarshal(body, &outcome) == nil && outcome.ResourceType == "OperationOutcome" {
+			opErr.Issues = outcome.Issue
+		}
+
+		return opErr
+	}
+
+	err = json.Unmarshal(body, result)
+	if err != nil {
+		return fmt.Errorf("cannot parse response of %s: %s", req.URL, err)
+	}
+
+	return nil
+}
+
+func expandQuery(params fhirterm.ExpandParams) url.Values {
+	query := url.Values{}
+
+	if params.Filter != "" {
+		query.Set("filter", params.Filter)
+	}
+
+	if params.DisplayLanguage != "" {
+		query.Set("displayLanguage", params.DisplayLanguage)
+	}
+
+	if params.Offset > 0 {
+		query.Set("offset", strconv.Itoa(params.Offset))
+	}
+
+	if params.Count > 0 {
+		query.Set("count", strconv.Itoa(params.Count))
+	}
+
+	systems := make([]string, 0, len(params.SystemVersions))
+	for system, _ := range params.SystemVersions {
+		systems = append(systems, system)
+	}
+	sort.Strings(systems)
+
+	for _, system := range systems {
+		query.Add("system-version", system+"|"+params.SystemVersions[system])
+	}
+
+	return query
+}
+
+// Expands value set with server-local id, single page is returned
+// when params.Count is set
+func (c *Client) Expand(ctx context.Context, id string, params fhirterm.ExpandParams) (*fhirterm.ValueSet, error) {
+	return c.expand(ctx, "ValueSet/"+url.PathEscape(id)+"/$expand", expandQuery(params), id)
+}
+
+// Value set is resolved by server, identifier may be url of implicit
+// value set like http://loinc.org/vs/LL715-4
+func (c *Client) ExpandByIdentifier(ctx context.Context, identifier string, params fhirterm.ExpandParams) (*fhirterm.ValueSet, error) {
+	query := expandQuery(params)
+	query.Set("identifier", identifier)
+
+	return c.expand(ctx, "ValueSet/$expand", query, identifier)
+}
+
+func (c *Client) expand(ctx context.Context, path string, query url.Values, name string) (*fhirterm.ValueSet, error) {
+	var vs fhirterm.ValueSet
+	err := c.get(ctx, path, query, &vs)
+	if err != nil {
+		return nil, err
+	}
+
+	if vs.Expansion == nil {
+		return nil, fmt.Errorf("response of $expand of %s contains no expansion", name)
+	}
+
+	return &vs, nil
+}
+
+type ValidationResult struct {
+	Result  bool
+	Message string
+	Display string
+}
+
+func parametersToValidationResult(params *fhirterm.Parameters) *ValidationResult {
+	result := &ValidationResult{}
+
+	for _, p := range params.Parameter {
+		switch p.Name {
+		case "result":
+			result.Result = p.ValueBoolean != nil && *p.ValueBoolean
+		case "message":
+			result.Message = p.ValueString
+		case "display":
+			result.Display = p.ValueString
+		}
+	}
+
+	return result
+}
+
+// Invalid code is not an error, it's reported with false Result
+func (c *Client) ValidateCode(ctx context.Context, system string, code string, display string) (*ValidationResult, error) {
+	return c.validateCode(ctx, "ValueSet/$validate-code", system, code, display)
+}
+
+// Empty system matches code from any system of value set
+func (c *Client) ValidateCodeInValueSet(ctx context.Context, id string, system string, code string, display string) (*ValidationResult, error) {
+	return c.validateCode(ctx, "ValueSet/"+url.PathEscape(id)+"/$validate-code", system, code, display)
+}
+
+func (c *Client) validateCode(ctx context.Context, path string, system string, code string, display string) (*ValidationResult, error) {
+	query := url.Values{"code": {code}}
+
+	if system != "" {
+		query.Set("system", system)
+	}
+
+	if display != "" {
+		query.Set("display", display)
+	}
+
+	var params fhirterm.Parameters
+	err := c.get(ctx, path, query, &params)
+	if err != nil {
+		return nil, err
+	}
+
+	return parametersToValidationResult(&params), nil
+}
+
+func partValue(p fhirterm.Parameter) string {
+	if p.ValueCode != "" {
+		return p.ValueCode
+	} else if p.ValueUri != "" {
+		return p.ValueUri
+	}
+
+	return p.ValueString
+}
+
+func parametersToConcept(system string, code string, params *fhirterm.Parameters) *fhirterm.NsConcept {
+	concept := &fhirterm.NsConcept{System: system, Code: code}
+
+	for _, p := range params.Parameter {
+		switch p.Name {
+		case "display":
+			concept.Display = p.ValueString
+		case "designation":
+			var d fhirterm.NsDesignation
+			for _, part := range p.Part {
+				if part.Name == "language" {
+					d.Language = partValue(part)
+				} else if part.Name == "value" {
+					d.Value = partValue(part)
+				}
+			}
+			concept.Designations = append(concept.Designations, d)
+		case "property":
+			var prop fhirterm.NsProperty
+			for _, part := range p.Part {
+				if part.Name == "code" {
+					prop.Code = partValue(part)
+				} else if part.Name == "value" {
+					prop.Value = partValue(part)
+				}
+			}
+			concept.Properties = append(concept.Properties, prop)
+		}
+	}
+
+	return concept
+}
+
+// Unknown code is reported as *OperationError with 404 status, see
+// IsNotFound
+func (c *Client) Lookup(ctx context.Context, system string, code string, displayLanguage string) (*fhirterm.NsConcept, error) {
+	query := url.Values{"system": {system}, "code": {code}}
+
+	if displayLanguage != "" {
+		query.Set("displayLanguage", displayLanguage)
+	}
+
+	var params fhirterm.Parameters
+	err := c.get(ctx, "ValueSet/$lookup", query, &params)
+	if err != nil {
+		return nil, err
+	}
+
+	return parametersToConcept(system, code, &params), nil
+}
+
+// Outcome of $subsumes operation
+const (
+	Equivalent  = fhirterm.SubsumesEquivalent
+	Subsumes    = fhirterm.SubsumesSubsumes
+	SubsumedBy  = fhirterm.SubsumesSubsumedBy
+	NotSubsumed = fhirterm.SubsumesNotSubsumed
+)
+
+// Tests subsumption relationship between codes A and B of code
+// system, returns one of Equivalent, Subsumes, SubsumedBy and
+// NotSubsumed
+func (c *Client) Subsumes(ctx context.Context, system string, codeA string, codeB string) (string, error) {
+	query := url.Values{"system": {system}, "codeA": {codeA}, "codeB": {codeB}}
+
+	var params fhirterm.Parameters
+	err := c.get(ctx, "CodeSystem/$subsumes", query, &params)
+	if err != nil {
+		return "", err
+	}
+
+	for _, p := range params.Parameter {
+		if p.Name == "outcome" {
+			return p.ValueCode, nil
+		}
+	}
+
+	return "", fmt.Errorf("response of $subsumes contains no outcome")
+}
+
+type TranslateMatch struct {
+	Equivalence string
+	Concept     fhirterm.Coding
+	// url of concept map the match comes from
+	Source string
+	// map rule and advice of conditional maps
+	Comments string
+}
+
+// Translates code into target code system (empty target means any),
+// no matches is not an error
+func (c *Client) Translate(ctx context.Context, system string, code string, target string) ([]TranslateMatch, error) {
+	query := url.Values{"system": {system}, "code": {code}}
+
+	if target != "" {
+		query.Set("target", target)
+	}
+
+	var params fhirterm.Parameters
+	err := c.get(ctx, "ConceptMap/$translate", query, &params)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]TranslateMatch, 0)
+	for _, p := range params.Parameter {
+		if p.Name != "match" {
+			continue
+		}
+
+		var m TranslateMatch
+		for _, part := range p.Part {
+			if part.Name == "equivalence" {
+				m.Equivalence = partValue(part)
+			} else if part.Name == "concept" && part.ValueCoding != nil {
+				m.Concept = *part.ValueCoding
+			} else if part.Name == "source" {
+				m.Source = partValue(part)
+			} else if part.Name == "comments" {
+				m.Comments = partValue(part)
+			}
+		}
+
+		result = append(result, m)
+	}
+
+	return result, nil
+}
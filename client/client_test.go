@@ -0,0 +1,219 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"github.com/mlapshin/fhirterm"
+	"github.com/stretchr/testify/assert"
+	"net/http/httptest"
+	"testing"
+)
+
+type fakeStorage struct{}
+
+func (s fakeStorage) FindValueSetById(id string) (*fhirterm.ValueSet, error) {
+	if id != "units" {
+		return nil, fmt.Errorf("unknown value set: %s", id)
+	}
+
+	concepts := make([]fhirterm.VsComposeIncludeConcept, 0)
+	for _, code := range []string{"mg", "g", "kg", "mL", "L"} {
+		concepts = append(concepts, fhirterm.VsComposeIncludeConcept{Code: code})
+	}
+
+	return &fhirterm.ValueSet{
+		ResourceType: "ValueSet",
+		Id:           id,
+		Compose: &fhirterm.VsCompose{
+			Include: []fhirterm.VsComposeInclude{
+				fhirterm.VsComposeInclude{System: fhirterm.UcumUrl, Concept: concepts},
+			},
+		},
+	}, nil
+}
+
+func (s fakeStorage) FindValueSetByIdentifier(identifier string) (*fhirterm.ValueSet, error) {
+	if identifier != "http://example.org/vs/units" {
+		return nil, fmt.Errorf("unknown value set: %s", identifier)
+	}
+
+	return s.FindValueSetById("units")
+}
+
+func (s fakeStorage) FindConceptMaps(system string, code string) ([]*fhirterm.ConceptMap, error) {
+	return []*fhirterm.ConceptMap{
+		&fhirterm.ConceptMap{
+			ResourceType: "ConceptMap",
+			Url:          "http://example.org/units-to-ucum",
+			Element: []fhirterm.CmElement{
+				fhirterm.CmElement{
+					CodeSystem: "http://example.org/units",
+					Code:       "milligram",
+					Target: []fhirterm.CmTarget{
+						fhirterm.CmTarget{CodeSystem: fhirterm.UcumUrl, Code: "mg", Equivalence: "equivalent"},
+						fhirterm.CmTarget{CodeSystem: "http://example.org/other", Code: "MG", Equivalence: "equal"},
+					},
+				},
+			},
+		},
+	}, nil
+}
+
+// Code system with ancestors of every code
+type fakeHierarchy map[string][]string
+
+func (ns fakeHierarchy) Url() string {
+	return "http://example.org/hierarchy"
+}
+
+func (ns fakeHierarchy) Filter(f *fhirterm.NsFilter) ([]fhirterm.VsExpansionContains, error) {
+	return []fhirterm.VsExpansionContains{}, nil
+}
+
+func (ns fakeHierarchy) CodeDisplay(code string) (string, bool) {
+	_, found := ns[code]
+	return code, found
+}
+
+func (ns fakeHierarchy) AncestorCodes(code string) ([]string, error) {
+	return ns[code], nil
+}
+
+func newTestClient(t *testing.T) (*Client, func()) {
+	s := &fhirterm.Server{Storage: fakeStorage{}, Registry: fhirterm.NewRegistry()}
+	s.Registry.RegisterNamespace(fakeHierarchy{
+		"drug":      {},
+		"analgesic": {"drug"},
+		"aspirin":   {"analgesic", "drug"},
+		"vaccine":   {"drug"},
+	})
+	ts := httptest.NewServer(s.Handler())
+
+	c, err := New(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return c, ts.Close
+}
+
+func Test_ClientOperations(t *testing.T) {
+	assert := assert.New(t)
+	c, stop := newTestClient(t)
+	defer stop()
+	ctx := context.Background()
+
+	vs, err := c.Expand(ctx, "units", fhirterm.ExpandParams{Offset: 1, Count: 2})
+	assert.Nil(err)
+	assert.Equal(5, vs.Expansion.Total)
+	assert.Equal("g", vs.Expansion.Contains[0].Code)
+	assert.Equal(2, len(vs.Expansion.Contains))
+
+	vs, err = c.ExpandByIdentifier(ctx, "http://example.org/vs/units", fhirterm.ExpandParams{Count: 1})
+	assert.Nil(err)
+	assert.Equal(5, vs.Expansion.Total)
+	assert.Equal("mg", vs.Expansion.Contains[0].Code)
+
+	concept, err := c.Lookup(ctx, fhirterm.UcumUrl, "mg", "")
+	assert.Nil(err)
+	assert.Equal("milligram", concept.Display)
+	assert.Equal(fhirterm.NsProperty{Code: "canonical-unit", Value: "g"}, concept.Properties[0])
+
+	result, err := c.ValidateCode(ctx, fhirterm.UcumUrl, "mg/dL", "")
+	assert.Nil(err)
+	assert.True(result.Result)
+
+	result, err = c.ValidateCode(ctx, fhirterm.UcumUrl, "mg/", "")
+	assert.Nil(err)
+	assert.False(result.Result)
+	assert.NotEqual("", result.Message)
+
+	result, err = c.ValidateCodeInValueSet(ctx, "units", "", "kg", "")
+	assert.Nil(err)
+	assert.True(result.Result)
+
+	matches, err := c.Translate(ctx, "http://example.org/units", "milligram", fhirterm.UcumUrl)
+	assert.Nil(err)
+	assert.Equal([]TranslateMatch{
+		TranslateMatch{
+			Equivalence: "equivalent",
+			Concept:     fhirterm.Coding{System: fhirterm.UcumUrl, Code: "mg", Display: "milligram"},
+			Source:      "http://example.org/units-to-ucum",
+		},
+	}, matches)
+
+	matches, err = c.Translate(ctx, "http://example.org/units", "gram", "")
+	assert.Nil(err)
+	assert.Equal(0, len(matches))
+
+	subsumes := []struct {
+		codeA   string
+		codeB   string
+		outcome string
+	}{
+		{"drug", "aspirin", Subsumes},
+		{"aspirin", "analgesic", SubsumedBy},
+		{"analgesic", "analgesic", Equivalent},
+		{"vaccine", "aspirin", NotSubsumed},
+	}
+
+	for _, test := range subsumes {
+		outcome, err := c.Subsumes(ctx, "http://example.org/hierarchy", test.codeA, test.codeB)
+		assert.Nil(err)
+		assert.Equal(test.outcome, outcome, test.codeA+" "+test.codeB)
+	}
+
+	outcome, err := c.Subsumes(ctx, fhirterm.UcumUrl, "mg", "g")
+	assert.True(IsNotFound(err))
+	assert.Equal("", outcome)
+}
+
+func Test_ClientErrors(t *testing.T) {
+	assert := assert.New(t)
+	c, stop := newTestClient(t)
+	defer stop()
+	ctx := context.Background()
+
+	_, err := c.Lookup(ctx, "http://example.org/unknown", "42", "")
+	assert.True(IsNotFound(err))
+	assert.Contains(err.Error(), "unknown code system: http://example.org/unknown")
+
+	_, err = c.Subsumes(ctx, "http://example.org/hierarchy", "drug", "ibuprofen")
+	assert.True(IsNotFound(err))
+	assert.Contains(err.Error(), "unknown code 'ibuprofen'")
+
+	_, err = c.Expand(ctx, "missing", fhirterm.ExpandParams{})
+	opErr, ok := err.(*OperationError)
+	assert.True(ok)
+	assert.Equal(422, opErr.StatusCode)
+	assert.Equal("unknown value set: missing", opErr.Issues[0].Details)
+
+	_, err = c.ExpandByIdentifier(ctx, "http://example.org/vs/missing", fhirterm.ExpandParams{})
+	assert.Equal("unknown value set: http://example.org/vs/missing", err.(*OperationError).Issues[0].Details)
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = c.Expand(cancelled, "units", fhirterm.ExpandParams{})
+	assert.NotNil(err)
+}
+
+func Test_ExpansionIterator(t *testing.T) {
+	assert := assert.New(t)
+	c, stop := newTestClient(t)
+	defer stop()
+
+	codes := make([]string, 0)
+	it := c.ExpandIterator(context.Background(), "units", fhirterm.ExpandParams{Count: 2})
+	for it.Next() {
+		codes = append(codes, it.Concept().Code)
+	}
+
+	assert.Nil(it.Err())
+	assert.Equal([]string{"mg", "g", "kg", "mL", "L"}, codes)
+	assert.Equal(5, it.Total())
+	assert.False(it.Next())
+
+	it = c.ExpandIterator(context.Background(), "missing", fhirterm.ExpandParams{})
+	assert.False(it.Next())
+	assert.NotNil(it.Err())
+}
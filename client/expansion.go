@@ -0,0 +1,87 @@
+package client
+
+import (
+	"context"
+	"github.com/mlapshin/fhirterm"
+)
+
+const defaultPageSize = 1000
+
+// Iterates over concepts of value set expansion fetching it page by
+// page, usage is similar to sql.Rows:
+//
+//	it := c.ExpandIterator(ctx, "my-vs", fhirterm.ExpandParams{Count: 500})
+//	for it.Next() {
+//		concept := it.Concept()
+//	}
+//	err := it.Err()
+type ExpansionIterator struct {
+	client  *Client
+	ctx     context.Context
+	id      string
+	params  fhirterm.ExpandParams
+	page    []fhirterm.VsExpansionContains
+	pos     int
+	total   int
+	fetched bool
+	err     error
+}
+
+// params.Count is page size, params.Offset is offset of first
+// concept returned
+func (c *Client) ExpandIterator(ctx context.Context, id string, params fhirterm.ExpandParams) *ExpansionIterator {
+	if params.Count <= 0 {
+		params.Count = defaultPageSize
+	}
+
+	return &ExpansionIterator{client: c, ctx: ctx, id: id, params: params, pos: -1}
+}
+
+func (it *ExpansionIterator) Next() bool {
+	if it.err != nil {
+		return false
+	}
+
+	if it.pos+1 < len(it.page) {
+		it.pos++
+		return true
+	}
+
+	if it.fetched {
+		it.params.Offset += len(it.page)
+
+		// empty page means that server has nothing more even if
+		// total was not reached
+		if len(it.page) == 0 || it.params.Offset >= it.total {
+			it.page = nil
+			return false
+		}
+	}
+
+	vs, err := it.client.Expand(it.ctx, it.id, it.params)
+	if err != nil {
+		it.err = err
+		return false
+	}
+
+	it.fetched = true
+	it.page = vs.Expansion.Contains
+	it.total = vs.Expansion.Total
+	it.pos = 0
+
+	return len(it.page) > 0
+}
+
+func (it *ExpansionIterator) Concept() fhirterm.VsExpansionContains {
+	return it.page[it.pos]
+}
+
+// Total number of concepts in expansion, known after first call of
+// Next
+func (it *ExpansionIterator) Total() int {
+	return it.total
+}
+
+func (it *ExpansionIterator) Err() error {
+	return it.err
+}
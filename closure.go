@@ -0,0 +1,212 @@
+package fhirterm
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+)
+
+// Closure tables maintained with $closure operation. Client
+// initializes table by name and then submits concepts, every call
+// returns new subsumption relationships between submitted concepts.
+// Tables are kept in memory of server, so clients have to
+// initialize them again after restart.
+type ClosureTables struct {
+	mu     sync.Mutex
+	tables map[string]*closureTable
+}
+
+type closureKey struct {
+	system string
+	code   string
+}
+
+// Concept B is subsumed by concept A, found in version of table
+type closureRow struct {
+	version int
+	system  string
+	codeA   string
+	codeB   string
+}
+
+type closureTable struct {
+	version int
+	// concepts in order of addition and their ancestors
+	keys      []closureKey
+	ancestors map[closureKey]map[string]bool
+	rows      []closureRow
+}
+
+// Creates table or resets existing one
+func (c *ClosureTables) init(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.tables == nil {
+		c.tables = make(map[string]*closureTable)
+	}
+
+	c.tables[name] = &closureTable{ancestors: make(map[closureKey]map[string]bool)}
+}
+
+// Returns relationships found after specified version, current
+// version is returned as well
+func (c *ClosureTables) since(name string, version int) ([]closureRow, int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	t, found := c.tables[name]
+	if !found {
+		return nil, 0, fmt.Errorf("closure table %s is not initialized", name)
+	}
+
+	result := make([]closureRow, 0)
+	for _, r := range t.rows {
+		if r.version > version {
+			result = append(result, r)
+		}
+	}
+
+	return result, t.version, nil
+}
+
+// Adds concepts with their ancestors to table, concepts added
+// earlier are skipped. Returns new relationships and new version.
+func (c *ClosureTables) add(name string, concepts []closureKey, ancestors [][]string) ([]closureRow, int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	t, found := c.tables[name]
+	if !found {
+		return nil, 0, fmt.Errorf("closure table %s is not initialized", name)
+	}
+
+	t.version++
+	result := make([]closureRow, 0)
+
+	for i, k := range concepts {
+		if _, found := t.ancestors[k]; found {
+			continue
+		}
+
+		kAncestors := make(map[string]bool, len(ancestors[i]))
+		for _, a := range ancestors[i] {
+			kAncestors[a] = true
+		}
+
+		for _, other := range t.keys {
+			if other.system != k.system {
+				continue
+			}
+
+			if kAncestors[other.code] {
+				result = append(result, closureRow{t.version, k.system, other.code, k.code})
+			} else if t.ancestors[other][k.code] {
+				result = append(result, closureRow{t.version, k.system, k.code, other.code})
+			}
+		}
+
+		t.keys = append(t.keys, k)
+		t.ancestors[k] = kAncestors
+	}
+
+	t.rows = append(t.rows, result...)
+	return result, t.version, nil
+}
+
+// Concepts of code systems without hierarchy are added to table,
+// but never get into relationships
+func (s *Server) conceptAncestors(c Coding) ([]string, error) {
+	ns, found := s.Registry.FindNamespace(c.System)
+	if !found {
+		return []string{}, nil
+	}
+
+	h, ok := ns.(HierarchyNamespace)
+	if !ok {
+		return []string{}, nil
+	}
+
+	return h.AncestorCodes(c.Code)
+}
+
+// Relationships are returned as ConceptMap, target of every mapping
+// subsumes its source
+func closureRowsToConceptMap(name string, version int, rows []closureRow) *ConceptMap {
+	cm := &ConceptMap{
+		ResourceType: "ConceptMap",
+		Name:         "Updates for Closure Table " + name,
+		Version:      strconv.Itoa(version),
+		Element:      make([]CmElement, 0),
+	}
+
+	elements := make(map[closureKey]int)
+	for _, r := range rows {
+		k := closureKey{r.system, r.codeB}
+		i, found := elements[k]
+		if !found {
+			i = len(cm.Element)
+			elements[k] = i
+			cm.Element = append(cm.Element, CmElement{CodeSystem: r.system, Code: r.codeB})
+		}
+
+		cm.Element[i].Target = append(cm.Element[i].Target,
+			CmTarget{CodeSystem: r.system, Code: r.codeA, Equivalence: "subsumes"})
+	}
+
+	return cm
+}
+
+// Call without concepts and version initializes table, version
+// means resynchronization (all relationships found after version
+// are returned). Version is nil when request has no version
+// parameter, empty or unreadable version is an error, so table is
+// never reset by mistake.
+func (s *Server) Closure(name string, concepts []Coding, version *string) (*ConceptMap, error) {
+	if name == "" {
+		return nil, fmt.Errorf("'name' parameter is required")
+	}
+
+	if version != nil {
+		if len(concepts) > 0 {
+			return nil, fmt.Errorf("'concept' and 'version' parameters can't be used together")
+		}
+
+		v, err := strconv.Atoi(*version)
+		if err != nil {
+			return nil, fmt.Errorf("invalid version of closure table %s: '%s'", name, *version)
+		}
+
+		rows, current, err := s.Closures.since(name, v)
+		if err != nil {
+			return nil, err
+		}
+
+		return closureRowsToConceptMap(name, current, rows), nil
+	}
+
+	if len(concepts) == 0 {
+		s.Closures.init(name)
+		return closureRowsToConceptMap(name, 0, nil), nil
+	}
+
+	// ancestors are queried before table is locked
+	keys := make([]closureKey, 0, len(concepts))
+	ancestors := make([][]string, 0, len(concepts))
+	for _, c := range concepts {
+		a, err := s.conceptAncestors(c)
+		if err != nil {
+			return nil, err
+		}
+
+		keys = append(keys, closureKey{normalizeNsUrl(c.System), c.Code})
+		ancestors = append(ancestors, a)
+	}
+
+	rows, current, err := s.Closures.add(name, keys, ancestors)
+	if err != nil {
+		return nil, err
+	}
+
+	return closureRowsToConceptMap(name, current, rows), nil
+}
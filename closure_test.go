@@ -0,0 +1,121 @@
+package fhirterm
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// Hierarchy: A -> B -> C
+type fakeHierarchyNamespace struct {
+	fakeNamespace
+}
+
+func (ns fakeHierarchyNamespace) AncestorCodes(code string) ([]string, error) {
+	return map[string][]string{"B": {"A"}, "C": {"A", "B"}}[code], nil
+}
+
+func Test_Closure(t *testing.T) {
+	assert := assert.New(t)
+	url := "http://example.org/hierarchy"
+
+	s := &Server{Registry: NewRegistry()}
+	s.Registry.RegisterNamespace(fakeHierarchyNamespace{fakeNamespace{url, ""}})
+	coding := func(code string) Coding {
+		return Coding{System: url, Code: code}
+	}
+	target := func(code string) CmTarget {
+		return CmTarget{CodeSystem: url, Code: code, Equivalence: "subsumes"}
+	}
+	version := func(v string) *string {
+		return &v
+	}
+
+	_, err := s.Closure("t", []Coding{coding("C")}, nil)
+	assert.EqualError(err, "closure table t is not initialized")
+
+	cm, err := s.Closure("t", nil, nil)
+	assert.Nil(err)
+	assert.Equal("0", cm.Version)
+	assert.Equal(0, len(cm.Element))
+
+	cm, err = s.Closure("t", []Coding{coding("C"), coding("X"), {System: UcumUrl, Code: "mg"}}, nil)
+	assert.Nil(err)
+	assert.Equal(0, len(cm.Element), "concepts without hierarchy have no relationships")
+
+	cm, err = s.Closure("t", []Coding{coding("A"), coding("B"), coding("C")}, nil)
+	assert.Nil(err)
+	assert.Equal("2", cm.Version)
+	assert.Equal([]CmElement{
+		{CodeSystem: url, Code: "C", Target: []CmTarget{target("A"), target("B")}},
+		{CodeSystem: url, Code: "B", Target: []CmTarget{target("A")}},
+	}, cm.Element)
+
+	cm, err = s.Closure("t", nil, version("1"))
+	assert.Nil(err)
+	assert.Equal("2", cm.Version)
+	assert.Equal(2, len(cm.Element), "relationships since version 1 are returned")
+
+	cm, err = s.Closure("t", nil, version("2"))
+	assert.Nil(err)
+	assert.Equal(0, len(cm.Element))
+
+	_, err = s.Closure("t", nil, version(""))
+	assert.EqualError(err, "invalid version of closure table t: ''")
+
+	cm, err = s.Closure("t", nil, version("0"))
+	assert.Nil(err)
+	assert.Equal(2, len(cm.Element), "table is not reset by unreadable version")
+}
+
+func Test_ClosureOperation(t *testing.T) {
+	assert := assert.New(t)
+	url := "http://example.org/hierarchy"
+
+	s := &Server{Registry: NewRegistry()}
+	s.Registry.RegisterNamespace(fakeHierarchyNamespace{fakeNamespace{url, ""}})
+	ts := httptest.NewServer(s.Handler())
+	defer ts.Close()
+
+	post := func(params string) (int, *ConceptMap) {
+		resp, err := http.Post(ts.URL+"/$closure", "application/json+fhir",
+			strings.NewReader(`{"resourceType": "Parameters", "parameter": [`+params+`]}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		var cm ConceptMap
+		json.NewDecoder(resp.Body).Decode(&cm)
+		return resp.StatusCode, &cm
+	}
+
+	status, _ := post(`{"name": "name", "valueString": "t"}`)
+	assert.Equal(http.StatusOK, status)
+
+	status, cm := post(`{"name": "name", "valueString": "t"},
+                      {"name": "concept", "valueCoding": {"system": "` + url + `", "code": "A"}},
+                      {"name": "concept", "valueCoding": {"system": "` + url + `", "code": "B"}}`)
+	assert.Equal(http.StatusOK, status)
+	assert.Equal("1", cm.Version)
+	assert.Equal(1, len(cm.Element))
+
+	status, cm = post(`{"name": "name", "valueString": "t"}, {"name": "version", "valueId": "0"}`)
+	assert.Equal(http.StatusOK, status)
+	assert.Equal("1", cm.Version)
+	assert.Equal(1, len(cm.Element), "version is read from valueId")
+
+	status, cm = post(`{"name": "name", "valueString": "t"}, {"name": "version", "valueString": "0"}`)
+	assert.Equal(http.StatusOK, status)
+	assert.Equal(1, len(cm.Element), "version is read from valueString")
+
+	status, _ = post(`{"name": "name", "valueString": "t"}, {"name": "version", "valueInteger": 0}`)
+	assert.Equal(http.StatusUnprocessableEntity, status)
+
+	status, cm = post(`{"name": "name", "valueString": "t"}, {"name": "version", "valueId": "0"}`)
+	assert.Equal(http.StatusOK, status)
+	assert.Equal(1, len(cm.Element), "table is not reset by unreadable version")
+}
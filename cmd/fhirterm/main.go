@@ -34,19 +34,17 @@ func main() {
 
 	log.Printf("Using config file: %s", *configPath)
 
-	err = fhirterm.OpenDb(config)
+	server, err := fhirterm.NewServer(config)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "%s\n", err)
 		os.Exit(1)
 	}
 
-	err = fhirterm.InitStorage(config.Storage)
+	err = server.ListenAndServe()
+	server.Close()
+
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error initializing Storage: %s\n", err)
+		fmt.Fprintf(os.Stderr, "%s\n", err)
 		os.Exit(1)
 	}
-
-	fhirterm.StartServer(config)
-
-	os.Exit(0)
 }
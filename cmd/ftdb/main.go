@@ -13,27 +13,52 @@ func main() {
 		fmt.Fprintf(os.Stderr, "ftdb: Command-line FHIRterm database utility\n")
 		fmt.Fprintf(os.Stderr, "Usage:\n")
 		flag.PrintDefaults()
+		fmt.Fprintf(os.Stderr, "\nDatabase keeps single release of every code system, import releases\n")
+		fmt.Fprintf(os.Stderr, "served side by side into separate databases listed in config.\n")
+		fmt.Fprintf(os.Stderr, "Import of other version than recorded in database fails without -replace.\n")
+		fmt.Fprintf(os.Stderr, "Restart fhirterm server after import to serve imported release.\n")
 	}
 
-	var dbPath = flag.String("db", "", "Path to SQLite database")
+	var dbPath = flag.String("db", "", "Path to SQLite database or PostgreSQL URL (postgres://...)")
 	var action = flag.String("action", "", "Action to perform")
 	var inputFile = flag.String("file", "", "Source file containing dataset to import")
+	var mappingFile = flag.String("mapping", "", "JSON file mapping CSV columns for import-codesystem action")
+	var replace = flag.Bool("replace", false, "Replace release of code system of different version")
 	var err error
 
 	flag.Parse()
 
-	err = fhirterm.OpenDbSpecificFile(*dbPath)
+	db, err := fhirterm.OpenDatabase(*dbPath)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error opening SQLite database: %s\n", err)
+		fmt.Fprintf(os.Stderr, "Error opening database: %s\n", err)
 		os.Exit(1)
 	}
-	defer fhirterm.CloseDb()
+	defer db.Close()
+	db.ReplaceRelease = *replace
 
 	switch *action {
 	case "import-loinc":
-		err = importer.ImportLoinc(fhirterm.GetDb(), *inputFile)
+		err = importer.ImportLoinc(db, *inputFile)
 	case "import-snomed":
-		err = importer.ImportSnomed(fhirterm.GetDb(), *inputFile)
+		err = importer.ImportSnomed(db, *inputFile)
+	case "import-snomed-icd10-map":
+		err = importer.ImportSnomedIcd10Map(db, *inputFile)
+	case "import-rxnorm":
+		err = importer.ImportRxnorm(db, *inputFile)
+	case "import-icd10":
+		err = importer.ImportIcd10(db, *inputFile, fhirterm.Icd10Url)
+	case "import-icd10cm":
+		err = importer.ImportIcd10(db, *inputFile, fhirterm.Icd10CmUrl)
+	case "import-hl7":
+		err = importer.ImportHl7(db, *inputFile)
+	case "import-fhir-codesystem":
+		err = importer.ImportFhirCodeSystems(db, *inputFile)
+	case "import-codesystem":
+		var mapping *importer.CodeSystemMapping
+		mapping, err = importer.ReadCodeSystemMapping(*mappingFile)
+		if err == nil {
+			err = importer.ImportCodeSystem(db, *inputFile, mapping)
+		}
 	default:
 		fmt.Fprintf(os.Stderr, "Unknown action: %s\n", *action)
 	}
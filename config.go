@@ -6,12 +6,41 @@ import (
 	"io/ioutil"
 )
 
+// Settings of SQLite databases opened by server, zero values keep
+// SQLite defaults
+type SqliteConfig struct {
+	// mode=ro, server never writes into databases
+	ReadOnly bool `json:"read_only"`
+	// immutable=1, disables locking at all, databases must not be
+	// changed while server is running
+	Immutable   bool   `json:"immutable"`
+	JournalMode string `json:"journal_mode"`
+	MmapSize    int64  `json:"mmap_size"`
+	CacheSize   int    `json:"cache_size"`
+	// milliseconds
+	BusyTimeout  int `json:"busy_timeout"`
+	MaxOpenConns int `json:"max_open_conns"`
+	// number of prepared statements kept per database, 0 disables
+	// statement reuse
+	StatementCacheSize int `json:"statement_cache_size"`
+}
+
 type Config struct {
-	HttpPort               int        `json:"http_port"`
-	HttpHost               string     `json:"http_host"`
-	HttpCorsAllowedOrigins []string   `json:"http_cors_allowed_origins"`
-	Databases              []string   `json:"databases"`
-	Storage                JsonObject `json:"storage"`
+	HttpPort               int      `json:"http_port"`
+	HttpHost               string   `json:"http_host"`
+	HttpCorsAllowedOrigins []string `json:"http_cors_allowed_origins"`
+	// SQLite file paths or PostgreSQL URLs (postgres://...). Every
+	// database keeps single release of code system, releases served
+	// side by side are imported into separate databases, release from
+	// first listed database is default one. Importing other release
+	// into listed database replaces served one, so ftdb requires
+	// -replace flag for it. Server must be restarted after import.
+	Databases []string     `json:"databases"`
+	Sqlite    SqliteConfig `json:"sqlite"`
+	// URLs of code systems (LOINC and SNOMED-CT) loaded into memory
+	// at startup, see MemoryNamespace
+	InMemory []string   `json:"in_memory"`
+	Storage  JsonObject `json:"storage"`
 }
 
 func ReadConfig(path string) (*Config, error) {
@@ -0,0 +1,347 @@
+package fhirterm
+
+import (
+	"database/sql"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const customDescendantsStmt = `
+WITH RECURSIVE t(code) AS (
+  SELECT code FROM custom_concepts WHERE system = ? AND parent_code = ?
+  UNION
+  SELECT c.code FROM custom_concepts AS c JOIN t ON c.parent_code = t.code
+  WHERE c.system = ?
+) SELECT c.rowid FROM custom_concepts AS c JOIN t ON t.code = c.code
+  WHERE c.system = ?`
+
+const customAncestorsStmt = `
+WITH RECURSIVE t(code) AS (
+  SELECT parent_code FROM custom_concepts WHERE system = ? AND code = ? AND parent_code <> ''
+  UNION
+  SELECT c.parent_code FROM custom_concepts AS c JOIN t ON c.code = t.code
+  WHERE c.system = ? AND c.parent_code <> ''
+) SELECT code FROM t`
+
+// Code systems imported from CSV (ftdb -action import-codesystem) or
+// FHIR CodeSystem resources (ftdb -action import-fhir-codesystem),
+// concepts are identified by rowid of custom_concepts table
+type CustomNamespace struct {
+	db     *DB
+	system string
+	// differs from system for aliases, like http://hl7.org/fhir/v2/0203
+	// for http://terminology.hl7.org/CodeSystem/v2-0203
+	url string
+}
+
+func NewCustomNamespace(db *DB, system string) *CustomNamespace {
+	return &CustomNamespace{db: db, system: system, url: system}
+}
+
+// Returns namespace for every imported custom code system
+// and its aliases
+func customNamespaces(db *DB) ([]*CustomNamespace, error) {
+	result := make([]*CustomNamespace, 0)
+
+	exists, err := TableExists(db, "custom_code_systems")
+	if err != nil || !exists {
+		return result, err
+	}
+
+	query := "SELECT system, system FROM custom_code_systems"
+
+	exists, err = TableExists(db, "custom_code_system_aliases")
+	if err != nil {
+		return nil, err
+	} else if exists {
+		query = query + " UNION ALL SELECT system, alias FROM custom_code_system_aliases"
+	}
+
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		ns := &CustomNamespace{db: db}
+		err = rows.Scan(&ns.system, &ns.url)
+		if err != nil {
+			return nil, err
+		}
+
+		result = append(result, ns)
+	}
+
+	return result, rows.Err()
+}
+
+func (ns *CustomNamespace) Url() string {
+	return ns.url
+}
+
+func (ns *CustomNamespace) Filter(f *NsFilter) ([]VsExpansionContains, error) {
+	ids, err := evalNsFilter(f, ns)
+	if err != nil {
+		return nil, err
+	}
+
+	return ns.idsToContains(ids, f.Text)
+}
+
+func (ns *CustomNamespace) Lookup(code string, displayLanguage string) (*NsConcept, error) {
+	var display, parent sql.NullString
+	err := ns.db.QueryRow("SELECT display, parent_code FROM custom_concepts WHERE system = ? AND code = ?",
+		ns.system, code).Scan(&display, &parent)
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	concept := &NsConcept{System: ns.url, Code: code, Display: display.String}
+	if parent.Valid {
+		concept.Properties = append(concept.Properties, NsProperty{Code: "parent", Value: parent.String})
+	}
+
+	rows, err := ns.db.Query(`SELECT property, value FROM custom_concept_properties
+                            WHERE system = ? AND code = ? ORDER BY property, value`,
+		ns.system, code)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var p NsProperty
+		err = rows.Scan(&p.Code, &p.Value)
+		if err != nil {
+			return nil, err
+		}
+
+		concept.Properties = append(concept.Properties, p)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return concept, ns.lookupDesignations(concept, displayLanguage)
+}
+
+// Designation in displayLanguage replaces concept display, exact
+// match wins over primary subtag (de for de-AT), which wins over
+// other regions of the same language (de-CH for de-AT)
+func (ns *CustomNamespace) lookupDesignations(concept *NsConcept, displayLanguage string) error {
+	rows, err := ns.db.Query(`SELECT COALESCE(language, ''), value FROM custom_concept_designations
+                            WHERE system = ? AND code = ? ORDER BY rowid`,
+		ns.system, concept.Code)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	displayLanguage = strings.ToLower(displayLanguage)
+	primary := strings.SplitN(displayLanguage, "-", 2)[0]
+	// 0 - no match, 1 - other region, 2 - primary subtag, 3 - exact
+	bestMatch := 0
+
+	for rows.Next() {
+		var d NsDesignation
+		err = rows.Scan(&d.Language, &d.Value)
+		if err != nil {
+			return err
+		}
+
+		concept.Designations = append(concept.Designations, d)
+
+		language := strings.ToLower(d.Language)
+		if displayLanguage == "" || language == "" {
+			continue
+		}
+
+		match := 0
+		if language == displayLanguage {
+			match = 3
+		} else if language == primary {
+			match = 2
+		} else if strings.SplitN(language, "-", 2)[0] == primary {
+			match = 1
+		}
+
+		if match > bestMatch {
+			concept.Display, bestMatch = d.Value, match
+		}
+	}
+
+	return rows.Err()
+}
+
+func (ns *CustomNamespace) allConcepts() (*Intset, error) {
+	return queryIntset(ns.db, "SELECT rowid FROM custom_concepts WHERE system = ?", ns.system)
+}
+
+func (ns *CustomNamespace) codesToIds(codes []string) (*Intset, error) {
+	result := NewIntset()
+
+	for _, code := range codes {
+		ids, err := queryIntset(ns.db, "SELECT rowid FROM custom_concepts WHERE system = ? AND code = ?",
+			ns.system, strings.TrimSpace(code))
+		if err != nil {
+			return nil, err
+		}
+
+		result.AddSet(ids)
+	}
+
+	return result, nil
+}
+
+func (ns *CustomNamespace) descendantIds(code string, includeSelf bool) (*Intset, error) {
+	result, err := queryIntset(ns.db, customDescendantsStmt, ns.system, code, ns.system, ns.system)
+	if err != nil {
+		return nil, err
+	}
+
+	if includeSelf {
+		self, err := ns.codesToIds([]string{code})
+		if err != nil {
+			return nil, err
+		}
+
+		result.AddSet(self)
+	}
+
+	return result, nil
+}
+
+func (ns *CustomNamespace) AncestorCodes(code string) ([]string, error) {
+	return queryStrings(ns.db, customAncestorsStmt, ns.system, code, ns.system)
+}
+
+// Matches whole value of code or property against regex
+func (ns *CustomNamespace) regexIds(query string, pattern string, args ...interface{}) (*Intset, error) {
+	re, err := regexp.Compile("^(?:" + pattern + ")$")
+	if err != nil {
+		return nil, fmt.Errorf("invalid regex in %s filter: %s", ns.system, err)
+	}
+
+	rows, err := ns.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := NewIntset()
+	for rows.Next() {
+		var id int64
+		var value string
+		err = rows.Scan(&id, &value)
+		if err != nil {
+			return nil, err
+		}
+
+		if re.MatchString(value) {
+			result.Add(id)
+		}
+	}
+
+	return result, rows.Err()
+}
+
+func (ns *CustomNamespace) propertyIds(property string, values []string) (*Intset, error) {
+	args := []interface{}{ns.system, property}
+	for _, v := range values {
+		args = append(args, strings.TrimSpace(v))
+	}
+
+	return queryIntset(ns.db, `SELECT c.rowid FROM custom_concepts AS c
+                             JOIN custom_concept_properties AS p ON p.system = c.system AND p.code = c.code
+                             WHERE c.system = ? AND p.property = ? AND p.value IN (`+sqlPlaceholders(len(values))+`)`,
+		args...)
+}
+
+func (ns *CustomNamespace) predicateToIntset(p NsPredicate) (*Intset, error) {
+	switch p.Property {
+	case "concept":
+		switch p.Op {
+		case "in":
+			if p.Concepts != nil {
+				codes := make([]string, 0, len(p.Concepts))
+				for _, c := range p.Concepts {
+					codes = append(codes, c.Code)
+				}
+
+				return ns.codesToIds(codes)
+			}
+
+			return ns.codesToIds(strings.Split(p.Value, ","))
+		case "=":
+			return ns.codesToIds([]string{p.Value})
+		case "is-a":
+			return ns.descendantIds(p.Value, true)
+		case "descendent-of":
+			return ns.descendantIds(p.Value, false)
+		case "regex":
+			return ns.regexIds("SELECT rowid, code FROM custom_concepts WHERE system = ?", p.Value, ns.system)
+		}
+	case "code":
+		if p.Op == "regex" {
+			return ns.regexIds("SELECT rowid, code FROM custom_concepts WHERE system = ?", p.Value, ns.system)
+		}
+	case "parent":
+		if p.Op == "=" {
+			return queryIntset(ns.db, "SELECT rowid FROM custom_concepts WHERE system = ? AND parent_code = ?",
+				ns.system, p.Value)
+		}
+	default:
+		switch p.Op {
+		case "=":
+			return ns.propertyIds(p.Property, []string{p.Value})
+		case "in":
+			return ns.propertyIds(p.Property, strings.Split(p.Value, ","))
+		case "regex":
+			return ns.regexIds(`SELECT c.rowid, p.value FROM custom_concepts AS c
+                          JOIN custom_concept_properties AS p ON p.system = c.system AND p.code = c.code
+                          WHERE c.system = ? AND p.property = ?`,
+				p.Value, ns.system, p.Property)
+		}
+	}
+
+	return nil, unsupportedPredicateError(ns.system, p)
+}
+
+func (ns *CustomNamespace) idsToContains(ids *Intset, text string) ([]VsExpansionContains, error) {
+	result := make([]VsExpansionContains, 0, ids.Len())
+
+	// abstract concepts can be used in filters but are not expanded
+	err := queryByIdChunks(ns.db,
+		`SELECT c.code, c.display FROM custom_concepts AS c
+     WHERE c.rowid IN (%s) AND NOT EXISTS (
+       SELECT 1 FROM custom_concept_properties AS p
+       WHERE p.system = c.system AND p.code = c.code
+       AND p.property = 'notSelectable' AND p.value = 'true')`,
+		ids.ToInt64Slice(), nil,
+		func(rows *sql.Rows) error {
+			var code, display sql.NullString
+			err := rows.Scan(&code, &display)
+
+			result = append(result, VsExpansionContains{
+				System:  ns.url,
+				Code:    code.String,
+				Display: display.String,
+			})
+
+			return err
+		})
+
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Sort(containsByCode(result))
+	return filterContainsByText(result, text), nil
+}
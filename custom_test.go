@@ -0,0 +1,147 @@
+package fhirterm
+
+import (
+	"github.com/stretchr/testify/assert"
+	"sort"
+	"testing"
+)
+
+// LAB is parent of CHEM, CHEM is parent of GLU and CHOL. LAB is not
+// selectable, OTHER belongs to another code system.
+var customStmts = []string{
+	"CREATE TABLE custom_code_systems (system text, name text)",
+	"INSERT INTO custom_code_systems VALUES ('http://example.org/lab-codes', 'Lab codes')",
+	"CREATE TABLE custom_code_system_aliases (alias text, system text)",
+	"INSERT INTO custom_code_system_aliases VALUES ('http://example.org/lab', 'http://example.org/lab-codes')",
+	"CREATE TABLE custom_concepts (system text, code text, display text, parent_code text)",
+	`INSERT INTO custom_concepts VALUES
+   ('http://example.org/lab-codes', 'LAB', 'Laboratory', NULL),
+   ('http://example.org/lab-codes', 'CHEM', 'Chemistry', 'LAB'),
+   ('http://example.org/lab-codes', 'GLU', 'Glucose', 'CHEM'),
+   ('http://example.org/lab-codes', 'CHOL', 'Cholesterol', 'CHEM'),
+   ('http://example.org/lab-codes', 'HGB', 'Hemoglobin', 'LAB'),
+   ('http://example.org/other', 'OTHER', 'Other', 'LAB')`,
+	"CREATE TABLE custom_concept_properties (system text, code text, property text, value text)",
+	`INSERT INTO custom_concept_properties VALUES
+   ('http://example.org/lab-codes', 'LAB', 'notSelectable', 'true'),
+   ('http://example.org/lab-codes', 'GLU', 'specimen', 'Serum'),
+   ('http://example.org/lab-codes', 'CHOL', 'specimen', 'Plasma'),
+   ('http://example.org/lab-codes', 'HGB', 'specimen', 'Blood'),
+   ('http://example.org/other', 'OTHER', 'specimen', 'Serum')`,
+	"CREATE TABLE custom_concept_designations (system text, code text, language text, use text, value text)",
+	`INSERT INTO custom_concept_designations VALUES
+   ('http://example.org/lab-codes', 'GLU', 'de', NULL, 'Glukose'),
+   ('http://example.org/lab-codes', 'GLU', 'de-AT', NULL, 'Blutzucker'),
+   ('http://example.org/lab-codes', 'GLU', 'fr', NULL, 'Glucose (fr)')`,
+}
+
+func Test_CustomNamespaces(t *testing.T) {
+	assert := assert.New(t)
+	db, closeDb := openTestDb(t, customStmts...)
+	defer closeDb()
+
+	namespaces, err := customNamespaces(db)
+	assert.Nil(err)
+
+	urls := make([]string, 0, len(namespaces))
+	for _, ns := range namespaces {
+		urls = append(urls, ns.Url())
+		assert.Equal("http://example.org/lab-codes", ns.system)
+	}
+
+	sort.Strings(urls)
+	assert.Equal([]string{"http://example.org/lab", "http://example.org/lab-codes"}, urls)
+}
+
+func Test_CustomFilter(t *testing.T) {
+	assert := assert.New(t)
+	db, closeDb := openTestDb(t, customStmts...)
+	defer closeDb()
+
+	ns := NewCustomNamespace(db, "http://example.org/lab-codes")
+
+	tests := []struct {
+		include NsPredicate
+		exclude []NsPredicate
+		codes   []string
+	}{
+		{NsPredicate{}, nil, []string{"CHEM", "CHOL", "GLU", "HGB"}},
+		{NsPredicate{Property: "concept", Op: "=", Value: "GLU"}, nil, []string{"GLU"}},
+		{NsPredicate{Property: "concept", Op: "=", Value: "OTHER"}, nil, []string{}},
+		{NsPredicate{Property: "concept", Op: "in", Value: "GLU, HGB,XXX"}, nil, []string{"GLU", "HGB"}},
+		{NsPredicate{Property: "concept", Op: "in", Concepts: []VsComposeIncludeConcept{{Code: "CHOL"}}}, nil,
+			[]string{"CHOL"}},
+		{NsPredicate{Property: "concept", Op: "is-a", Value: "CHEM"}, nil, []string{"CHEM", "CHOL", "GLU"}},
+		{NsPredicate{Property: "concept", Op: "is-a", Value: "LAB"}, nil, []string{"CHEM", "CHOL", "GLU", "HGB"}},
+		{NsPredicate{Property: "concept", Op: "descendent-of", Value: "CHEM"}, nil, []string{"CHOL", "GLU"}},
+		{NsPredicate{Property: "concept", Op: "regex", Value: "CH.*"}, nil, []string{"CHEM", "CHOL"}},
+		{NsPredicate{Property: "code", Op: "regex", Value: "G.U"}, nil, []string{"GLU"}},
+		{NsPredicate{Property: "code", Op: "regex", Value: "H"}, nil, []string{}},
+		{NsPredicate{Property: "parent", Op: "=", Value: "LAB"}, nil, []string{"CHEM", "HGB"}},
+		{NsPredicate{Property: "specimen", Op: "=", Value: "Serum"}, nil, []string{"GLU"}},
+		{NsPredicate{Property: "specimen", Op: "in", Value: "Serum, Plasma"}, nil, []string{"CHOL", "GLU"}},
+		{NsPredicate{Property: "specimen", Op: "regex", Value: "B.*|P.*"}, nil, []string{"CHOL", "HGB"}},
+		{NsPredicate{Property: "concept", Op: "is-a", Value: "CHEM"},
+			[]NsPredicate{{Property: "specimen", Op: "=", Value: "Plasma"}}, []string{"CHEM", "GLU"}},
+	}
+
+	for _, test := range tests {
+		codes, err := filterCodes(ns, test.include, test.exclude...)
+		assert.Nil(err)
+		assert.Equal(test.codes, codes, "%+v", test.include)
+	}
+
+	_, err := filterCodes(ns, NsPredicate{Property: "parent", Op: "is-a", Value: "LAB"})
+	assert.EqualError(err, "http://example.org/lab-codes does not support filter with property 'parent' and op 'is-a'")
+
+	_, err = filterCodes(ns, NsPredicate{Property: "code", Op: "regex", Value: "("})
+	assert.NotNil(err)
+
+	contains, err := ns.Filter(&NsFilter{Include: [][]NsPredicate{{{Property: "concept", Op: "=", Value: "GLU"}}}})
+	assert.Nil(err)
+	assert.Equal([]VsExpansionContains{{System: "http://example.org/lab-codes", Code: "GLU", Display: "Glucose"}},
+		contains)
+
+	ancestors, err := ns.AncestorCodes("GLU")
+	assert.Nil(err)
+	sort.Strings(ancestors)
+	assert.Equal([]string{"CHEM", "LAB"}, ancestors)
+}
+
+func Test_CustomLookup(t *testing.T) {
+	assert := assert.New(t)
+	db, closeDb := openTestDb(t, customStmts...)
+	defer closeDb()
+
+	ns := NewCustomNamespace(db, "http://example.org/lab-codes")
+
+	concept, err := ns.Lookup("GLU", "")
+	assert.Nil(err)
+	assert.Equal("Glucose", concept.Display)
+	assert.Equal([]NsProperty{{Code: "parent", Value: "CHEM"}, {Code: "specimen", Value: "Serum"}},
+		concept.Properties)
+	assert.Equal([]NsDesignation{{"de", "Glukose"}, {"de-AT", "Blutzucker"}, {"fr", "Glucose (fr)"}},
+		concept.Designations)
+
+	for language, display := range map[string]string{
+		"":      "Glucose",
+		"en":    "Glucose",
+		"de":    "Glukose",
+		"de-DE": "Glukose",
+		"de-at": "Blutzucker",
+		"fr-CA": "Glucose (fr)",
+	} {
+		concept, err := ns.Lookup("GLU", language)
+		assert.Nil(err)
+		assert.Equal(display, concept.Display, language)
+	}
+
+	concept, err = ns.Lookup("LAB", "")
+	assert.Nil(err)
+	assert.Equal([]NsProperty{{Code: "notSelectable", Value: "true"}}, concept.Properties,
+		"concept without parent has no parent property")
+
+	concept, err = ns.Lookup("OTHER", "")
+	assert.Nil(err)
+	assert.Nil(concept)
+}
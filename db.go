@@ -1,57 +1,319 @@
 package fhirterm
 
 import (
+	"context"
 	"database/sql"
+	"database/sql/driver"
 	"fmt"
-	_ "github.com/mattn/go-sqlite3"
+	"github.com/mattn/go-sqlite3"
 	"log"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
 )
 
-var globalDb *sql.DB
+// Database handle used by namespaces and importers. Queries are
+// executed with prepared statements which are reused when statement
+// cache is enabled.
+type DB struct {
+	*sql.DB
+	Dialect *Dialect
+	// SQLite database file opened for import, imports replace it
+	// with imported copy
+	File string
+	// Allows import to replace release of code system recorded in
+	// database with release of different version
+	ReplaceRelease bool
+	stmts          *stmtCache
+}
 
-func GetDb() *sql.DB {
-	return globalDb
+type stmtCache struct {
+	size  int
+	mu    sync.Mutex
+	stmts map[string]*sql.Stmt
 }
 
-func OpenDb(cfg *Config) error {
-	if globalDb != nil {
-		return fmt.Errorf("DB connection already opened")
+// Returns nil statement when cache is disabled or full, query is
+// executed without preparing then
+func (db *DB) prepared(query string) (*sql.Stmt, error) {
+	c := db.stmts
+	if c == nil {
+		return nil, nil
+	}
+
+	c.mu.Lock()
+	stmt, found := c.stmts[query]
+	full := len(c.stmts) >= c.size
+	c.mu.Unlock()
+
+	if found || full {
+		return stmt, nil
 	}
 
-	dbFile := cfg.Databases[0]
-	err := OpenDbSpecificFile(dbFile)
+	// preparing may wait for free connection, so it's done without
+	// holding the lock
+	stmt, err := db.DB.Prepare(query)
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if cached, found := c.stmts[query]; found {
+		stmt.Close()
+		return cached, nil
 	}
 
-	globalDb.SetMaxOpenConns(100)
+	c.stmts[query] = stmt
+	return stmt, nil
+}
+
+func (db *DB) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	stmt, err := db.prepared(query)
+	if err != nil || stmt == nil {
+		return db.DB.Query(query, args...)
+	}
 
-	return nil
+	return stmt.Query(args...)
 }
 
-func OpenDbSpecificFile(dbFile string) error {
-	var err error
-	globalDb, err = sql.Open("sqlite3", dbFile)
+func (db *DB) QueryRow(query string, args ...interface{}) *sql.Row {
+	stmt, err := db.prepared(query)
+	if err != nil || stmt == nil {
+		return db.DB.QueryRow(query, args...)
+	}
+
+	return stmt.QueryRow(args...)
+}
+
+func (db *DB) Close() error {
+	if db.stmts != nil {
+		db.stmts.mu.Lock()
+		for _, stmt := range db.stmts.stmts {
+			stmt.Close()
+		}
+		db.stmts.stmts = make(map[string]*sql.Stmt)
+		db.stmts.mu.Unlock()
+	}
+
+	return db.DB.Close()
+}
+
+// Database served by Server, code systems are routed to databases
+// containing them. Path is SQLite file path or PostgreSQL URL.
+type Database struct {
+	Path string
+	Db   *DB
+}
+
+// Path with password removed from PostgreSQL URL, used in logs
+func (d Database) Name() string {
+	return databaseName(d.Path)
+}
+
+func databaseName(dsn string) string {
+	if !isPostgresDsn(dsn) {
+		return dsn
+	}
 
+	u, err := url.Parse(dsn)
 	if err != nil {
-		log.Fatalf("Failed to open SQLite Database %s: %s", dbFile, err)
-		return err
+		return "PostgreSQL database"
 	}
 
-	log.Printf("Opened SQLite Database %s", dbFile)
-	return nil
+	return u.Redacted()
 }
 
-func CloseDb() error {
-	err := globalDb.Close()
+// Opens database for import, SQLite file is created if missing
+func OpenDatabase(dsn string) (*DB, error) {
+	if isPostgresDsn(dsn) {
+		db, err := openPostgres(dsn)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to open PostgreSQL Database %s: %s", databaseName(dsn), err)
+		}
 
+		log.Printf("Opened PostgreSQL Database %s", databaseName(dsn))
+		return db, nil
+	}
+
+	db, err := sql.Open("sqlite3", dsn)
 	if err != nil {
-		log.Printf("Error when closing database: %s", err)
+		return nil, fmt.Errorf("Failed to open SQLite Database %s: %s", dsn, err)
+	}
+
+	log.Printf("Opened SQLite Database %s", dsn)
+	return &DB{DB: db, Dialect: SqliteDialect, File: dsn}, nil
+}
+
+var sqliteJournalModes = map[string]bool{
+	"delete": true, "truncate": true, "persist": true, "memory": true, "wal": true, "off": true,
+}
+
+func sqliteDsn(dbFile string, cfg SqliteConfig) string {
+	params := url.Values{}
+
+	if cfg.Immutable {
+		params.Set("immutable", "1")
+	}
+
+	if cfg.ReadOnly || cfg.Immutable {
+		params.Set("mode", "ro")
+	}
+
+	if len(params) == 0 {
+		return dbFile
+	}
+
+	return "file:" + (&url.URL{Path: dbFile}).EscapedPath() + "?" + params.Encode()
+}
+
+func sqlitePragmas(cfg SqliteConfig) ([]string, error) {
+	pragmas := make([]string, 0)
+
+	if cfg.JournalMode != "" {
+		mode := strings.ToLower(cfg.JournalMode)
+		if !sqliteJournalModes[mode] {
+			return nil, fmt.Errorf("unknown SQLite journal mode: %s", cfg.JournalMode)
+		}
+
+		pragmas = append(pragmas, "PRAGMA journal_mode = "+mode)
+	}
+
+	if cfg.MmapSize > 0 {
+		pragmas = append(pragmas, fmt.Sprintf("PRAGMA mmap_size = %d", cfg.MmapSize))
+	}
+
+	// negative value is size in KiB, positive is number of pages
+	if cfg.CacheSize != 0 {
+		pragmas = append(pragmas, fmt.Sprintf("PRAGMA cache_size = %d", cfg.CacheSize))
+	}
+
+	if cfg.BusyTimeout > 0 {
+		pragmas = append(pragmas, fmt.Sprintf("PRAGMA busy_timeout = %d", cfg.BusyTimeout))
+	}
+
+	return pragmas, nil
+}
+
+// Pragmas are per connection, so they are executed for every
+// connection opened by the pool
+type sqliteConnector struct {
+	dsn    string
+	driver *sqlite3.SQLiteDriver
+}
+
+func (c *sqliteConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	return c.driver.Open(c.dsn)
+}
+
+func (c *sqliteConnector) Driver() driver.Driver {
+	return c.driver
+}
+
+// Opens database for serving with settings from sqlite section
+// of config, only connection limit and statement cache are used
+// for PostgreSQL
+func OpenServingDatabase(dsn string, cfg SqliteConfig) (*DB, error) {
+	var db *DB
+	var err error
+
+	if isPostgresDsn(dsn) {
+		db, err = OpenDatabase(dsn)
 	} else {
-		log.Print("Closed database file")
+		db, err = openServingSqlite(dsn, cfg)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	maxOpenConns := cfg.MaxOpenConns
+	if maxOpenConns <= 0 {
+		maxOpenConns = 100
+	}
+	db.SetMaxOpenConns(maxOpenConns)
+
+	if cfg.StatementCacheSize > 0 {
+		db.stmts = &stmtCache{size: cfg.StatementCacheSize, stmts: make(map[string]*sql.Stmt)}
+	}
+
+	return db, nil
+}
+
+func openServingSqlite(dbFile string, cfg SqliteConfig) (*DB, error) {
+	pragmas, err := sqlitePragmas(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	connector := &sqliteConnector{
+		dsn: sqliteDsn(dbFile, cfg),
+		driver: &sqlite3.SQLiteDriver{
+			ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+				for _, p := range pragmas {
+					if _, err := conn.Exec(p, nil); err != nil {
+						return fmt.Errorf("%s failed: %s", p, err)
+					}
+				}
+
+				return nil
+			},
+		},
+	}
+
+	db := sql.OpenDB(connector)
+
+	// pragmas and open mode are checked by first connection
+	err = db.Ping()
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("Failed to open SQLite Database %s: %s", dbFile, err)
 	}
 
-	globalDb = nil
+	log.Printf("Opened SQLite Database %s (%s)", dbFile, strings.Join(append([]string{connector.dsn}, pragmas...), ", "))
+	return &DB{DB: db, Dialect: SqliteDialect}, nil
+}
+
+// Opens every database listed in config, all of them must exist
+func OpenDatabases(dbFiles []string, cfg SqliteConfig) ([]Database, error) {
+	if len(dbFiles) == 0 {
+		return nil, fmt.Errorf("No databases specified in config")
+	}
+
+	result := make([]Database, 0, len(dbFiles))
+
+	for _, dbFile := range dbFiles {
+		// sqlite creates missing files, so typo in config would
+		// end up with empty database
+		if _, err := os.Stat(dbFile); err != nil && !isPostgresDsn(dbFile) {
+			CloseDatabases(result)
+			return nil, fmt.Errorf("Cannot open SQLite Database %s: %s", dbFile, err)
+		}
+
+		db, err := OpenServingDatabase(dbFile, cfg)
+		if err != nil {
+			CloseDatabases(result)
+			return nil, err
+		}
+
+		result = append(result, Database{Path: dbFile, Db: db})
+	}
+
+	return result, nil
+}
+
+func CloseDatabases(dbs []Database) error {
+	var err error
+
+	for _, d := range dbs {
+		if e := d.Db.Close(); e != nil {
+			log.Printf("Error when closing database %s: %s", d.Name(), e)
+			err = e
+		} else {
+			log.Printf("Closed database %s", d.Name())
+		}
+	}
 
 	return err
 }
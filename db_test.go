@@ -0,0 +1,85 @@
+package fhirterm
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Creates SQLite database in temporary directory, statements
+// create and fill tables used by test
+func openTestDb(t *testing.T, stmts ...string) (*DB, func()) {
+	dir, err := ioutil.TempDir("", "fhirterm")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := OpenDatabase(filepath.Join(dir, "test.db3"))
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+
+	closeDb := func() {
+		db.Close()
+		os.RemoveAll(dir)
+	}
+
+	for _, stmt := range stmts {
+		if _, err = db.Exec(stmt); err != nil {
+			closeDb()
+			t.Fatalf("%s: %s", stmt, err)
+		}
+	}
+
+	return db, closeDb
+}
+
+func Test_OpenServingDatabase(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "fhirterm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "test db.db3")
+	importDb, err := OpenDatabase(path)
+	assert.Nil(err)
+	_, err = importDb.Exec("CREATE TABLE t (v integer); INSERT INTO t VALUES (1), (2)")
+	assert.Nil(err)
+	importDb.Close()
+
+	_, err = OpenServingDatabase(path, SqliteConfig{JournalMode: "fast"})
+	assert.EqualError(err, "unknown SQLite journal mode: fast")
+
+	db, err := OpenServingDatabase(path, SqliteConfig{
+		ReadOnly:           true,
+		MmapSize:           1 << 20,
+		CacheSize:          -2000,
+		BusyTimeout:        1000,
+		StatementCacheSize: 1,
+	})
+	assert.Nil(err)
+	defer db.Close()
+
+	var mmapSize int64
+	assert.Nil(db.QueryRow("PRAGMA mmap_size").Scan(&mmapSize))
+	assert.Equal(int64(1<<20), mmapSize)
+
+	// cache is full after first query, second one is not prepared
+	var sum int
+	for i := 0; i < 2; i++ {
+		assert.Nil(db.QueryRow("SELECT SUM(v) FROM t WHERE v > ?", 0).Scan(&sum))
+		assert.Equal(3, sum)
+	}
+	assert.Nil(db.QueryRow("SELECT COUNT(*) FROM t").Scan(&sum))
+	assert.Equal(2, sum)
+	assert.Equal(1, len(db.stmts.stmts))
+
+	_, err = db.Exec("INSERT INTO t VALUES (3)")
+	assert.NotNil(err)
+}
@@ -0,0 +1,205 @@
+package fhirterm
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"github.com/lib/pq"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// SQL differences of supported databases. Queries and DDL are
+// written for SQLite with ? placeholders, PostgreSQL connections
+// rewrite them (see postgresQuery), so only catalog queries and
+// import settings differ.
+type Dialect struct {
+	Name string
+	// lists names of tables in "name" column
+	TablesQuery string
+	// lists names of columns of table bound as parameter in "name"
+	// column
+	ColumnsQuery string
+	// definition of rowid column appended to tables which rows are
+	// identified by rowid, SQLite provides implicit one
+	RowidColumn string
+	// import loads tables into staging schema and moves them to
+	// served schema after commit, so served tables are locked only
+	// for short time
+	ImportsIntoStagingSchema bool
+	// failed statement aborts whole transaction, so import can't
+	// continue after failed row
+	AbortsTransactionOnError bool
+}
+
+var SqliteDialect = &Dialect{
+	Name:         "sqlite3",
+	TablesQuery:  "SELECT name FROM sqlite_master WHERE type = 'table'",
+	ColumnsQuery: "SELECT name FROM pragma_table_info(?)",
+}
+
+var PostgresDialect = &Dialect{
+	Name: "postgres",
+	// tables and columns are looked up in search path, it includes
+	// staging schema during import
+	TablesQuery: `SELECT DISTINCT table_name AS name FROM information_schema.tables
+                WHERE table_schema = ANY(current_schemas(false))`,
+	ColumnsQuery: `SELECT attname AS name FROM pg_attribute
+                 WHERE attrelid = to_regclass(?) AND attnum > 0 AND NOT attisdropped`,
+	RowidColumn:              ",\n  rowid bigserial",
+	AbortsTransactionOnError: true,
+	ImportsIntoStagingSchema: true,
+}
+
+// Databases are listed in config and passed to ftdb as SQLite file
+// paths or PostgreSQL URLs
+func isPostgresDsn(dsn string) bool {
+	return strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://")
+}
+
+func TableExists(db *DB, name string) (bool, error) {
+	var exists bool
+	err := db.QueryRow("SELECT COUNT(*) > 0 FROM ("+db.Dialect.TablesQuery+") AS t WHERE name = ?", name).
+		Scan(&exists)
+
+	return exists, err
+}
+
+// SNOMED-CT ids don't fit into PostgreSQL integer, SQLite has
+// no separate boolean and blob types
+var postgresDdlTypes = []struct {
+	re          *regexp.Regexp
+	replacement string
+}{
+	{regexp.MustCompile(`(?i)\binteger\b`), "bigint"},
+	{regexp.MustCompile(`(?i)\bboolean\b`), "smallint"},
+	{regexp.MustCompile(`(?i)\bblob\b`), "bytea"},
+}
+
+var createTableRegexp = regexp.MustCompile(`(?i)^\s*CREATE\s+TABLE\b`)
+
+// Values cast to integer are SNOMED-CT and RxNorm ids, PostgreSQL
+// integer is 32 bit
+var castToIntegerRegexp = regexp.MustCompile(`(?i)(\bAS\s+)integer(\s*\))`)
+
+// Replaces ? placeholders with $1, $2..., placeholders inside
+// quoted literals and identifiers are left as is
+func postgresRebind(query string) string {
+	if !strings.Contains(query, "?") {
+		return query
+	}
+
+	var buf strings.Builder
+	var quote rune
+	n := 0
+
+	for _, c := range query {
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == '?':
+			n++
+			buf.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+
+		buf.WriteRune(c)
+	}
+
+	return buf.String()
+}
+
+func postgresQuery(query string) string {
+	if createTableRegexp.MatchString(query) {
+		for _, t := range postgresDdlTypes {
+			query = t.re.ReplaceAllString(query, t.replacement)
+		}
+	}
+
+	query = castToIntegerRegexp.ReplaceAllString(query, "${1}bigint${2}")
+	return postgresRebind(query)
+}
+
+// Connection of lib/pq rewriting every statement with postgresQuery
+type postgresConn struct {
+	driver.Conn
+}
+
+func (c *postgresConn) Prepare(query string) (driver.Stmt, error) {
+	return c.Conn.Prepare(postgresQuery(query))
+}
+
+func (c *postgresConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	if p, ok := c.Conn.(driver.ConnPrepareContext); ok {
+		return p.PrepareContext(ctx, postgresQuery(query))
+	}
+
+	return c.Prepare(query)
+}
+
+func (c *postgresConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	if q, ok := c.Conn.(driver.QueryerContext); ok {
+		return q.QueryContext(ctx, postgresQuery(query), args)
+	}
+
+	return nil, driver.ErrSkip
+}
+
+func (c *postgresConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	if e, ok := c.Conn.(driver.ExecerContext); ok {
+		return e.ExecContext(ctx, postgresQuery(query), args)
+	}
+
+	return nil, driver.ErrSkip
+}
+
+func (c *postgresConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if b, ok := c.Conn.(driver.ConnBeginTx); ok {
+		return b.BeginTx(ctx, opts)
+	}
+
+	return c.Conn.Begin()
+}
+
+func (c *postgresConn) Ping(ctx context.Context) error {
+	if p, ok := c.Conn.(driver.Pinger); ok {
+		return p.Ping(ctx)
+	}
+
+	return nil
+}
+
+type postgresConnector struct {
+	*pq.Connector
+}
+
+func (c postgresConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.Connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &postgresConn{conn}, nil
+}
+
+func openPostgres(dsn string) (*DB, error) {
+	connector, err := pq.NewConnector(dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	db := sql.OpenDB(postgresConnector{connector})
+
+	err = db.Ping()
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &DB{DB: db, Dialect: PostgresDialect}, nil
+}
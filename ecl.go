@@ -0,0 +1,470 @@
+package fhirterm
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Subset of SNOMED-CT Expression Constraint Language: hierarchy
+// operators (<, <<, <!, >, >>, >!), refset membership (^), AND/OR/MINUS
+// and attribute refinements without groups and cardinalities.
+
+type eclEvaluator interface {
+	allConcepts() (*Intset, error)
+	descendants(id int64) ([]int64, error)
+	ancestors(id int64) ([]int64, error)
+	children(id int64) ([]int64, error)
+	parents(id int64) ([]int64, error)
+	refsetMembers(refsetId int64) ([]int64, error)
+	// destinations == nil means attribute with any value
+	attributeSources(typeIds *Intset, destinations *Intset, negate bool) (*Intset, error)
+}
+
+type eclExpr interface {
+	eval(e eclEvaluator) (*Intset, error)
+}
+
+type eclConcept struct {
+	id int64
+}
+
+type eclAny struct{}
+
+type eclConstraint struct {
+	op    string
+	focus eclExpr
+}
+
+type eclBinary struct {
+	op    string
+	left  eclExpr
+	right eclExpr
+}
+
+type eclRefined struct {
+	focus      eclExpr
+	refinement eclExpr
+}
+
+type eclAttribute struct {
+	name   eclExpr
+	negate bool
+	value  eclExpr
+}
+
+func (c eclConcept) eval(e eclEvaluator) (*Intset, error) {
+	return NewIntsetFromSlice([]int64{c.id}), nil
+}
+
+func (a eclAny) eval(e eclEvaluator) (*Intset, error) {
+	return e.allConcepts()
+}
+
+func (c eclConstraint) eval(e eclEvaluator) (*Intset, error) {
+	// descendants or ancestors of any concept are all concepts
+	if _, isAny := c.focus.(eclAny); isAny && c.op != "^" {
+		return e.allConcepts()
+	}
+
+	focus, err := c.focus.eval(e)
+	if err != nil {
+		return nil, err
+	}
+
+	var related func(id int64) ([]int64, error)
+	includeSelf := false
+
+	switch c.op {
+	case "<":
+		related = e.descendants
+	case "<<":
+		related, includeSelf = e.descendants, true
+	case "<!":
+		related = e.children
+	case ">":
+		related = e.ancestors
+	case ">>":
+		related, includeSelf = e.ancestors, true
+	case ">!":
+		related = e.parents
+	case "^":
+		related = e.refsetMembers
+	default:
+		return nil, fmt.Errorf("unsupported ECL operator: %s", c.op)
+	}
+
+	result := NewIntset()
+	for id := range focus.M {
+		ids, err := related(id)
+		if err != nil {
+			return nil, err
+		}
+
+		result.AddSlice(ids)
+
+		if includeSelf {
+			result.Add(id)
+		}
+	}
+
+	return result, nil
+}
+
+func (b eclBinary) eval(e eclEvaluator) (*Intset, error) {
+	left, err := b.left.eval(e)
+	if err != nil {
+		return nil, err
+	}
+
+	right, err := b.right.eval(e)
+	if err != nil {
+		return nil, err
+	}
+
+	switch b.op {
+	case "AND":
+		return left.Intersect(right), nil
+	case "OR":
+		return left.Union(right), nil
+	case "MINUS":
+		return left.Difference(right), nil
+	}
+
+	return nil, fmt.Errorf("unsupported ECL operator: %s", b.op)
+}
+
+func (r eclRefined) eval(e eclEvaluator) (*Intset, error) {
+	focus, err := r.focus.eval(e)
+	if err != nil {
+		return nil, err
+	}
+
+	refinement, err := r.refinement.eval(e)
+	if err != nil {
+		return nil, err
+	}
+
+	return focus.Intersect(refinement), nil
+}
+
+func (a eclAttribute) eval(e eclEvaluator) (*Intset, error) {
+	typeIds, err := a.name.eval(e)
+	if err != nil {
+		return nil, err
+	}
+
+	var destinations *Intset
+	if _, isAny := a.value.(eclAny); !isAny {
+		destinations, err = a.value.eval(e)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return e.attributeSources(typeIds, destinations, a.negate)
+}
+
+type eclToken struct {
+	kind  string // "op", "id", "kw" or "term"
+	value string
+}
+
+var eclOperators = []string{"<<", "<!", ">>", ">!", "!=", "<", ">", "^", ":", "=", "(", ")", "*", ",", "{", "}"}
+
+func tokenizeEcl(s string) ([]eclToken, error) {
+	tokens := make([]eclToken, 0)
+	runes := []rune(s)
+	i := 0
+
+	for i < len(runes) {
+		r := runes[i]
+
+		if unicode.IsSpace(r) {
+			i++
+			continue
+		}
+
+		if r == '|' {
+			end := strings.IndexRune(string(runes[i+1:]), '|')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated term in ECL expression")
+			}
+
+			term := []rune(string(runes[i+1:])[:end])
+			tokens = append(tokens, eclToken{"term", string(term)})
+			i += len(term) + 2
+			continue
+		}
+
+		if unicode.IsDigit(r) {
+			start := i
+			for i < len(runes) && unicode.IsDigit(runes[i]) {
+				i++
+			}
+
+			tokens = append(tokens, eclToken{"id", string(runes[start:i])})
+			continue
+		}
+
+		if unicode.IsLetter(r) {
+			start := i
+			for i < len(runes) && unicode.IsLetter(runes[i]) {
+				i++
+			}
+
+			word := strings.ToUpper(string(runes[start:i]))
+			if word != "AND" && word != "OR" && word != "MINUS" {
+				return nil, fmt.Errorf("unexpected word in ECL expression: %s", string(runes[start:i]))
+			}
+
+			tokens = append(tokens, eclToken{"kw", word})
+			continue
+		}
+
+		matched := false
+		for _, op := range eclOperators {
+			if strings.HasPrefix(string(runes[i:]), op) {
+				tokens = append(tokens, eclToken{"op", op})
+				i += len([]rune(op))
+				matched = true
+				break
+			}
+		}
+
+		if !matched {
+			return nil, fmt.Errorf("unexpected character in ECL expression: %c", r)
+		}
+	}
+
+	return tokens, nil
+}
+
+type eclParser struct {
+	tokens []eclToken
+	pos    int
+}
+
+func (p *eclParser) peek() *eclToken {
+	if p.pos < len(p.tokens) {
+		return &p.tokens[p.pos]
+	}
+
+	return nil
+}
+
+func (p *eclParser) accept(kind string, value string) bool {
+	t := p.peek()
+	if t != nil && t.kind == kind && t.value == value {
+		p.pos++
+		return true
+	}
+
+	return false
+}
+
+func (p *eclParser) expect(kind string, value string) error {
+	if !p.accept(kind, value) {
+		return p.unexpected()
+	}
+
+	return nil
+}
+
+func (p *eclParser) unexpected() error {
+	t := p.peek()
+	if t == nil {
+		return fmt.Errorf("unexpected end of ECL expression")
+	}
+
+	return fmt.Errorf("unexpected '%s' in ECL expression", t.value)
+}
+
+// binary operators are left-associative and have equal precedence,
+// comma is an alias for AND
+func (p *eclParser) binaryOp() (string, bool) {
+	t := p.peek()
+	if t == nil {
+		return "", false
+	}
+
+	if t.kind == "kw" {
+		p.pos++
+		return t.value, true
+	}
+
+	if t.kind == "op" && t.value == "," {
+		p.pos++
+		return "AND", true
+	}
+
+	return "", false
+}
+
+func (p *eclParser) parseExpression() (eclExpr, error) {
+	left, err := p.parseRefined()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		op, found := p.binaryOp()
+		if !found {
+			return left, nil
+		}
+
+		right, err := p.parseRefined()
+		if err != nil {
+			return nil, err
+		}
+
+		left = eclBinary{op: op, left: left, right: right}
+	}
+}
+
+func (p *eclParser) parseRefined() (eclExpr, error) {
+	focus, err := p.parseFocus()
+	if err != nil {
+		return nil, err
+	}
+
+	if !p.accept("op", ":") {
+		return focus, nil
+	}
+
+	refinement, err := p.parseRefinement()
+	if err != nil {
+		return nil, err
+	}
+
+	return eclRefined{focus: focus, refinement: refinement}, nil
+}
+
+func (p *eclParser) parseRefinement() (eclExpr, error) {
+	left, err := p.parseAttribute()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		t := p.peek()
+		// MINUS after refinement belongs to enclosing expression
+		if t == nil || (t.kind == "kw" && t.value == "MINUS") {
+			return left, nil
+		}
+
+		op, found := p.binaryOp()
+		if !found {
+			return left, nil
+		}
+
+		right, err := p.parseAttribute()
+		if err != nil {
+			return nil, err
+		}
+
+		left = eclBinary{op: op, left: left, right: right}
+	}
+}
+
+func (p *eclParser) parseAttribute() (eclExpr, error) {
+	if p.accept("op", "(") {
+		refinement, err := p.parseRefinement()
+		if err != nil {
+			return nil, err
+		}
+
+		return refinement, p.expect("op", ")")
+	}
+
+	if t := p.peek(); t != nil && t.value == "{" {
+		return nil, fmt.Errorf("attribute groups are not supported in ECL expressions")
+	}
+
+	name, err := p.parseFocus()
+	if err != nil {
+		return nil, err
+	}
+
+	negate := false
+	if p.accept("op", "!=") {
+		negate = true
+	} else if err := p.expect("op", "="); err != nil {
+		return nil, err
+	}
+
+	value, err := p.parseFocus()
+	if err != nil {
+		return nil, err
+	}
+
+	return eclAttribute{name: name, negate: negate, value: value}, nil
+}
+
+func (p *eclParser) parseFocus() (eclExpr, error) {
+	t := p.peek()
+	if t == nil {
+		return nil, p.unexpected()
+	}
+
+	if t.kind == "op" {
+		switch t.value {
+		case "<", "<<", "<!", ">", ">>", ">!", "^":
+			p.pos++
+			focus, err := p.parseFocus()
+			if err != nil {
+				return nil, err
+			}
+
+			return eclConstraint{op: t.value, focus: focus}, nil
+		case "*":
+			p.pos++
+			return eclAny{}, nil
+		case "(":
+			p.pos++
+			expr, err := p.parseExpression()
+			if err != nil {
+				return nil, err
+			}
+
+			return expr, p.expect("op", ")")
+		}
+	}
+
+	if t.kind == "id" {
+		p.pos++
+		id, err := strconv.ParseInt(t.value, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid concept id in ECL expression: %s", t.value)
+		}
+
+		// optional term is ignored
+		if nt := p.peek(); nt != nil && nt.kind == "term" {
+			p.pos++
+		}
+
+		return eclConcept{id: id}, nil
+	}
+
+	return nil, p.unexpected()
+}
+
+func parseEcl(s string) (eclExpr, error) {
+	tokens, err := tokenizeEcl(s)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &eclParser{tokens: tokens}
+	expr, err := p.parseExpression()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.peek() != nil {
+		return nil, p.unexpected()
+	}
+
+	return expr, nil
+}
@@ -0,0 +1,143 @@
+package fhirterm
+
+import (
+	"github.com/stretchr/testify/assert"
+	"sort"
+	"testing"
+)
+
+// 1 <- 2 <- 4 <- 5
+// 1 <- 3
+type fakeEclEvaluator struct{}
+
+var fakeEclParents = map[int64][]int64{2: {1}, 3: {1}, 4: {2}, 5: {4}}
+
+// attribute 60 relationships, source => destination
+var fakeEclAttributes = map[int64]int64{4: 3, 5: 2}
+
+func (e fakeEclEvaluator) allConcepts() (*Intset, error) {
+	return NewIntsetFromSlice([]int64{1, 2, 3, 4, 5}), nil
+}
+
+func (e fakeEclEvaluator) parents(id int64) ([]int64, error) {
+	return fakeEclParents[id], nil
+}
+
+func (e fakeEclEvaluator) children(id int64) ([]int64, error) {
+	result := make([]int64, 0)
+	for child, parents := range fakeEclParents {
+		for _, p := range parents {
+			if p == id {
+				result = append(result, child)
+			}
+		}
+	}
+
+	return result, nil
+}
+
+func (e fakeEclEvaluator) ancestors(id int64) ([]int64, error) {
+	result := make([]int64, 0)
+	for _, p := range fakeEclParents[id] {
+		result = append(result, p)
+		a, _ := e.ancestors(p)
+		result = append(result, a...)
+	}
+
+	return result, nil
+}
+
+func (e fakeEclEvaluator) descendants(id int64) ([]int64, error) {
+	result := make([]int64, 0)
+	children, _ := e.children(id)
+	for _, c := range children {
+		result = append(result, c)
+		d, _ := e.descendants(c)
+		result = append(result, d...)
+	}
+
+	return result, nil
+}
+
+func (e fakeEclEvaluator) refsetMembers(refsetId int64) ([]int64, error) {
+	if refsetId == 100 {
+		return []int64{3, 5}, nil
+	}
+
+	return []int64{}, nil
+}
+
+func (e fakeEclEvaluator) attributeSources(typeIds *Intset, destinations *Intset, negate bool) (*Intset, error) {
+	result := NewIntset()
+	if !typeIds.Contains(60) {
+		return result, nil
+	}
+
+	for source, destination := range fakeEclAttributes {
+		if destinations == nil || destinations.Contains(destination) != negate {
+			result.Add(source)
+		}
+	}
+
+	return result, nil
+}
+
+func evalEcl(t *testing.T, s string) []int64 {
+	expr, err := parseEcl(s)
+	if err != nil {
+		t.Fatalf("Failed to parse '%s': %s", s, err)
+	}
+
+	r, err := expr.eval(fakeEclEvaluator{})
+	if err != nil {
+		t.Fatalf("Failed to evaluate '%s': %s", s, err)
+	}
+
+	result := r.ToInt64Slice()
+	sort.Sort(int64Slice(result))
+	return result
+}
+
+func Test_EclHierarchyOperators(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal([]int64{2}, evalEcl(t, "2 |Some term|"))
+	assert.Equal([]int64{4, 5}, evalEcl(t, "< 2"))
+	assert.Equal([]int64{2, 4, 5}, evalEcl(t, "<<2"))
+	assert.Equal([]int64{2, 3}, evalEcl(t, "<! 1"))
+	assert.Equal([]int64{1, 2}, evalEcl(t, "> 4"))
+	assert.Equal([]int64{1, 2, 4}, evalEcl(t, ">> 4"))
+	assert.Equal([]int64{4}, evalEcl(t, ">! 5"))
+	assert.Equal([]int64{3, 5}, evalEcl(t, "^ 100"))
+	assert.Equal([]int64{1, 2, 3, 4, 5}, evalEcl(t, "<< *"))
+}
+
+func Test_EclBinaryOperators(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal([]int64{5}, evalEcl(t, "<< 2 AND ^ 100"))
+	assert.Equal([]int64{3, 4, 5}, evalEcl(t, "< 2 or 3"))
+	assert.Equal([]int64{2, 4}, evalEcl(t, "<< 2 MINUS ^ 100"))
+	assert.Equal([]int64{2, 3}, evalEcl(t, "<< 1 MINUS (<< 4 OR 1)"))
+	assert.Equal([]int64{5}, evalEcl(t, "<< 2, ^100"))
+}
+
+func Test_EclRefinements(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal([]int64{4}, evalEcl(t, "<< 1 : 60 = 3"))
+	assert.Equal([]int64{4, 5}, evalEcl(t, "<< 1 : 60 = *"))
+	assert.Equal([]int64{5}, evalEcl(t, "<< 1 : 60 != 3"))
+	assert.Equal([]int64{4, 5}, evalEcl(t, "<< 1 : 60 = << 1"))
+	assert.Equal([]int64{5}, evalEcl(t, "<< 1 : 60 = (<< 2 MINUS 4)"))
+	assert.Equal([]int64{}, evalEcl(t, "<< 1 : 60 = 3 AND 60 = 2"))
+	assert.Equal([]int64{4, 5}, evalEcl(t, "<< 1 : 60 = 3 OR 60 = 2"))
+	assert.Equal([]int64{4}, evalEcl(t, "<< 1 : 60 = * MINUS ^ 100"))
+}
+
+func Test_EclSyntaxErrors(t *testing.T) {
+	for _, s := range []string{"", "<<", "<< 1 AND", "(<< 1", "<< 1 : 60", "<< 1 : {60 = 2}", "<< 1 |term", "foo"} {
+		_, err := parseEcl(s)
+		assert.NotNil(t, err, "Expected error for '%s'", s)
+	}
+}
@@ -1,8 +1,10 @@
 package fhirterm
 
 import (
-	"github.com/davecgh/go-spew/spew"
+	"fmt"
+	"sort"
 	"strings"
+	"time"
 )
 
 const (
@@ -30,58 +32,279 @@ func normalizeNsUrl(u string) string {
 	return strings.TrimRight(u, "/")
 }
 
-func valueSetComposeFiltersToNsFilters(vs *ValueSet) (*map[string]*NsFilter, error) {
+// NsFilters are keyed by system url, or by "url|version" when
+// specific release of code system is requested
+func nsFilterKey(system string, version string) string {
+	if version == "" {
+		return system
+	}
+
+	return system + "|" + version
+}
+
+func splitNsFilterKey(key string) (string, string) {
+	parts := strings.SplitN(key, "|", 2)
+	if len(parts) == 1 {
+		return parts[0], ""
+	}
+
+	return parts[0], parts[1]
+}
+
+// systemVersions are used for includes without version
+func valueSetComposeFiltersToNsFilters(vs *ValueSet, systemVersions map[string]string) (*map[string]*NsFilter, error) {
 	nsFilters := make(map[string]*NsFilter)
-	composeFiltersToNsFilters(&nsFilters, vs.Compose.Include, composeIncludeFilters)
-	composeFiltersToNsFilters(&nsFilters, vs.Compose.Exclude, composeExcludeFilters)
+	composeFiltersToNsFilters(&nsFilters, vs.Compose.Include, composeIncludeFilters, systemVersions)
+	composeFiltersToNsFilters(&nsFilters, vs.Compose.Exclude, composeExcludeFilters, systemVersions)
 
 	return &nsFilters, nil
 }
 
-func composeFiltersToNsFilters(nsFilters *map[string]*NsFilter, compose []VsComposeInclude, ft int) {
+func composeFiltersToNsFilters(nsFilters *map[string]*NsFilter, compose []VsComposeInclude, ft int, systemVersions map[string]string) {
 	for _, i := range compose {
-		systemUrl := normalizeNsUrl(i.System)
+		system := normalizeNsUrl(i.System)
+		version := i.Version
+		if version == "" && ft == composeIncludeFilters {
+			version = systemVersions[system]
+		}
+
+		// include without filters and concepts means whole namespace
+		// and is represented with empty predicates list
+		preds := make([]NsPredicate, 0)
+
+		if i.Filter != nil {
+			for _, f := range i.Filter {
+				preds = append(preds, vsFilterToNsPredicate(f))
+			}
+		}
+
+		if i.Concept != nil {
+			preds = append(preds, vsConceptsToNsPredicate(i.Concept))
+		}
+
+		if ft == composeIncludeFilters {
+			nsFilter := findOrAddNsFilter(nsFilters, nsFilterKey(system, version))
+			nsFilter.Include = append(nsFilter.Include, preds)
+			continue
+		}
+
+		for _, key := range excludedNsFilterKeys(*nsFilters, system, version) {
+			nsFilter := findOrAddNsFilter(nsFilters, key)
+			nsFilter.Exclude = append(nsFilter.Exclude, preds)
+		}
+	}
+}
+
+func findOrAddNsFilter(nsFilters *map[string]*NsFilter, key string) *NsFilter {
+	nsFilter, found := (*nsFilters)[key]
+
+	if !found {
+		nsFilter = &NsFilter{
+			Include: make([][]NsPredicate, 0),
+			Exclude: make([][]NsPredicate, 0),
+		}
+
+		(*nsFilters)[key] = nsFilter
+	}
+
+	return nsFilter
+}
+
+// Exclude without version applies to every included version of
+// code system (including versions requested with system-version
+// parameter), versioned one only to includes of the same version
+func excludedNsFilterKeys(nsFilters map[string]*NsFilter, system string, version string) []string {
+	if version != "" {
+		return []string{nsFilterKey(system, version)}
+	}
+
+	keys := make([]string, 0)
+	for key, _ := range nsFilters {
+		if s, _ := splitNsFilterKey(key); s == system {
+			keys = append(keys, key)
+		}
+	}
+
+	if len(keys) == 0 {
+		keys = append(keys, nsFilterKey(system, ""))
+	}
+
+	return keys
+}
+
+type ExpandParams struct {
+	Filter          string
+	DisplayLanguage string
+	Offset          int
+	Count           int
+	// versions of code systems by url (system-version parameter)
+	SystemVersions map[string]string
+}
+
+func flattenDefineConcepts(system string, concepts []VsDefineConcept, result []VsExpansionContains) []VsExpansionContains {
+	for _, c := range concepts {
+		if !c.Abstract {
+			result = append(result, VsExpansionContains{
+				System:  system,
+				Code:    c.Code,
+				Display: c.Display,
+			})
+		}
+
+		result = flattenDefineConcepts(system, c.Concept, result)
+	}
 
-		nsFilter, found := (*nsFilters)[systemUrl]
+	return result
+}
+
+func filterContainsByText(contains []VsExpansionContains, text string) []VsExpansionContains {
+	if text == "" {
+		return contains
+	}
+
+	text = strings.ToLower(text)
+	result := make([]VsExpansionContains, 0)
+
+	for _, c := range contains {
+		if strings.Contains(strings.ToLower(c.Display), text) {
+			result = append(result, c)
+		}
+	}
+
+	return result
+}
+
+func (s *Server) expandValueSetContent(vs *ValueSet, params ExpandParams) (*ValueSet, error) {
+	contains := make([]VsExpansionContains, 0)
+	// releases of code systems expansion is computed against
+	usedVersions := make(map[string]bool)
+
+	if vs.Define != nil {
+		defined := flattenDefineConcepts(vs.Define.System, vs.Define.Concept, nil)
+		contains = append(contains, filterContainsByText(defined, params.Filter)...)
+	}
+
+	if vs.Compose != nil {
+		for _, identifier := range vs.Compose.Import {
+			imported, found := s.Registry.FindImplicitValueSet(identifier)
+			if !found {
+				return nil, fmt.Errorf("cannot resolve imported value set: %s", identifier)
+			}
 
-		if !found {
-			nsFilter = &NsFilter{
-				Include: make([][]NsPredicate, 0),
-				Exclude: make([][]NsPredicate, 0),
+			importedVs, err := s.expandValueSetContent(imported, ExpandParams{
+				Filter:          params.Filter,
+				DisplayLanguage: params.DisplayLanguage,
+				SystemVersions:  params.SystemVersions,
+			})
+			if err != nil {
+				return nil, err
+			}
+
+			contains = append(contains, importedVs.Expansion.Contains...)
+			for _, p := range importedVs.Expansion.Parameter {
+				usedVersions[p.ValueUri] = true
 			}
 		}
 
-		if i.Filter != nil || i.Concept != nil {
-			preds := make([]NsPredicate, 0)
+		nsFilters, err := valueSetComposeFiltersToNsFilters(vs, params.SystemVersions)
+		if err != nil {
+			return nil, err
+		}
 
-			if i.Filter != nil {
-				for _, f := range i.Filter {
-					preds = append(preds, vsFilterToNsPredicate(f))
-				}
+		// expand namespaces in stable order to make paging predictable
+		keys := make([]string, 0, len(*nsFilters))
+		for key, _ := range *nsFilters {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			system, version := splitNsFilterKey(key)
+			ns, version, err := s.Registry.FindNamespaceVersion(system, version)
+			if err != nil {
+				return nil, err
 			}
 
-			if i.Concept != nil {
-				preds = append(preds, vsConceptsToNsPredicate(i.Concept))
+			if version != "" {
+				usedVersions[system+"|"+version] = true
 			}
 
-			if ft == composeIncludeFilters {
-				nsFilter.Include = append(nsFilter.Include, preds)
-			} else {
-				nsFilter.Exclude = append(nsFilter.Exclude, preds)
+			nsFilter := (*nsFilters)[key]
+			nsFilter.Text = params.Filter
+			nsFilter.DisplayLanguage = params.DisplayLanguage
+
+			nsContains, err := ns.Filter(nsFilter)
+			if err != nil {
+				return nil, err
 			}
+
+			contains = append(contains, nsContains...)
 		}
+	}
 
-		(*nsFilters)[systemUrl] = nsFilter
+	total := len(contains)
+	offset := params.Offset
+	if offset > total {
+		offset = total
 	}
-}
 
-func ExpandValueSet(id string) (*ValueSet, error) {
-	storage := GetStorage()
-	vs, _ := storage.FindValueSetById(id)
-	spew.Dump(vs)
+	end := total
+	if params.Count > 0 && offset+params.Count < total {
+		end = offset + params.Count
+	}
 
-	nsFilters, _ := valueSetComposeFiltersToNsFilters(vs)
-	spew.Dump(nsFilters)
+	vs.Expansion = &VsExpansion{
+		Timestamp: time.Now().Format(time.RFC3339),
+		Total:     total,
+		Offset:    offset,
+		Parameter: versionParameters(usedVersions),
+		Contains:  contains[offset:end],
+	}
 
 	return vs, nil
 }
+
+// Versions are reported like FHIR terminology servers do, with
+// "version" parameters containing "system|version" values
+func versionParameters(versions map[string]bool) []VsExpansionParameter {
+	values := make([]string, 0, len(versions))
+	for v, _ := range versions {
+		values = append(values, v)
+	}
+	sort.Strings(values)
+
+	result := make([]VsExpansionParameter, 0, len(values))
+	for _, v := range values {
+		result = append(result, VsExpansionParameter{Name: "version", ValueUri: v})
+	}
+
+	return result
+}
+
+// Implicit value sets of namespaces (like http://loinc.org/vs/LL715-4)
+// are resolved without Storage
+func (s *Server) FindValueSetByIdentifier(identifier string) (*ValueSet, error) {
+	if vs, found := s.Registry.FindImplicitValueSet(identifier); found {
+		return vs, nil
+	}
+
+	return s.Storage.FindValueSetByIdentifier(identifier)
+}
+
+func (s *Server) ExpandValueSetByIdentifier(identifier string, params ExpandParams) (*ValueSet, error) {
+	vs, err := s.FindValueSetByIdentifier(identifier)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.expandValueSetContent(vs, params)
+}
+
+func (s *Server) ExpandValueSet(id string, params ExpandParams) (*ValueSet, error) {
+	vs, err := s.Storage.FindValueSetById(id)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.expandValueSetContent(vs, params)
+}
@@ -1,7 +1,11 @@
 package fhirterm
 
 import (
+	"encoding/json"
 	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 )
 
@@ -43,7 +47,7 @@ func Test_VsComposeToNsFilters(t *testing.T) {
 		},
 	}
 
-	nsFilters, _ := valueSetComposeFiltersToNsFilters(&vs)
+	nsFilters, _ := valueSetComposeFiltersToNsFilters(&vs, nil)
 
 	assert.Len((*nsFilters), 2, "There is two NSs in the map")
 
@@ -74,3 +78,130 @@ func Test_VsComposeToNsFilters(t *testing.T) {
 			},
 		})
 }
+
+func Test_VsComposeExcludesToVersionedNsFilters(t *testing.T) {
+	assert := assert.New(t)
+	url := "http://loinc.org"
+	exclude := VsComposeInclude{System: url, Concept: []VsComposeIncludeConcept{{Code: "1"}}}
+
+	tests := []struct {
+		name           string
+		include        []string
+		excludeVersion string
+		systemVersions map[string]string
+		// number of exclude groups by filter key
+		excludes map[string]int
+	}{
+		{"versioned include", []string{"2.0"}, "", nil,
+			map[string]int{url + "|2.0": 1}},
+		{"include with system-version", []string{""}, "", map[string]string{url: "2.0"},
+			map[string]int{url + "|2.0": 1}},
+		{"include with version and system-version", []string{"1.0"}, "", map[string]string{url: "2.0"},
+			map[string]int{url + "|1.0": 1}},
+		{"several included versions", []string{"1.0", "2.0"}, "", nil,
+			map[string]int{url + "|1.0": 1, url + "|2.0": 1}},
+		{"versioned exclude", []string{"1.0", "2.0"}, "2.0", nil,
+			map[string]int{url + "|1.0": 0, url + "|2.0": 1}},
+		{"exclude of other version", []string{"1.0"}, "2.0", nil,
+			map[string]int{url + "|1.0": 0, url + "|2.0": 1}},
+		{"unversioned include", []string{""}, "", nil,
+			map[string]int{url: 1}},
+	}
+
+	for _, test := range tests {
+		vs := ValueSet{Compose: &VsCompose{}}
+		for _, version := range test.include {
+			vs.Compose.Include = append(vs.Compose.Include, VsComposeInclude{System: url, Version: version})
+		}
+
+		exclude.Version = test.excludeVersion
+		vs.Compose.Exclude = []VsComposeInclude{exclude}
+
+		nsFilters, err := valueSetComposeFiltersToNsFilters(&vs, test.systemVersions)
+		assert.Nil(err)
+
+		excludes := make(map[string]int)
+		for key, f := range *nsFilters {
+			excludes[key] = len(f.Exclude)
+		}
+
+		assert.Equal(test.excludes, excludes, test.name)
+	}
+}
+
+func Test_ExpandWithVersions(t *testing.T) {
+	assert := assert.New(t)
+	url := "http://example.org/versioned-expand"
+	s := &Server{Registry: NewRegistry()}
+
+	s.Registry.RegisterNamespaceVersion(fakeNamespace{url, "first"}, "1.0")
+	s.Registry.RegisterNamespaceVersion(fakeNamespace{url, "second"}, "2.0")
+
+	newVs := func(version string) *ValueSet {
+		return &ValueSet{Compose: &VsCompose{
+			Include: []VsComposeInclude{VsComposeInclude{System: url, Version: version}},
+		}}
+	}
+
+	vs, err := s.expandValueSetContent(newVs(""), ExpandParams{})
+	assert.Nil(err)
+	assert.Equal("first", vs.Expansion.Contains[0].Display)
+	assert.Equal([]VsExpansionParameter{VsExpansionParameter{Name: "version", ValueUri: url + "|1.0"}},
+		vs.Expansion.Parameter)
+
+	vs, err = s.expandValueSetContent(newVs("2.0"), ExpandParams{})
+	assert.Nil(err)
+	assert.Equal("second", vs.Expansion.Contains[0].Display, "compose.include.version selects release")
+	assert.Equal(url+"|2.0", vs.Expansion.Parameter[0].ValueUri)
+
+	vs, err = s.expandValueSetContent(newVs(""), ExpandParams{SystemVersions: map[string]string{url: "2.0"}})
+	assert.Nil(err)
+	assert.Equal("second", vs.Expansion.Contains[0].Display, "system-version applies to includes without version")
+
+	vs, err = s.expandValueSetContent(newVs("1.0"), ExpandParams{SystemVersions: map[string]string{url: "2.0"}})
+	assert.Nil(err)
+	assert.Equal("first", vs.Expansion.Contains[0].Display, "compose.include.version wins over system-version")
+
+	_, err = s.expandValueSetContent(newVs("3.0"), ExpandParams{})
+	assert.NotNil(err)
+}
+
+func Test_ExpandInlineValueSet(t *testing.T) {
+	assert := assert.New(t)
+	s := &Server{Registry: NewRegistry()}
+	ts := httptest.NewServer(s.Handler())
+	defer ts.Close()
+
+	post := func(query string, body string) (int, *ValueSet) {
+		resp, err := http.Post(ts.URL+"/ValueSet/$expand"+query, "application/json+fhir", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		var vs ValueSet
+		json.NewDecoder(resp.Body).Decode(&vs)
+		return resp.StatusCode, &vs
+	}
+
+	draft := `{"resourceType": "ValueSet", "compose": {"include": [{"system": "http://unitsofmeasure.org",
+             "concept": [{"code": "mg"}, {"code": "g"}, {"code": "kg"}]}]}}`
+
+	status, vs := post("?count=2", draft)
+	assert.Equal(http.StatusOK, status)
+	assert.Equal(3, vs.Expansion.Total)
+	assert.Equal([]string{"mg", "g"}, []string{vs.Expansion.Contains[0].Code, vs.Expansion.Contains[1].Code})
+
+	status, vs = post("?count=2", `{"resourceType": "Parameters", "parameter": [
+                                   {"name": "valueSet", "resource": `+draft+`},
+                                   {"name": "offset", "valueInteger": 2}]}`)
+	assert.Equal(http.StatusOK, status)
+	assert.Equal(2, vs.Expansion.Offset)
+	assert.Equal("kg", vs.Expansion.Contains[0].Code)
+
+	status, _ = post("", `{"resourceType": "Parameters", "parameter": [{"name": "count", "valueInteger": 2}]}`)
+	assert.Equal(http.StatusBadRequest, status)
+
+	status, _ = post("", `{"resourceType": "Patient"}`)
+	assert.Equal(http.StatusBadRequest, status)
+}
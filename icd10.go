@@ -0,0 +1,222 @@
+package fhirterm
+
+import (
+	"database/sql"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const (
+	Icd10Url   = "http://hl7.org/fhir/sid/icd-10"
+	Icd10CmUrl = "http://hl7.org/fhir/sid/icd-10-cm"
+)
+
+const icd10DescendantsStmt = `
+WITH RECURSIVE t(code) AS (
+  SELECT code FROM icd10_concepts WHERE system = ? AND parent_code = ?
+  UNION
+  SELECT c.code FROM icd10_concepts AS c JOIN t ON c.parent_code = t.code
+  WHERE c.system = ?
+) SELECT c.rowid FROM icd10_concepts AS c JOIN t ON t.code = c.code
+  WHERE c.system = ?`
+
+const icd10AncestorsStmt = `
+WITH RECURSIVE t(code) AS (
+  SELECT parent_code FROM icd10_concepts WHERE system = ? AND code = ? AND parent_code <> ''
+  UNION
+  SELECT c.parent_code FROM icd10_concepts AS c JOIN t ON c.code = t.code
+  WHERE c.system = ? AND c.parent_code <> ''
+) SELECT code FROM t`
+
+var icd10CategoryRegexp = regexp.MustCompile("^[A-Z][0-9][0-9A-Z]")
+
+// Serves both WHO ICD-10 and ICD-10-CM, concepts are identified by
+// rowid of icd10_concepts table. Chapters and blocks can be used in
+// hierarchy filters but never appear in expansions.
+type Icd10Namespace struct {
+	db     *DB
+	system string
+}
+
+func NewIcd10Namespace(db *DB, system string) *Icd10Namespace {
+	return &Icd10Namespace{db: db, system: system}
+}
+
+func (ns *Icd10Namespace) Url() string {
+	return ns.system
+}
+
+func (ns *Icd10Namespace) Filter(f *NsFilter) ([]VsExpansionContains, error) {
+	ids, err := evalNsFilter(f, ns)
+	if err != nil {
+		return nil, err
+	}
+
+	return ns.idsToContains(ids, f.Text)
+}
+
+// ICD-10-CM files sometimes omit dot in codes, E1165 means E11.65
+func normalizeIcd10Code(code string) string {
+	code = strings.ToUpper(strings.TrimSpace(code))
+
+	if len(code) > 3 && !strings.ContainsAny(code, ".-") && icd10CategoryRegexp.MatchString(code) {
+		return code[0:3] + "." + code[3:]
+	}
+
+	return code
+}
+
+func (ns *Icd10Namespace) Lookup(code string, displayLanguage string) (*NsConcept, error) {
+	concept := &NsConcept{System: ns.system, Code: normalizeIcd10Code(code)}
+
+	err := ns.db.QueryRow("SELECT display FROM icd10_concepts WHERE system = ? AND code = ?",
+		ns.system, concept.Code).Scan(&concept.Display)
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return concept, nil
+}
+
+func (ns *Icd10Namespace) allConcepts() (*Intset, error) {
+	return queryIntset(ns.db, "SELECT rowid FROM icd10_concepts WHERE system = ?", ns.system)
+}
+
+func (ns *Icd10Namespace) codesToIds(codes []string) (*Intset, error) {
+	result := NewIntset()
+
+	for _, code := range codes {
+		ids, err := queryIntset(ns.db, "SELECT rowid FROM icd10_concepts WHERE system = ? AND code = ?",
+			ns.system, normalizeIcd10Code(code))
+		if err != nil {
+			return nil, err
+		}
+
+		result.AddSet(ids)
+	}
+
+	return result, nil
+}
+
+func (ns *Icd10Namespace) descendantIds(code string, includeSelf bool) (*Intset, error) {
+	code = normalizeIcd10Code(code)
+	result, err := queryIntset(ns.db, icd10DescendantsStmt, ns.system, code, ns.system, ns.system)
+	if err != nil {
+		return nil, err
+	}
+
+	if includeSelf {
+		self, err := ns.codesToIds([]string{code})
+		if err != nil {
+			return nil, err
+		}
+
+		result.AddSet(self)
+	}
+
+	return result, nil
+}
+
+func (ns *Icd10Namespace) AncestorCodes(code string) ([]string, error) {
+	return queryStrings(ns.db, icd10AncestorsStmt, ns.system, normalizeIcd10Code(code), ns.system)
+}
+
+// Regex has to match whole code, like E11\..* or E1[01].*
+func (ns *Icd10Namespace) codeRegexIds(pattern string) (*Intset, error) {
+	re, err := regexp.Compile("^(?:" + pattern + ")$")
+	if err != nil {
+		return nil, fmt.Errorf("invalid regex in ICD-10 filter: %s", err)
+	}
+
+	rows, err := ns.db.Query("SELECT rowid, code FROM icd10_concepts WHERE system = ?", ns.system)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := NewIntset()
+	for rows.Next() {
+		var id int64
+		var code string
+		err = rows.Scan(&id, &code)
+		if err != nil {
+			return nil, err
+		}
+
+		if re.MatchString(code) {
+			result.Add(id)
+		}
+	}
+
+	return result, rows.Err()
+}
+
+func (ns *Icd10Namespace) predicateToIntset(p NsPredicate) (*Intset, error) {
+	switch p.Property {
+	case "concept":
+		switch p.Op {
+		case "in":
+			if p.Concepts != nil {
+				codes := make([]string, 0, len(p.Concepts))
+				for _, c := range p.Concepts {
+					codes = append(codes, c.Code)
+				}
+
+				return ns.codesToIds(codes)
+			}
+
+			return ns.codesToIds(strings.Split(p.Value, ","))
+		case "=":
+			return ns.codesToIds([]string{p.Value})
+		case "is-a":
+			return ns.descendantIds(p.Value, true)
+		case "descendent-of":
+			return ns.descendantIds(p.Value, false)
+		case "regex":
+			return ns.codeRegexIds(p.Value)
+		}
+	case "code":
+		if p.Op == "regex" {
+			return ns.codeRegexIds(p.Value)
+		}
+	case "parent":
+		if p.Op == "=" {
+			return queryIntset(ns.db, "SELECT rowid FROM icd10_concepts WHERE system = ? AND parent_code = ?",
+				ns.system, normalizeIcd10Code(p.Value))
+		}
+	}
+
+	return nil, unsupportedPredicateError("ICD-10", p)
+}
+
+func (ns *Icd10Namespace) idsToContains(ids *Intset, text string) ([]VsExpansionContains, error) {
+	result := make([]VsExpansionContains, 0, ids.Len())
+
+	err := queryByIdChunks(ns.db,
+		"SELECT code, display FROM icd10_concepts WHERE kind = 'category' AND rowid IN (%s)",
+		ids.ToInt64Slice(), nil,
+		func(rows *sql.Rows) error {
+			var code, display sql.NullString
+			err := rows.Scan(&code, &display)
+
+			result = append(result, VsExpansionContains{
+				System:  ns.system,
+				Code:    code.String,
+				Display: display.String,
+			})
+
+			return err
+		})
+
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Sort(containsByCode(result))
+	return filterContainsByText(result, text), nil
+}
@@ -0,0 +1,83 @@
+package fhirterm
+
+import (
+	"github.com/stretchr/testify/assert"
+	"sort"
+	"testing"
+)
+
+// Chapter I contains block A00-A09 with A00 and A01 categories,
+// ICD-10-CM rows share the table
+var icd10Stmts = []string{
+	"CREATE TABLE icd10_concepts (system text, code text, display text, kind text, parent_code text)",
+	`INSERT INTO icd10_concepts VALUES
+   ('http://hl7.org/fhir/sid/icd-10', 'I', 'Certain infectious and parasitic diseases', 'chapter', ''),
+   ('http://hl7.org/fhir/sid/icd-10', 'A00-A09', 'Intestinal infectious diseases', 'block', 'I'),
+   ('http://hl7.org/fhir/sid/icd-10', 'A00', 'Cholera', 'category', 'A00-A09'),
+   ('http://hl7.org/fhir/sid/icd-10', 'A00.0', 'Classical cholera', 'category', 'A00'),
+   ('http://hl7.org/fhir/sid/icd-10', 'A00.1', 'Cholera eltor', 'category', 'A00'),
+   ('http://hl7.org/fhir/sid/icd-10', 'A01', 'Typhoid and paratyphoid fevers', 'category', 'A00-A09'),
+   ('http://hl7.org/fhir/sid/icd-10-cm', 'A00', 'Cholera', 'category', ''),
+   ('http://hl7.org/fhir/sid/icd-10-cm', 'E11.65', 'Type 2 diabetes mellitus with hyperglycemia', 'category', '')`,
+}
+
+func Test_Icd10Filter(t *testing.T) {
+	assert := assert.New(t)
+	db, closeDb := openTestDb(t, icd10Stmts...)
+	defer closeDb()
+
+	ns := NewIcd10Namespace(db, Icd10Url)
+
+	tests := []struct {
+		include NsPredicate
+		exclude []NsPredicate
+		codes   []string
+	}{
+		{NsPredicate{}, nil, []string{"A00", "A00.0", "A00.1", "A01"}},
+		{NsPredicate{Property: "concept", Op: "=", Value: "a000"}, nil, []string{"A00.0"}},
+		{NsPredicate{Property: "concept", Op: "in", Value: "A00.1, A01,Z99"}, nil, []string{"A00.1", "A01"}},
+		{NsPredicate{Property: "concept", Op: "in", Concepts: []VsComposeIncludeConcept{{Code: "A00"}}}, nil,
+			[]string{"A00"}},
+		{NsPredicate{Property: "concept", Op: "is-a", Value: "I"}, nil, []string{"A00", "A00.0", "A00.1", "A01"}},
+		{NsPredicate{Property: "concept", Op: "is-a", Value: "A00"}, nil, []string{"A00", "A00.0", "A00.1"}},
+		{NsPredicate{Property: "concept", Op: "descendent-of", Value: "A00"}, nil, []string{"A00.0", "A00.1"}},
+		{NsPredicate{Property: "concept", Op: "regex", Value: `A00\..*`}, nil, []string{"A00.0", "A00.1"}},
+		{NsPredicate{Property: "code", Op: "regex", Value: "A0[01]"}, nil, []string{"A00", "A01"}},
+		{NsPredicate{Property: "parent", Op: "=", Value: "A00-A09"}, nil, []string{"A00", "A01"}},
+		{NsPredicate{Property: "concept", Op: "is-a", Value: "I"},
+			[]NsPredicate{{Property: "concept", Op: "descendent-of", Value: "A00"}}, []string{"A00", "A01"}},
+	}
+
+	for _, test := range tests {
+		codes, err := filterCodes(ns, test.include, test.exclude...)
+		assert.Nil(err)
+		assert.Equal(test.codes, codes, "%+v", test.include)
+	}
+
+	codes, err := filterCodes(NewIcd10Namespace(db, Icd10CmUrl), NsPredicate{})
+	assert.Nil(err)
+	assert.Equal([]string{"A00", "E11.65"}, codes, "code systems are separated")
+
+	codes, err = filterCodes(NewIcd10Namespace(db, Icd10CmUrl), NsPredicate{Property: "concept", Op: "=", Value: "E1165"})
+	assert.Nil(err)
+	assert.Equal([]string{"E11.65"}, codes)
+
+	_, err = filterCodes(ns, NsPredicate{Property: "code", Op: "regex", Value: "A0["})
+	assert.Contains(err.Error(), "invalid regex in ICD-10 filter")
+
+	_, err = filterCodes(ns, NsPredicate{Property: "parent", Op: "in", Value: "A00"})
+	assert.EqualError(err, "ICD-10 does not support filter with property 'parent' and op 'in'")
+
+	ancestors, err := ns.AncestorCodes("a000")
+	assert.Nil(err)
+	sort.Strings(ancestors)
+	assert.Equal([]string{"A00", "A00-A09", "I"}, ancestors)
+
+	concept, err := ns.Lookup("A001", "")
+	assert.Nil(err)
+	assert.Equal("Cholera eltor", concept.Display)
+
+	concept, err = ns.Lookup("A02", "")
+	assert.Nil(err)
+	assert.Nil(concept)
+}
@@ -0,0 +1,203 @@
+package importer
+
+import (
+	"archive/zip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Release files are read directly from zip archive, from directory
+// with already extracted release or from single plain file, nothing
+// is unpacked to disk
+type release struct {
+	path    string
+	dir     string
+	archive *zip.ReadCloser
+	entries map[string]*zip.File
+	names   []string
+	isDir   bool
+	sum     string
+}
+
+// File inside release, names start with "/" and use forward slashes
+// (like /SnomedCT_Release_INT_20150131/RF2Release/Full/...)
+type releaseFile struct {
+	name string
+	open func() (io.ReadCloser, error)
+}
+
+func openRelease(path string) (*release, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &release{path: path, entries: make(map[string]*zip.File)}
+
+	if info.IsDir() {
+		r.isDir = true
+		// directory name is kept, so patterns written for archives
+		// with top-level directory match extracted releases as well
+		r.dir = filepath.Dir(filepath.Clean(path))
+		err = filepath.Walk(path, func(p string, f os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+
+			if !f.IsDir() {
+				rel, err := filepath.Rel(r.dir, p)
+				if err != nil {
+					return err
+				}
+
+				r.names = append(r.names, "/"+filepath.ToSlash(rel))
+			}
+
+			return nil
+		})
+
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		r.archive, err = zip.OpenReader(path)
+		if err == zip.ErrFormat {
+			r.dir = filepath.Dir(path)
+			r.names = []string{"/" + filepath.Base(path)}
+		} else if err != nil {
+			return nil, fmt.Errorf("Cannot open archive %s: %s", path, err)
+		} else {
+			for _, f := range r.archive.File {
+				if !f.FileInfo().IsDir() {
+					name := "/" + strings.TrimPrefix(f.Name, "/")
+					r.entries[name] = f
+					r.names = append(r.names, name)
+				}
+			}
+		}
+	}
+
+	sort.Strings(r.names)
+	return r, nil
+}
+
+// Opens release and passes it to callback, replaces extraction
+// of archive into temporary directory
+func withRelease(path string, callback func(r *release) error) error {
+	r, err := openRelease(path)
+	if err != nil {
+		return err
+	}
+	defer r.close()
+
+	return callback(r)
+}
+
+func (r *release) close() error {
+	if r.archive != nil {
+		return r.archive.Close()
+	}
+
+	return nil
+}
+
+func (r *release) files() []string {
+	return r.names
+}
+
+func (r *release) file(name string) releaseFile {
+	return releaseFile{
+		name: name,
+		open: func() (io.ReadCloser, error) {
+			if r.archive != nil {
+				entry, found := r.entries[name]
+				if !found {
+					return nil, fmt.Errorf("no %s in archive %s", name, r.path)
+				}
+
+				return entry.Open()
+			}
+
+			return os.Open(filepath.Join(r.dir, filepath.FromSlash(name)))
+		},
+	}
+}
+
+// Returns the only file of release, it's either plain file or
+// archive with single entry
+func (r *release) single() (releaseFile, bool) {
+	if len(r.names) != 1 {
+		return releaseFile{}, false
+	}
+
+	return r.file(r.names[0]), true
+}
+
+func (r *release) find(rexp string) (releaseFile, bool) {
+	found := r.findAll(rexp)
+	if len(found) == 0 {
+		return releaseFile{}, false
+	}
+
+	return found[0], true
+}
+
+func (r *release) findAll(rexp string) []releaseFile {
+	re := regexp.MustCompile(rexp)
+	result := make([]releaseFile, 0)
+
+	for _, name := range r.names {
+		if re.MatchString(name) {
+			result = append(result, r.file(name))
+		}
+	}
+
+	return result
+}
+
+// SHA-256 of release archive or file, for directory it's calculated
+// over names and content of all files
+func (r *release) checksum() (string, error) {
+	if r.sum != "" {
+		return r.sum, nil
+	}
+
+	hash := sha256.New()
+
+	if r.isDir {
+		for _, name := range r.names {
+			io.WriteString(hash, name+"\n")
+
+			err := r.hashFile(hash, filepath.Join(r.dir, filepath.FromSlash(name)))
+			if err != nil {
+				return "", err
+			}
+		}
+	} else {
+		err := r.hashFile(hash, r.path)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	r.sum = hex.EncodeToString(hash.Sum(nil))
+	return r.sum, nil
+}
+
+func (r *release) hashFile(hash io.Writer, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = io.Copy(hash, file)
+	return err
+}
@@ -0,0 +1,161 @@
+package importer
+
+import (
+	"archive/zip"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Writes zip archive with entries in given order, names ending with
+// "/" are directory entries
+func writeTestZip(t *testing.T, path string, entries [][2]string) {
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	w := zip.NewWriter(file)
+	for _, e := range entries {
+		f, err := w.Create(e[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err = f.Write([]byte(e[1])); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readReleaseFile(t *testing.T, f releaseFile) string {
+	file, err := f.open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	content, err := ioutil.ReadAll(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(content)
+}
+
+func Test_ReleaseFind(t *testing.T) {
+	assert := assert.New(t)
+
+	dir := writeTestRelease(t, map[string]string{
+		"SnomedCT/RF2Release/Full/Terminology/sct2_Concept_Full_INT_20150131.txt":        "concepts",
+		"SnomedCT/RF2Release/Full/Terminology/sct2_Description_Full-en_INT_20150131.txt": "descriptions",
+		"SnomedCT/Readme.txt": "readme",
+		"single/codes.csv":    "codes",
+		"codes.csv":           "plain",
+	})
+	defer os.RemoveAll(dir)
+
+	writeTestZip(t, filepath.Join(dir, "SnomedCT.zip"), [][2]string{
+		{"SnomedCT/", ""},
+		{"SnomedCT/RF2Release/Full/Terminology/sct2_Description_Full-en_INT_20150131.txt", "descriptions"},
+		{"SnomedCT/RF2Release/Full/Terminology/sct2_Concept_Full_INT_20150131.txt", "concepts"},
+		{"/SnomedCT/Readme.txt", "readme"},
+	})
+
+	writeTestZip(t, filepath.Join(dir, "single.zip"), [][2]string{{"codes.csv", "codes"}})
+
+	tests := []struct {
+		path   string
+		files  []string
+		single string
+	}{
+		{"SnomedCT.zip", []string{
+			"/SnomedCT/RF2Release/Full/Terminology/sct2_Concept_Full_INT_20150131.txt",
+			"/SnomedCT/RF2Release/Full/Terminology/sct2_Description_Full-en_INT_20150131.txt",
+			"/SnomedCT/Readme.txt",
+		}, ""},
+		{"SnomedCT", []string{
+			"/SnomedCT/RF2Release/Full/Terminology/sct2_Concept_Full_INT_20150131.txt",
+			"/SnomedCT/RF2Release/Full/Terminology/sct2_Description_Full-en_INT_20150131.txt",
+			"/SnomedCT/Readme.txt",
+		}, ""},
+		{"single.zip", []string{"/codes.csv"}, "codes"},
+		{"single", []string{"/single/codes.csv"}, "codes"},
+		{"codes.csv", []string{"/codes.csv"}, "plain"},
+	}
+
+	for _, test := range tests {
+		r, err := openRelease(filepath.Join(dir, test.path))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(test.files, r.files(), test.path)
+
+		f, found := r.single()
+		assert.Equal(test.single != "", found, test.path)
+		if found {
+			assert.Equal(test.single, readReleaseFile(t, f), test.path)
+		}
+
+		if len(test.files) > 1 {
+			f, found = r.find("(?i)/Terminology/sct2_Concept_Full_.+\\.txt$")
+			assert.True(found, test.path)
+			assert.Equal("/SnomedCT/RF2Release/Full/Terminology/sct2_Concept_Full_INT_20150131.txt", f.name)
+			assert.Equal("concepts", readReleaseFile(t, f), test.path)
+
+			assert.Len(r.findAll("/Terminology/sct2_"), 2, test.path)
+			assert.Len(r.findAll("^/SnomedCT/[^/]+\\.txt$"), 1, test.path)
+		}
+
+		_, found = r.find("sct2_Relationship_")
+		assert.False(found, test.path)
+
+		_, err = r.file("/missing.txt").open()
+		assert.NotNil(err, test.path)
+
+		assert.Nil(r.close())
+	}
+
+	_, err := openRelease(filepath.Join(dir, "missing.zip"))
+	assert.NotNil(err)
+}
+
+func Test_ReleaseChecksum(t *testing.T) {
+	assert := assert.New(t)
+
+	dir := writeTestRelease(t, map[string]string{
+		"a/codes.csv": "codes",
+		"b/codes.csv": "codes",
+		"c/codes.csv": "other codes",
+		"d/other.csv": "codes",
+		"codes.csv":   "codes",
+	})
+	defer os.RemoveAll(dir)
+
+	checksum := func(path string) string {
+		r, err := openRelease(filepath.Join(dir, path))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.close()
+
+		sum, err := r.checksum()
+		assert.Nil(err)
+		return sum
+	}
+
+	assert.Equal(checksum("codes.csv"), checksum("codes.csv"))
+	assert.Len(checksum("codes.csv"), 64)
+	assert.NotEqual(checksum("a"), checksum("b"), "directory name is part of checksum")
+	assert.NotEqual(checksum("a"), checksum("c"))
+	assert.NotEqual(checksum("a"), checksum("d"))
+	assert.NotEqual(checksum("a"), checksum("codes.csv"))
+}
@@ -0,0 +1,274 @@
+package importer
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/mlapshin/fhirterm"
+	"io/ioutil"
+	"log"
+	"strings"
+)
+
+// Concepts are identified by rowid
+const createCustomConceptsTblStmt = `CREATE TABLE IF NOT EXISTS custom_concepts
+(
+  system character varying,
+  code character varying,
+  display text,
+  parent_code character varying
+)`
+
+var createCustomTblStmts = []string{
+	`CREATE TABLE IF NOT EXISTS custom_code_systems
+(
+  system character varying NOT NULL PRIMARY KEY,
+  name character varying
+)`,
+	`CREATE TABLE IF NOT EXISTS custom_code_system_aliases
+(
+  alias character varying NOT NULL PRIMARY KEY,
+  system character varying
+)`,
+	`CREATE TABLE IF NOT EXISTS custom_concept_properties
+(
+  system character varying,
+  code character varying,
+  property character varying,
+  value text
+)`,
+	`CREATE TABLE IF NOT EXISTS custom_concept_designations
+(
+  system character varying,
+  code character varying,
+  language character varying,
+  use character varying,
+  value text
+)`,
+	"CREATE INDEX IF NOT EXISTS custom_concepts_on_system_code_idx ON custom_concepts(system, code)",
+	"CREATE INDEX IF NOT EXISTS custom_concepts_on_system_parent_code_idx ON custom_concepts(system, parent_code)",
+	"CREATE INDEX IF NOT EXISTS custom_concept_properties_on_system_property_idx ON custom_concept_properties(system, property, value)",
+	"CREATE INDEX IF NOT EXISTS custom_concept_properties_on_system_code_idx ON custom_concept_properties(system, code)",
+	"CREATE INDEX IF NOT EXISTS custom_concept_designations_on_system_code_idx ON custom_concept_designations(system, code)",
+}
+
+func createCustomTables(tx *importTx) error {
+	_, err := tx.Exec(tx.withRowid(createCustomConceptsTblStmt))
+	if err != nil {
+		return err
+	}
+
+	for _, s := range createCustomTblStmts {
+		_, err := tx.Exec(s)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Removes previously imported concepts of code system and registers
+// it in custom_code_systems
+func resetCustomCodeSystem(tx *importTx, system string, name string) error {
+	for _, table := range []string{"custom_concepts", "custom_concept_properties", "custom_concept_designations"} {
+		_, err := tx.Exec("DELETE FROM "+table+" WHERE system = ?", system)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err := tx.Exec("DELETE FROM custom_code_system_aliases WHERE system = ?", system)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM custom_code_systems WHERE system = ?", system)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("INSERT INTO custom_code_systems VALUES (?, ?)", system, name)
+	return err
+}
+
+// Describes how CSV columns map to code system, for example:
+//
+//	{"system": "http://example.org/lab-codes", "name": "Lab codes",
+//	 "version": "2024-01", "releaseDate": "2024-01-15",
+//	 "delimiter": ",", "code": "Code",
+//	 "display": "Name", "parent": "Parent",
+//	 "properties": {"specimen": "Specimen Type"}}
+//
+// Columns are referenced by header names, delimiter is "," by default,
+// "tab" or "\t" means TSV.
+type CodeSystemMapping struct {
+	System      string            `json:"system"`
+	Name        string            `json:"name"`
+	Version     string            `json:"version"`
+	ReleaseDate string            `json:"releaseDate"`
+	Delimiter   string            `json:"delimiter"`
+	Code        string            `json:"code"`
+	Display     string            `json:"display"`
+	Parent      string            `json:"parent"`
+	Properties  map[string]string `json:"properties"`
+}
+
+func ReadCodeSystemMapping(path string) (*CodeSystemMapping, error) {
+	file, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var mapping CodeSystemMapping
+	err = json.Unmarshal(file, &mapping)
+	if err != nil {
+		return nil, fmt.Errorf("Could not parse mapping file: %s", err)
+	}
+
+	return &mapping, nil
+}
+
+func (m *CodeSystemMapping) comma() (rune, error) {
+	switch m.Delimiter {
+	case "":
+		return ',', nil
+	case "tab", "\t":
+		return '\t', nil
+	}
+
+	runes := []rune(m.Delimiter)
+	if len(runes) != 1 {
+		return 0, fmt.Errorf("invalid delimiter: %s", m.Delimiter)
+	}
+
+	return runes[0], nil
+}
+
+// Concepts are loaded to staging table first, then copied to
+// custom_concepts and custom_concept_properties tables
+func ImportCodeSystem(db *fhirterm.DB, csvPath string, mapping *CodeSystemMapping) error {
+	log.Printf("Importing %s code system", mapping.System)
+
+	err := importRelease(db, csvPath, func(tx *importTx, r *release) error {
+		csvFile, found := r.single()
+		if !found {
+			return fmt.Errorf("%s contains more than one file", csvPath)
+		}
+
+		return importCodeSystem(tx, r, csvFile, mapping)
+	})
+
+	if err != nil {
+		return fmt.Errorf("Error during importing %s: %s", mapping.System, err)
+	}
+
+	return nil
+}
+
+func importCodeSystem(tx *importTx, r *release, csvFile releaseFile, mapping *CodeSystemMapping) error {
+	if mapping.System == "" || mapping.Code == "" {
+		return fmt.Errorf("'system' and 'code' are required in mapping")
+	}
+
+	comma, err := mapping.comma()
+	if err != nil {
+		return err
+	}
+
+	header, err := readCsvHeader(csvFile, comma)
+	if err != nil {
+		return err
+	}
+
+	found := false
+	for _, h := range header {
+		if strings.EqualFold(strings.TrimSpace(h), mapping.Code) {
+			found = true
+		}
+	}
+
+	if !found {
+		return fmt.Errorf("code column %s is missing in %s", mapping.Code, csvFile.name)
+	}
+
+	err = createCustomTables(tx)
+	if err != nil {
+		return err
+	}
+
+	// display and parent are optional
+	columns := []csvColumn{{mapping.Code, "code"}}
+	if mapping.Display != "" {
+		columns = append(columns, csvColumn{mapping.Display, "display"})
+	}
+
+	if mapping.Parent != "" {
+		columns = append(columns, csvColumn{mapping.Parent, "parent_code"})
+	}
+
+	columnDefs := []string{"code text", "display text", "parent_code text"}
+	properties := make([]string, 0, len(mapping.Properties))
+
+	for property, header := range mapping.Properties {
+		column := fmt.Sprintf("p%d", len(properties))
+		columns = append(columns, csvColumn{header, column})
+		columnDefs = append(columnDefs, column+" text")
+		properties = append(properties, property)
+	}
+
+	_, err = tx.Exec("DROP TABLE IF EXISTS custom_staging")
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("CREATE TABLE custom_staging (" + strings.Join(columnDefs, ", ") + ")")
+	if err != nil {
+		return err
+	}
+
+	importedRows, err := importCsvColumns(tx, csvFile, comma, "custom_staging", columns)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Imported %d rows from %s", importedRows, csvFile.name)
+
+	err = resetCustomCodeSystem(tx, mapping.System, mapping.Name)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`INSERT INTO custom_concepts
+                    SELECT ?, TRIM(code), display, NULLIF(TRIM(parent_code), '')
+                    FROM custom_staging WHERE TRIM(code) <> ''`, mapping.System)
+	if err != nil {
+		return err
+	}
+
+	for i, property := range properties {
+		_, err = tx.Exec(fmt.Sprintf(`INSERT INTO custom_concept_properties
+                                  SELECT ?, TRIM(code), ?, p%d FROM custom_staging
+                                  WHERE TRIM(code) <> '' AND p%d IS NOT NULL AND p%d <> ''`, i, i, i),
+			mapping.System, property)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.Exec("DROP TABLE custom_staging")
+	if err != nil {
+		return err
+	}
+
+	count, err := countRows(tx, "SELECT COUNT(*) FROM custom_concepts WHERE system = ?", mapping.System)
+	if err != nil {
+		return err
+	}
+
+	return recordRelease(tx, r, releaseInfo{
+		system:      mapping.System,
+		version:     mapping.Version,
+		releaseDate: mapping.ReleaseDate,
+		rowCount:    count,
+	})
+}
@@ -0,0 +1,204 @@
+package importer
+
+import (
+	"github.com/mlapshin/fhirterm"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_CodeSystemMappingComma(t *testing.T) {
+	assert := assert.New(t)
+
+	tests := []struct {
+		delimiter string
+		comma     rune
+		err       string
+	}{
+		{"", ',', ""},
+		{",", ',', ""},
+		{"tab", '\t', ""},
+		{"\t", '\t', ""},
+		{";", ';', ""},
+		{"|", '|', ""},
+		{";;", 0, "invalid delimiter: ;;"},
+		{"TAB", 0, "invalid delimiter: TAB"},
+	}
+
+	for _, test := range tests {
+		m := CodeSystemMapping{Delimiter: test.delimiter}
+		comma, err := m.comma()
+
+		if test.err != "" {
+			assert.EqualError(err, test.err, test.delimiter)
+		} else {
+			assert.Nil(err, test.delimiter)
+			assert.Equal(test.comma, comma, test.delimiter)
+		}
+	}
+}
+
+func Test_ReadCodeSystemMapping(t *testing.T) {
+	assert := assert.New(t)
+
+	dir := writeTestRelease(t, map[string]string{
+		"mapping.json": `{"system": "http://example.org/lab-codes", "name": "Lab codes",
+                      "version": "2024-01", "releaseDate": "2024-01-15", "delimiter": "tab",
+                      "code": "Code", "display": "Name", "parent": "Parent",
+                      "properties": {"specimen": "Specimen Type"}}`,
+		"broken.json": `{"system": `,
+	})
+	defer os.RemoveAll(dir)
+
+	mapping, err := ReadCodeSystemMapping(filepath.Join(dir, "mapping.json"))
+	assert.Nil(err)
+	assert.Equal(CodeSystemMapping{
+		System:      "http://example.org/lab-codes",
+		Name:        "Lab codes",
+		Version:     "2024-01",
+		ReleaseDate: "2024-01-15",
+		Delimiter:   "tab",
+		Code:        "Code",
+		Display:     "Name",
+		Parent:      "Parent",
+		Properties:  map[string]string{"specimen": "Specimen Type"},
+	}, *mapping)
+
+	_, err = ReadCodeSystemMapping(filepath.Join(dir, "broken.json"))
+	assert.EqualError(err, "Could not parse mapping file: unexpected end of JSON input")
+
+	_, err = ReadCodeSystemMapping(filepath.Join(dir, "missing.json"))
+	assert.NotNil(err)
+}
+
+func Test_ImportCodeSystem(t *testing.T) {
+	assert := assert.New(t)
+	db, closeDb := openTestDb(t)
+	defer closeDb()
+
+	system := "http://example.org/lab-codes"
+	dir := writeTestRelease(t, map[string]string{
+		"codes.tsv": "Code\tName\tParent\tSpecimen Type\tMethod\n" +
+			"LAB\tLaboratory\t\t\t\n" +
+			" GLU \tGlucose\tLAB\tSerum\tEnzymatic\n" +
+			"HGB\tHemoglobin\tLAB\tBlood\t\n" +
+			"\tEmpty code\t\t\t\n",
+		"other.tsv": "Code\tName\nOTHER\tOther\n",
+	})
+	defer os.RemoveAll(dir)
+
+	mapping := &CodeSystemMapping{
+		System:      system,
+		Name:        "Lab codes",
+		Version:     "2024-01",
+		ReleaseDate: "2024-01-15",
+		Delimiter:   "tab",
+		Code:        "code",
+		Display:     "Name",
+		Parent:      "Parent",
+		Properties:  map[string]string{"specimen": "Specimen Type", "method": "Method"},
+	}
+
+	assert.Nil(ImportCodeSystem(db, filepath.Join(dir, "codes.tsv"), mapping))
+
+	rows, err := db.Query("SELECT code, display, COALESCE(parent_code, '') FROM custom_concepts WHERE system = ? ORDER BY code", system)
+	assert.Nil(err)
+
+	concepts := make([][]string, 0)
+	for rows.Next() {
+		var code, display, parent string
+		assert.Nil(rows.Scan(&code, &display, &parent))
+		concepts = append(concepts, []string{code, display, parent})
+	}
+	rows.Close()
+
+	assert.Equal([][]string{
+		{"GLU", "Glucose", "LAB"},
+		{"HGB", "Hemoglobin", "LAB"},
+		{"LAB", "Laboratory", ""},
+	}, concepts, "codes are trimmed, rows without code are skipped")
+
+	rows, err = db.Query("SELECT code, property, value FROM custom_concept_properties WHERE system = ? ORDER BY code, property", system)
+	assert.Nil(err)
+
+	properties := make([][]string, 0)
+	for rows.Next() {
+		var code, property, value string
+		assert.Nil(rows.Scan(&code, &property, &value))
+		properties = append(properties, []string{code, property, value})
+	}
+	rows.Close()
+
+	assert.Equal([][]string{
+		{"GLU", "method", "Enzymatic"},
+		{"GLU", "specimen", "Serum"},
+		{"HGB", "specimen", "Blood"},
+	}, properties, "empty property values are not stored")
+
+	var name string
+	assert.Nil(db.QueryRow("SELECT name FROM custom_code_systems WHERE system = ?", system).Scan(&name))
+	assert.Equal("Lab codes", name)
+
+	versions, err := fhirterm.ReadTerminologyVersions(db)
+	assert.Nil(err)
+	assert.Equal("2024-01", versions[system].Version)
+	assert.Equal("2024-01-15", versions[system].ReleaseDate)
+	assert.Equal(3, versions[system].RowCount)
+
+	// other version is imported into separate database unless
+	// replacing is requested
+	mapping.Version = "2024-02"
+	mapping.Parent = ""
+	mapping.Properties = nil
+	err = ImportCodeSystem(db, filepath.Join(dir, "other.tsv"), mapping)
+	if assert.NotNil(err) {
+		assert.Contains(err.Error(), "already contains "+system+" version 2024-01")
+	}
+
+	versions, err = fhirterm.ReadTerminologyVersions(db)
+	assert.Nil(err)
+	assert.Equal("2024-01", versions[system].Version)
+
+	// re-import replaces previously imported concepts
+	db.ReplaceRelease = true
+	assert.Nil(ImportCodeSystem(db, filepath.Join(dir, "other.tsv"), mapping))
+
+	var count int
+	assert.Nil(db.QueryRow("SELECT COUNT(*) FROM custom_concepts WHERE system = ?", system).Scan(&count))
+	assert.Equal(1, count)
+	assert.Nil(db.QueryRow("SELECT COUNT(*) FROM custom_concept_properties WHERE system = ?", system).Scan(&count))
+	assert.Equal(0, count)
+
+	versions, err = fhirterm.ReadTerminologyVersions(db)
+	assert.Nil(err)
+	assert.Equal("2024-02", versions[system].Version)
+}
+
+func Test_ImportCodeSystemErrors(t *testing.T) {
+	assert := assert.New(t)
+	db, closeDb := openTestDb(t)
+	defer closeDb()
+
+	system := "http://example.org/lab-codes"
+	dir := writeTestRelease(t, map[string]string{
+		"release/codes.csv": "Id,Name\nGLU,Glucose\n",
+		"release/more.csv":  "Id,Name\nHGB,Hemoglobin\n",
+	})
+	defer os.RemoveAll(dir)
+
+	csvPath := filepath.Join(dir, "release", "codes.csv")
+
+	err := ImportCodeSystem(db, csvPath, &CodeSystemMapping{System: system, Code: "Code"})
+	assert.EqualError(err, "Error during importing "+system+": code column Code is missing in /codes.csv")
+
+	err = ImportCodeSystem(db, csvPath, &CodeSystemMapping{System: system})
+	assert.EqualError(err, "Error during importing "+system+": 'system' and 'code' are required in mapping")
+
+	err = ImportCodeSystem(db, csvPath, &CodeSystemMapping{System: system, Code: "Id", Delimiter: "::"})
+	assert.EqualError(err, "Error during importing "+system+": invalid delimiter: ::")
+
+	err = ImportCodeSystem(db, filepath.Join(dir, "release"), &CodeSystemMapping{System: system, Code: "Id"})
+	assert.EqualError(err, "Error during importing "+system+": "+filepath.Join(dir, "release")+
+		" contains more than one file")
+}
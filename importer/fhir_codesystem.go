@@ -0,0 +1,333 @@
+package importer
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"github.com/mlapshin/fhirterm"
+	"io/ioutil"
+	"log"
+	"sort"
+	"strings"
+)
+
+type fhirCoding struct {
+	System string `json:"system"`
+	Code   string `json:"code"`
+}
+
+type fhirDesignation struct {
+	Language string      `json:"language"`
+	Use      *fhirCoding `json:"use"`
+	Value    string      `json:"value"`
+}
+
+type fhirConcept struct {
+	Code        string                   `json:"code"`
+	Display     string                   `json:"display"`
+	Abstract    bool                     `json:"abstract"`
+	Designation []fhirDesignation        `json:"designation"`
+	Property    []map[string]interface{} `json:"property"`
+	Concept     []fhirConcept            `json:"concept"`
+}
+
+type fhirCodeSystem struct {
+	ResourceType string        `json:"resourceType"`
+	Url          string        `json:"url"`
+	Name         string        `json:"name"`
+	Title        string        `json:"title"`
+	Version      string        `json:"version"`
+	Date         string        `json:"date"`
+	Concept      []fhirConcept `json:"concept"`
+}
+
+// DSTU2 ValueSet.codeSystem (and DSTU1 ValueSet.define) contain code
+// systems, published HL7 v2 tables and v3 vocabulary use them
+type fhirValueSetCodeSystem struct {
+	System  string        `json:"system"`
+	Version string        `json:"version"`
+	Concept []fhirConcept `json:"concept"`
+}
+
+type fhirValueSet struct {
+	ResourceType string                  `json:"resourceType"`
+	Name         string                  `json:"name"`
+	Version      string                  `json:"version"`
+	Date         string                  `json:"date"`
+	CodeSystem   *fhirValueSetCodeSystem `json:"codeSystem"`
+	Define       *fhirValueSetCodeSystem `json:"define"`
+}
+
+func (vs *fhirValueSet) toCodeSystem() (fhirCodeSystem, bool) {
+	define := vs.CodeSystem
+	if define == nil {
+		define = vs.Define
+	}
+
+	if vs.ResourceType != "ValueSet" || define == nil || define.System == "" {
+		return fhirCodeSystem{}, false
+	}
+
+	cs := fhirCodeSystem{
+		ResourceType: "CodeSystem",
+		Url:          define.System,
+		Name:         vs.Name,
+		Version:      define.Version,
+		Date:         vs.Date,
+		Concept:      define.Concept,
+	}
+
+	if cs.Version == "" {
+		cs.Version = vs.Version
+	}
+
+	return cs, true
+}
+
+type fhirBundle struct {
+	ResourceType string `json:"resourceType"`
+	Entry        []struct {
+		Resource json.RawMessage `json:"resource"`
+	} `json:"entry"`
+}
+
+// Property value is stored as text whatever its type is
+// (valueCode, valueString, valueInteger, valueCoding and so on)
+func fhirPropertyValue(p map[string]interface{}) (string, bool) {
+	keys := make([]string, 0, len(p))
+	for k := range p {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		if !strings.HasPrefix(k, "value") {
+			continue
+		}
+
+		switch v := p[k].(type) {
+		case string:
+			return v, true
+		case map[string]interface{}:
+			if code, ok := v["code"].(string); ok {
+				return code, true
+			}
+		default:
+			return fmt.Sprint(v), true
+		}
+	}
+
+	return "", false
+}
+
+// Returns CodeSystem resources (and code systems defined in ValueSet
+// resources) from single resource or Bundle, other resources are skipped
+func readFhirCodeSystems(f releaseFile) ([]fhirCodeSystem, error) {
+	file, err := f.open()
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	content, err := ioutil.ReadAll(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var bundle fhirBundle
+	err = json.Unmarshal(content, &bundle)
+	if err != nil {
+		return nil, err
+	}
+
+	resources := []json.RawMessage{content}
+	if bundle.ResourceType == "Bundle" {
+		resources = resources[:0]
+		for _, e := range bundle.Entry {
+			resources = append(resources, e.Resource)
+		}
+	}
+
+	result := make([]fhirCodeSystem, 0)
+	for _, r := range resources {
+		var cs fhirCodeSystem
+		err = json.Unmarshal(r, &cs)
+		if err != nil {
+			return nil, err
+		}
+
+		if cs.ResourceType == "CodeSystem" {
+			result = append(result, cs)
+			continue
+		}
+
+		var vs fhirValueSet
+		err = json.Unmarshal(r, &vs)
+		if err != nil {
+			return nil, err
+		}
+
+		if defined, ok := vs.toCodeSystem(); ok {
+			result = append(result, defined)
+		}
+	}
+
+	return result, nil
+}
+
+type fhirConceptInserter struct {
+	system       string
+	concepts     *sql.Stmt
+	properties   *sql.Stmt
+	designations *sql.Stmt
+	count        int
+}
+
+func (ins *fhirConceptInserter) insert(concepts []fhirConcept, parent string) error {
+	for _, c := range concepts {
+		_, err := ins.concepts.Exec(ins.system, c.Code, c.Display, parent)
+		if err != nil {
+			return err
+		}
+
+		// abstract concepts are marked like in later FHIR versions
+		if c.Abstract {
+			_, err = ins.properties.Exec(ins.system, c.Code, "notSelectable", "true")
+			if err != nil {
+				return err
+			}
+		}
+
+		for _, p := range c.Property {
+			code, _ := p["code"].(string)
+			value, found := fhirPropertyValue(p)
+
+			if code != "" && found {
+				_, err = ins.properties.Exec(ins.system, c.Code, code, value)
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		for _, d := range c.Designation {
+			use := ""
+			if d.Use != nil {
+				use = d.Use.Code
+			}
+
+			_, err = ins.designations.Exec(ins.system, c.Code, d.Language, use, d.Value)
+			if err != nil {
+				return err
+			}
+		}
+
+		ins.count++
+
+		err = ins.insert(c.Concept, c.Code)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func importFhirCodeSystem(tx *importTx, r *release, cs *fhirCodeSystem) error {
+	if cs.Url == "" {
+		return fmt.Errorf("CodeSystem %s has no url", cs.Name)
+	}
+
+	name := cs.Title
+	if name == "" {
+		name = cs.Name
+	}
+
+	err := resetCustomCodeSystem(tx, cs.Url, name)
+	if err != nil {
+		return err
+	}
+
+	for _, alias := range hl7UrlAliases(cs.Url) {
+		_, err = tx.Exec("DELETE FROM custom_code_system_aliases WHERE alias = ?", alias)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec("INSERT INTO custom_code_system_aliases VALUES (?, ?)", alias, cs.Url)
+		if err != nil {
+			return err
+		}
+	}
+
+	ins := &fhirConceptInserter{system: cs.Url}
+	ins.concepts, err = tx.Prepare("INSERT INTO custom_concepts VALUES (?, ?, ?, NULLIF(?, ''))")
+	if err == nil {
+		ins.properties, err = tx.Prepare("INSERT INTO custom_concept_properties VALUES (?, ?, ?, ?)")
+	}
+
+	if err == nil {
+		ins.designations, err = tx.Prepare("INSERT INTO custom_concept_designations VALUES (?, ?, NULLIF(?, ''), NULLIF(?, ''), ?)")
+	}
+
+	if err == nil {
+		err = ins.insert(cs.Concept, "")
+	}
+
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Imported %d concepts of %s", ins.count, cs.Url)
+
+	return recordRelease(tx, r, releaseInfo{
+		system:      cs.Url,
+		version:     cs.Version,
+		releaseDate: cs.Date,
+		rowCount:    ins.count,
+	})
+}
+
+// Imports CodeSystem resource or Bundle of them, every code system
+// becomes separate custom namespace
+func ImportFhirCodeSystems(db *fhirterm.DB, filePath string) error {
+	log.Printf("Importing FHIR CodeSystem resources from %s", filePath)
+
+	err := importRelease(db, filePath, func(tx *importTx, r *release) error {
+		f, found := r.single()
+		if !found {
+			return fmt.Errorf("%s contains more than one file", filePath)
+		}
+
+		return importFhirCodeSystems(tx, r, f)
+	})
+
+	if err != nil {
+		return fmt.Errorf("Error during importing FHIR CodeSystem: %s", err)
+	}
+
+	return nil
+}
+
+func importFhirCodeSystems(tx *importTx, r *release, f releaseFile) error {
+	codeSystems, err := readFhirCodeSystems(f)
+	if err != nil {
+		return err
+	} else if len(codeSystems) == 0 {
+		return fmt.Errorf("no CodeSystem resources found")
+	}
+
+	err = createCustomTables(tx)
+	if err != nil {
+		return err
+	}
+
+	for i := range codeSystems {
+		err = importFhirCodeSystem(tx, r, &codeSystems[i])
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
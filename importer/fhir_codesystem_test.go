@@ -0,0 +1,201 @@
+package importer
+
+import (
+	"encoding/json"
+	"github.com/mlapshin/fhirterm"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const fhirCodeSystemJson = `{"resourceType": "CodeSystem", "url": "http://example.org/lab-codes",
+  "name": "LabCodes", "title": "Lab codes", "version": "1.0", "date": "2024-01-15",
+  "concept": [
+    {"code": "LAB", "display": "Laboratory", "abstract": true, "concept": [
+      {"code": "CHEM", "display": "Chemistry", "concept": [
+        {"code": "GLU", "display": "Glucose",
+         "designation": [{"language": "de", "use": {"code": "display"}, "value": "Glukose"}],
+         "property": [{"code": "specimen", "valueCode": "Serum"}, {"code": "rank", "valueInteger": 2},
+                      {"code": "empty"}]}]}]},
+    {"code": "OTHER", "display": "Other"}]}`
+
+func Test_FhirPropertyValue(t *testing.T) {
+	assert := assert.New(t)
+
+	tests := []struct {
+		property string
+		value    string
+		found    bool
+	}{
+		{`{"code": "specimen", "valueCode": "Serum"}`, "Serum", true},
+		{`{"code": "label", "valueString": "Blood sugar"}`, "Blood sugar", true},
+		{`{"code": "rank", "valueInteger": 2}`, "2", true},
+		{`{"code": "ratio", "valueDecimal": 0.5}`, "0.5", true},
+		{`{"code": "active", "valueBoolean": false}`, "false", true},
+		{`{"code": "parent", "valueCoding": {"system": "http://example.org", "code": "LAB"}}`, "LAB", true},
+		{`{"code": "empty"}`, "", false},
+	}
+
+	for _, test := range tests {
+		var p map[string]interface{}
+		assert.Nil(json.Unmarshal([]byte(test.property), &p))
+
+		value, found := fhirPropertyValue(p)
+		assert.Equal(test.value, value, test.property)
+		assert.Equal(test.found, found, test.property)
+	}
+}
+
+func Test_FhirValueSetToCodeSystem(t *testing.T) {
+	assert := assert.New(t)
+
+	tests := []struct {
+		valueSet string
+		url      string
+		version  string
+		found    bool
+	}{
+		{`{"resourceType": "ValueSet", "version": "2.0",
+       "codeSystem": {"system": "http://hl7.org/fhir/v2/0001", "version": "2.8", "concept": [{"code": "F"}]}}`,
+			"http://hl7.org/fhir/v2/0001", "2.8", true},
+		{`{"resourceType": "ValueSet", "version": "2.0",
+       "codeSystem": {"system": "http://hl7.org/fhir/v2/0001", "concept": [{"code": "F"}]}}`,
+			"http://hl7.org/fhir/v2/0001", "2.0", true},
+		{`{"resourceType": "ValueSet", "define": {"system": "http://hl7.org/fhir/v3/Gender", "concept": [{"code": "M"}]}}`,
+			"http://hl7.org/fhir/v3/Gender", "", true},
+		{`{"resourceType": "ValueSet", "compose": {"include": [{"system": "http://loinc.org"}]}}`, "", "", false},
+		{`{"resourceType": "ValueSet", "codeSystem": {"concept": [{"code": "F"}]}}`, "", "", false},
+		{`{"resourceType": "Patient", "codeSystem": {"system": "http://example.org"}}`, "", "", false},
+	}
+
+	for _, test := range tests {
+		var vs fhirValueSet
+		assert.Nil(json.Unmarshal([]byte(test.valueSet), &vs))
+
+		cs, found := vs.toCodeSystem()
+		assert.Equal(test.found, found, test.valueSet)
+
+		if test.found {
+			assert.Equal("CodeSystem", cs.ResourceType)
+			assert.Equal(test.url, cs.Url)
+			assert.Equal(test.version, cs.Version, test.valueSet)
+			assert.Len(cs.Concept, 1)
+		}
+	}
+}
+
+func Test_ReadFhirCodeSystems(t *testing.T) {
+	assert := assert.New(t)
+
+	dir := writeTestRelease(t, map[string]string{
+		"codesystem.json": fhirCodeSystemJson,
+		"bundle.json": `{"resourceType": "Bundle", "entry": [
+       {"resource": ` + fhirCodeSystemJson + `},
+       {"resource": {"resourceType": "Patient", "id": "1"}},
+       {"resource": {"resourceType": "ValueSet", "name": "v2 Sex",
+                     "codeSystem": {"system": "http://hl7.org/fhir/v2/0001", "concept": [{"code": "F"}]}}},
+       {"resource": {"resourceType": "ValueSet", "compose": {}}}]}`,
+		"patient.json": `{"resourceType": "Patient"}`,
+		"broken.json":  `{"resourceType": `,
+	})
+	defer os.RemoveAll(dir)
+
+	read := func(name string) ([]fhirCodeSystem, error) {
+		r, err := openRelease(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.close()
+
+		f, _ := r.single()
+		return readFhirCodeSystems(f)
+	}
+
+	codeSystems, err := read("codesystem.json")
+	assert.Nil(err)
+	assert.Len(codeSystems, 1)
+	assert.Equal("http://example.org/lab-codes", codeSystems[0].Url)
+	assert.Equal("GLU", codeSystems[0].Concept[0].Concept[0].Concept[0].Code)
+
+	codeSystems, err = read("bundle.json")
+	assert.Nil(err)
+	assert.Len(codeSystems, 2, "resources other than CodeSystem and ValueSet with code system are skipped")
+	assert.Equal("http://example.org/lab-codes", codeSystems[0].Url)
+	assert.Equal("http://hl7.org/fhir/v2/0001", codeSystems[1].Url)
+	assert.Equal("v2 Sex", codeSystems[1].Name)
+
+	codeSystems, err = read("patient.json")
+	assert.Nil(err)
+	assert.Len(codeSystems, 0)
+
+	_, err = read("broken.json")
+	assert.NotNil(err)
+}
+
+func Test_ImportFhirCodeSystems(t *testing.T) {
+	assert := assert.New(t)
+	db, closeDb := openTestDb(t)
+	defer closeDb()
+
+	system := "http://example.org/lab-codes"
+	dir := writeTestRelease(t, map[string]string{
+		"bundle.json": `{"resourceType": "Bundle", "entry": [
+       {"resource": ` + fhirCodeSystemJson + `},
+       {"resource": {"resourceType": "CodeSystem", "url": "http://terminology.hl7.org/CodeSystem/v2-0001",
+                     "name": "AdministrativeSex", "concept": [{"code": "F", "display": "Female"}]}}]}`,
+		"patient.json": `{"resourceType": "Patient"}`,
+		"nourl.json":   `{"resourceType": "CodeSystem", "name": "NoUrl"}`,
+	})
+	defer os.RemoveAll(dir)
+
+	assert.Nil(ImportFhirCodeSystems(db, filepath.Join(dir, "bundle.json")))
+
+	rows, err := db.Query("SELECT code, COALESCE(parent_code, '') FROM custom_concepts WHERE system = ? ORDER BY code", system)
+	assert.Nil(err)
+
+	parents := make(map[string]string)
+	for rows.Next() {
+		var code, parent string
+		assert.Nil(rows.Scan(&code, &parent))
+		parents[code] = parent
+	}
+	rows.Close()
+
+	assert.Equal(map[string]string{"LAB": "", "CHEM": "LAB", "GLU": "CHEM", "OTHER": ""}, parents,
+		"nested concepts are flattened with parent codes")
+
+	ns := fhirterm.NewCustomNamespace(db, system)
+	concept, err := ns.Lookup("GLU", "de")
+	assert.Nil(err)
+	assert.Equal("Glukose", concept.Display)
+	assert.Equal([]fhirterm.NsProperty{{Code: "parent", Value: "CHEM"}, {Code: "rank", Value: "2"},
+		{Code: "specimen", Value: "Serum"}}, concept.Properties)
+
+	contains, err := ns.Filter(&fhirterm.NsFilter{Include: [][]fhirterm.NsPredicate{{}}})
+	assert.Nil(err)
+	codes := make([]string, 0, len(contains))
+	for _, c := range contains {
+		codes = append(codes, c.Code)
+	}
+	assert.Equal([]string{"CHEM", "GLU", "OTHER"}, codes, "abstract concepts are not expanded")
+
+	var name, alias string
+	assert.Nil(db.QueryRow("SELECT name FROM custom_code_systems WHERE system = ?", system).Scan(&name))
+	assert.Equal("Lab codes", name, "title is preferred to name")
+	assert.Nil(db.QueryRow("SELECT alias FROM custom_code_system_aliases WHERE system = ?",
+		"http://terminology.hl7.org/CodeSystem/v2-0001").Scan(&alias))
+	assert.Equal("http://hl7.org/fhir/v2/0001", alias)
+
+	versions, err := fhirterm.ReadTerminologyVersions(db)
+	assert.Nil(err)
+	assert.Equal("1.0", versions[system].Version)
+	assert.Equal("2024-01-15", versions[system].ReleaseDate)
+	assert.Equal(4, versions[system].RowCount)
+
+	err = ImportFhirCodeSystems(db, filepath.Join(dir, "patient.json"))
+	assert.EqualError(err, "Error during importing FHIR CodeSystem: no CodeSystem resources found")
+
+	err = ImportFhirCodeSystems(db, filepath.Join(dir, "nourl.json"))
+	assert.EqualError(err, "Error during importing FHIR CodeSystem: CodeSystem NoUrl has no url")
+}
@@ -0,0 +1,61 @@
+package importer
+
+import (
+	"fmt"
+	"github.com/mlapshin/fhirterm"
+	"log"
+	"regexp"
+	"strings"
+)
+
+// HL7 v2 tables and v3 code systems changed their URLs in FHIR R4,
+// both forms are resolvable after import
+var hl7LegacyUrlRegexp = regexp.MustCompile("^http://hl7\\.org/fhir/(v[23])/(.+)$")
+var hl7TerminologyUrlRegexp = regexp.MustCompile("^http://terminology\\.hl7\\.org/CodeSystem/(v[23])-(.+)$")
+
+func hl7UrlAliases(url string) []string {
+	if m := hl7LegacyUrlRegexp.FindStringSubmatch(url); m != nil {
+		return []string{fmt.Sprintf("http://terminology.hl7.org/CodeSystem/%s-%s", m[1], m[2])}
+	}
+
+	if m := hl7TerminologyUrlRegexp.FindStringSubmatch(url); m != nil {
+		return []string{fmt.Sprintf("http://hl7.org/fhir/%s/%s", m[1], m[2])}
+	}
+
+	return nil
+}
+
+var hl7DefinitionFiles = []string{"v2-tables.json", "v3-codesystems.json"}
+
+// Imports HL7 v2 tables and v3 code systems from FHIR definitions:
+// either definitions.json.zip archive or v2-tables.json and
+// v3-codesystems.json files themselves
+func ImportHl7(db *fhirterm.DB, filePath string) error {
+	log.Printf("Importing HL7 v2 tables and v3 code systems")
+
+	err := importRelease(db, filePath, func(tx *importTx, r *release) error {
+		if f, found := r.single(); found {
+			return importFhirCodeSystems(tx, r, f)
+		}
+
+		for _, name := range hl7DefinitionFiles {
+			f, found := r.find("(?i)/" + strings.Replace(name, ".", "\\.", -1) + "$")
+			if !found {
+				return fmt.Errorf("Could not find file %s in definitions archive", name)
+			}
+
+			err := importFhirCodeSystems(tx, r, f)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return fmt.Errorf("Error during importing HL7 code systems: %s", err)
+	}
+
+	return nil
+}
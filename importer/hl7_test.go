@@ -0,0 +1,75 @@
+package importer
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+func Test_Hl7UrlAliases(t *testing.T) {
+	assert := assert.New(t)
+
+	tests := []struct {
+		url     string
+		aliases []string
+	}{
+		{"http://hl7.org/fhir/v2/0203", []string{"http://terminology.hl7.org/CodeSystem/v2-0203"}},
+		{"http://hl7.org/fhir/v2/0203/2.7", []string{"http://terminology.hl7.org/CodeSystem/v2-0203/2.7"}},
+		{"http://hl7.org/fhir/v3/ActCode", []string{"http://terminology.hl7.org/CodeSystem/v3-ActCode"}},
+		{"http://terminology.hl7.org/CodeSystem/v2-0001", []string{"http://hl7.org/fhir/v2/0001"}},
+		{"http://terminology.hl7.org/CodeSystem/v3-NullFlavor", []string{"http://hl7.org/fhir/v3/NullFlavor"}},
+		{"http://terminology.hl7.org/CodeSystem/observation-category", nil},
+		{"http://hl7.org/fhir/administrative-gender", nil},
+		{"http://hl7.org/fhir/v2/", nil},
+		{"https://hl7.org/fhir/v2/0203", nil},
+		{"http://loinc.org", nil},
+	}
+
+	for _, test := range tests {
+		assert.Equal(test.aliases, hl7UrlAliases(test.url), test.url)
+	}
+}
+
+func Test_ImportHl7(t *testing.T) {
+	assert := assert.New(t)
+	db, closeDb := openTestDb(t)
+	defer closeDb()
+
+	dir := writeTestRelease(t, map[string]string{
+		"definitions/v2-tables.json": `{"resourceType": "Bundle", "entry": [{"resource": {
+       "resourceType": "ValueSet", "name": "v2 Administrative Sex",
+       "codeSystem": {"system": "http://hl7.org/fhir/v2/0001", "concept": [{"code": "F", "display": "Female"}]}}}]}`,
+		"definitions/v3-codesystems.json": `{"resourceType": "Bundle", "entry": [{"resource": {
+       "resourceType": "CodeSystem", "url": "http://terminology.hl7.org/CodeSystem/v3-NullFlavor",
+       "name": "NullFlavor", "concept": [{"code": "UNK", "display": "unknown"}]}}]}`,
+		"definitions/profiles-resources.json": `{"resourceType": "Bundle", "entry": []}`,
+		"incomplete/v3-codesystems.json":      `{"resourceType": "Bundle", "entry": []}`,
+		"incomplete/valuesets.json":           `{"resourceType": "Bundle", "entry": []}`,
+	})
+	defer os.RemoveAll(dir)
+
+	assert.Nil(ImportHl7(db, filepath.Join(dir, "definitions")))
+
+	rows, err := db.Query("SELECT alias, system FROM custom_code_system_aliases")
+	assert.Nil(err)
+
+	aliases := make([]string, 0)
+	for rows.Next() {
+		var alias, system string
+		assert.Nil(rows.Scan(&alias, &system))
+		aliases = append(aliases, alias+" -> "+system)
+	}
+	rows.Close()
+
+	sort.Strings(aliases)
+	assert.Equal([]string{
+		"http://hl7.org/fhir/v3/NullFlavor -> http://terminology.hl7.org/CodeSystem/v3-NullFlavor",
+		"http://terminology.hl7.org/CodeSystem/v2-0001 -> http://hl7.org/fhir/v2/0001",
+	}, aliases)
+
+	err = ImportHl7(db, filepath.Join(dir, "incomplete"))
+	assert.EqualError(err, "Error during importing HL7 code systems: "+
+		"Could not find file v2-tables.json in definitions archive")
+}
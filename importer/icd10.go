@@ -0,0 +1,400 @@
+package importer
+
+import (
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"github.com/mlapshin/fhirterm"
+	"html"
+	"io"
+	"log"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// Both ICD-10 and ICD-10-CM live in one table, distinguished by system
+const createIcd10TableStmt = `
+CREATE TABLE IF NOT EXISTS icd10_concepts
+(
+  system character varying,
+  code character varying,
+  display text,
+  kind character varying,
+  parent_code character varying
+)`
+
+var createIcd10IndexStmts = []string{
+	"CREATE INDEX IF NOT EXISTS icd10_concepts_on_system_code_idx ON icd10_concepts(system, code)",
+	"CREATE INDEX IF NOT EXISTS icd10_concepts_on_system_parent_code_idx ON icd10_concepts(system, parent_code)",
+}
+
+type icd10Concept struct {
+	code    string
+	display string
+	kind    string // chapter, block or category
+	parent  string
+}
+
+// ICD-10-CM tabular XML (icd10cm_tabular_YYYY.xml)
+type icd10cmDiag struct {
+	Name  string        `xml:"name"`
+	Desc  string        `xml:"desc"`
+	Diags []icd10cmDiag `xml:"diag"`
+}
+
+type icd10cmSection struct {
+	Id    string        `xml:"id,attr"`
+	Desc  string        `xml:"desc"`
+	Diags []icd10cmDiag `xml:"diag"`
+}
+
+type icd10cmChapter struct {
+	Name     string           `xml:"name"`
+	Desc     string           `xml:"desc"`
+	Sections []icd10cmSection `xml:"section"`
+}
+
+type icd10cmTabular struct {
+	Chapters []icd10cmChapter `xml:"chapter"`
+}
+
+// WHO ICD-10 ClaML
+type clamlLabel struct {
+	Lang    string `xml:"http://www.w3.org/XML/1998/namespace lang,attr"`
+	Content string `xml:",innerxml"`
+}
+
+type clamlRubric struct {
+	Kind   string       `xml:"kind,attr"`
+	Labels []clamlLabel `xml:"Label"`
+}
+
+type clamlSuperClass struct {
+	Code string `xml:"code,attr"`
+}
+
+type clamlClass struct {
+	Code         string            `xml:"code,attr"`
+	Kind         string            `xml:"kind,attr"`
+	SuperClasses []clamlSuperClass `xml:"SuperClass"`
+	Rubrics      []clamlRubric     `xml:"Rubric"`
+}
+
+var xmlTagRegexp = regexp.MustCompile("<[^>]*>")
+
+// Year of release in file name, like icd10cm_order_2024.txt
+var icd10VersionRegexp = regexp.MustCompile("\\d{4}")
+
+// ICD-10-CM order file stores codes without dots
+func icd10cmDottedCode(code string) string {
+	if len(code) > 3 && !strings.Contains(code, ".") {
+		return code[0:3] + "." + code[3:]
+	}
+
+	return code
+}
+
+func flattenIcd10cmDiags(diags []icd10cmDiag, parent string, result []icd10Concept) []icd10Concept {
+	for _, d := range diags {
+		code := strings.TrimSpace(d.Name)
+		result = append(result, icd10Concept{
+			code:    code,
+			display: strings.TrimSpace(d.Desc),
+			kind:    "category",
+			parent:  parent,
+		})
+
+		result = flattenIcd10cmDiags(d.Diags, code, result)
+	}
+
+	return result
+}
+
+func parseIcd10cmTabular(r io.Reader) ([]icd10Concept, error) {
+	var tabular icd10cmTabular
+	err := xml.NewDecoder(r).Decode(&tabular)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]icd10Concept, 0)
+	for _, ch := range tabular.Chapters {
+		chapter := strings.TrimSpace(ch.Name)
+		result = append(result, icd10Concept{
+			code:    chapter,
+			display: strings.TrimSpace(ch.Desc),
+			kind:    "chapter",
+		})
+
+		for _, s := range ch.Sections {
+			result = append(result, icd10Concept{
+				code:    s.Id,
+				display: strings.TrimSpace(s.Desc),
+				kind:    "block",
+				parent:  chapter,
+			})
+
+			result = flattenIcd10cmDiags(s.Diags, s.Id, result)
+		}
+	}
+
+	return result, nil
+}
+
+func clamlPreferredLabel(c *clamlClass) string {
+	for _, r := range c.Rubrics {
+		if r.Kind == "preferred" && len(r.Labels) > 0 {
+			text := xmlTagRegexp.ReplaceAllString(r.Labels[0].Content, "")
+			return strings.Join(strings.Fields(html.UnescapeString(text)), " ")
+		}
+	}
+
+	return ""
+}
+
+// Classes are decoded one by one, ClaML files are rather large
+func parseClaml(r io.Reader) ([]icd10Concept, error) {
+	decoder := xml.NewDecoder(r)
+	result := make([]icd10Concept, 0)
+
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		start, ok := token.(xml.StartElement)
+		if !ok || start.Name.Local != "Class" {
+			continue
+		}
+
+		var class clamlClass
+		err = decoder.DecodeElement(&class, &start)
+		if err != nil {
+			return nil, err
+		}
+
+		concept := icd10Concept{
+			code:    class.Code,
+			display: clamlPreferredLabel(&class),
+			kind:    class.Kind,
+		}
+
+		if len(class.SuperClasses) > 0 {
+			concept.parent = class.SuperClasses[0].Code
+		}
+
+		if concept.kind != "chapter" && concept.kind != "block" {
+			concept.kind = "category"
+		}
+
+		result = append(result, concept)
+	}
+
+	return result, nil
+}
+
+// Parses fixed-width icd10cm_order_YYYY.txt, parent of every code
+// is its longest prefix present in the file
+func parseIcd10cmOrder(r io.Reader) ([]icd10Concept, error) {
+	scanner := bufio.NewScanner(r)
+	result := make([]icd10Concept, 0)
+	known := make(map[string]bool)
+
+	for scanner.Scan() {
+		line := scanner.Text()
+		if len(line) < 16 {
+			continue
+		}
+
+		code := strings.TrimSpace(line[6:13])
+		display := ""
+		if len(line) > 77 {
+			display = strings.TrimSpace(line[77:])
+		} else if len(line) > 16 {
+			display = strings.TrimSpace(line[16:])
+		}
+
+		parent := ""
+		for l := len(code) - 1; l >= 3; l-- {
+			if known[code[0:l]] {
+				parent = icd10cmDottedCode(code[0:l])
+				break
+			}
+		}
+
+		known[code] = true
+		result = append(result, icd10Concept{
+			code:    icd10cmDottedCode(code),
+			display: display,
+			kind:    "category",
+			parent:  parent,
+		})
+	}
+
+	return result, scanner.Err()
+}
+
+func insertIcd10Concepts(tx *importTx, system string, concepts []icd10Concept) error {
+	_, err := tx.Exec(tx.withRowid(createIcd10TableStmt))
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM icd10_concepts WHERE system = ?", system)
+	if err != nil {
+		return err
+	}
+
+	stmt, err := tx.Prepare("INSERT INTO icd10_concepts VALUES (?, ?, ?, ?, NULLIF(?, ''))")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, c := range concepts {
+		_, err = stmt.Exec(system, c.code, c.display, c.kind, c.parent)
+		if err != nil {
+			return fmt.Errorf("cannot insert %s: %s", c.code, err)
+		}
+	}
+
+	for _, s := range createIcd10IndexStmts {
+		err = execStmt(tx, s, "")
+		if err != nil {
+			return err
+		}
+	}
+
+	log.Printf("Imported %d %s concepts", len(concepts), system)
+	return nil
+}
+
+// Tabular XML has chapters and blocks, but doesn't list codes with
+// 7th character extensions, order file lists every code, but has
+// no chapters and blocks. So chapters and blocks are taken from
+// tabular XML and top-level categories of order file are linked
+// to blocks containing them.
+func mergeIcd10cmTabularAndOrder(tabular []icd10Concept, order []icd10Concept) []icd10Concept {
+	result := make([]icd10Concept, 0, len(order))
+	blocks := make(map[string]bool)
+	categoryBlocks := make(map[string]string)
+
+	for _, c := range tabular {
+		switch {
+		case c.kind == "chapter":
+			result = append(result, c)
+		case c.kind == "block":
+			blocks[c.code] = true
+			result = append(result, c)
+		case blocks[c.parent]:
+			categoryBlocks[c.code] = c.parent
+		}
+	}
+
+	for _, c := range order {
+		if c.parent == "" {
+			c.parent = categoryBlocks[c.code]
+		}
+
+		result = append(result, c)
+	}
+
+	return result
+}
+
+// Detects format by file content: ClaML and ICD-10-CM tabular are
+// XML files, anything else is treated as ICD-10-CM order file
+func parseIcd10File(f releaseFile) ([]icd10Concept, error) {
+	log.Printf("Importing %s", f.name)
+
+	file, err := f.open()
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	head, _ := reader.Peek(4096)
+
+	switch {
+	case strings.Contains(string(head), "<ClaML"):
+		return parseClaml(reader)
+	case strings.Contains(string(head), "<ICD10CM.tabular"):
+		return parseIcd10cmTabular(reader)
+	}
+
+	return parseIcd10cmOrder(reader)
+}
+
+const (
+	icd10cmOrderPattern   = "(?i)icd10cm[_-]order[_-]\\d{4}\\.txt$"
+	icd10cmTabularPattern = "(?i)icd10cm[_-]tabular[_-]\\d{4}\\.xml$"
+)
+
+// ICD-10-CM archives contain both tabular XML and order file, WHO
+// ICD-10 is distributed as ClaML
+func readIcd10Release(r *release) ([]icd10Concept, error) {
+	if f, found := r.single(); found {
+		return parseIcd10File(f)
+	}
+
+	orderFile, hasOrder := r.find(icd10cmOrderPattern)
+	tabularFile, hasTabular := r.find(icd10cmTabularPattern)
+
+	switch {
+	case hasOrder && hasTabular:
+		tabular, err := parseIcd10File(tabularFile)
+		if err != nil {
+			return nil, err
+		}
+
+		order, err := parseIcd10File(orderFile)
+		if err != nil {
+			return nil, err
+		}
+
+		return mergeIcd10cmTabularAndOrder(tabular, order), nil
+	case hasOrder:
+		return parseIcd10File(orderFile)
+	case hasTabular:
+		return parseIcd10File(tabularFile)
+	}
+
+	if f, found := r.find("(?i)\\.xml$"); found {
+		return parseIcd10File(f)
+	}
+
+	return nil, fmt.Errorf("Could not find ClaML, tabular or order file in archive")
+}
+
+func ImportIcd10(db *fhirterm.DB, filePath string, system string) error {
+	log.Printf("Importing %s dataset", system)
+
+	err := importRelease(db, filePath, func(tx *importTx, r *release) error {
+		concepts, err := readIcd10Release(r)
+		if err != nil {
+			return err
+		}
+
+		err = insertIcd10Concepts(tx, system, concepts)
+		if err != nil {
+			return err
+		}
+
+		return recordRelease(tx, r, releaseInfo{
+			system:   system,
+			version:  icd10VersionRegexp.FindString(path.Base(filePath)),
+			rowCount: len(concepts),
+		})
+	})
+
+	if err != nil {
+		return fmt.Errorf("Error during importing %s: %s", system, err)
+	} else {
+		return nil
+	}
+}
@@ -0,0 +1,182 @@
+package importer
+
+import (
+	"fmt"
+	"github.com/mlapshin/fhirterm"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const icd10cmTabularXml = `<?xml version="1.0" encoding="utf-8"?>
+<ICD10CM.tabular>
+  <version>2024</version>
+  <chapter>
+    <name>4</name>
+    <desc>Endocrine, nutritional and metabolic diseases (E00-E89)</desc>
+    <section id="E08-E13">
+      <desc>Diabetes mellitus (E08-E13)</desc>
+      <diag>
+        <name>E08</name>
+        <desc>Diabetes mellitus due to underlying condition</desc>
+        <diag>
+          <name>E08.0</name>
+          <desc>Diabetes mellitus due to underlying condition with hyperosmolarity</desc>
+        </diag>
+      </diag>
+    </section>
+  </chapter>
+</ICD10CM.tabular>`
+
+// Fixed-width lines of icd10cm_order_YYYY.txt
+func icd10cmOrderLines(rows ...[3]string) string {
+	lines := make([]string, 0, len(rows))
+	for i, r := range rows {
+		lines = append(lines, fmt.Sprintf("%05d %-7s %s %-60.60s %s", i+1, r[0], r[1], r[2], r[2]))
+	}
+
+	return strings.Join(lines, "\n") + "\n"
+}
+
+func Test_ImportIcd10cmTabularAndOrder(t *testing.T) {
+	assert := assert.New(t)
+	db, closeDb := openTestDb(t)
+	defer closeDb()
+
+	dir := writeTestRelease(t, map[string]string{
+		"icd10cm_2024/icd10cm_tabular_2024.xml": icd10cmTabularXml,
+		"icd10cm_2024/icd10cm_order_2024.txt": icd10cmOrderLines(
+			[3]string{"E08", "0", "Diabetes due to underlying condition"},
+			[3]string{"E080", "0", "Diabetes due to underlying condition w hyprosm"},
+			[3]string{"E0800", "1", "Diab due to undrl cond w hyprosm w/o nonket hyprgly-hypros coma"},
+			[3]string{"E09", "0", "Drug or chemical induced diabetes mellitus"},
+		),
+	})
+	defer os.RemoveAll(dir)
+
+	assert.Nil(ImportIcd10(db, filepath.Join(dir, "icd10cm_2024"), fhirterm.Icd10CmUrl))
+
+	ns := fhirterm.NewIcd10Namespace(db, fhirterm.Icd10CmUrl)
+	codes := func(op string, value string) []string {
+		contains, err := ns.Filter(&fhirterm.NsFilter{
+			Include: [][]fhirterm.NsPredicate{{{Property: "concept", Op: op, Value: value}}},
+		})
+		assert.Nil(err)
+
+		result := make([]string, 0, len(contains))
+		for _, c := range contains {
+			result = append(result, c.Code)
+		}
+
+		return result
+	}
+
+	assert.Equal([]string{"E08", "E08.0", "E08.00"}, codes("descendent-of", "E08-E13"),
+		"codes from order file are linked to blocks from tabular XML")
+	assert.Equal([]string{"E08", "E08.0", "E08.00"}, codes("is-a", "4"), "chapters and blocks are not expanded")
+	assert.Equal([]string{"E09"}, codes("=", "E09"), "code missing in tabular XML has no block")
+
+	versions, err := fhirterm.ReadTerminologyVersions(db)
+	assert.Nil(err)
+	assert.Equal("2024", versions[fhirterm.Icd10CmUrl].Version)
+}
+
+const clamlXml = `<?xml version="1.0" encoding="UTF-8"?>
+<ClaML version="2.0.0">
+  <Title name="ICD-10" version="2019">International Classification of Diseases</Title>
+  <Class code="I" kind="chapter">
+    <SubClass code="A00-A09"/>
+    <Rubric kind="preferred"><Label xml:lang="en">Certain infectious and parasitic diseases</Label></Rubric>
+  </Class>
+  <Class code="A00-A09" kind="block">
+    <SuperClass code="I"/>
+    <Rubric kind="preferred"><Label xml:lang="en">Intestinal infectious diseases</Label></Rubric>
+  </Class>
+  <Class code="A00" kind="category">
+    <SuperClass code="A00-A09"/>
+    <Rubric kind="inclusion"><Label xml:lang="en">Asiatic cholera</Label></Rubric>
+    <Rubric kind="preferred"><Label xml:lang="en">Cholera</Label></Rubric>
+  </Class>
+  <Class code="A00.0" kind="category">
+    <SuperClass code="A00"/>
+    <Rubric kind="preferred">
+      <Label xml:lang="en">Cholera due to <Reference>Vibrio cholerae</Reference>
+        01, biovar cholerae &amp; classical</Label>
+    </Rubric>
+  </Class>
+  <Class code="A01.0" kind="modifiedcategory">
+    <SuperClass code="A01"/>
+  </Class>
+</ClaML>`
+
+func Test_ParseIcd10Files(t *testing.T) {
+	assert := assert.New(t)
+
+	tests := []struct {
+		name     string
+		parse    func(io.Reader) ([]icd10Concept, error)
+		input    string
+		expected []icd10Concept
+	}{
+		{"ICD-10-CM tabular", parseIcd10cmTabular, icd10cmTabularXml, []icd10Concept{
+			{"4", "Endocrine, nutritional and metabolic diseases (E00-E89)", "chapter", ""},
+			{"E08-E13", "Diabetes mellitus (E08-E13)", "block", "4"},
+			{"E08", "Diabetes mellitus due to underlying condition", "category", "E08-E13"},
+			{"E08.0", "Diabetes mellitus due to underlying condition with hyperosmolarity", "category", "E08"},
+		}},
+		{"ClaML", parseClaml, clamlXml, []icd10Concept{
+			{"I", "Certain infectious and parasitic diseases", "chapter", ""},
+			{"A00-A09", "Intestinal infectious diseases", "block", "I"},
+			{"A00", "Cholera", "category", "A00-A09"},
+			{"A00.0", "Cholera due to Vibrio cholerae 01, biovar cholerae & classical", "category", "A00"},
+			{"A01.0", "", "category", "A01"},
+		}},
+		{"ICD-10-CM order", parseIcd10cmOrder, icd10cmOrderLines(
+			[3]string{"A00", "0", "Cholera"},
+			[3]string{"A000", "1", "Cholera due to Vibrio cholerae 01, biovar cholerae"},
+			[3]string{"E08", "0", "Diabetes due to underlying condition"},
+			[3]string{"E0811", "1", "Diabetes due to underlying condition w hyperglycemia"},
+		) + "00005 Z00     0 General exam\n\n00006 short\n", []icd10Concept{
+			{"A00", "Cholera", "category", ""},
+			{"A00.0", "Cholera due to Vibrio cholerae 01, biovar cholerae", "category", "A00"},
+			{"E08", "Diabetes due to underlying condition", "category", ""},
+			{"E08.11", "Diabetes due to underlying condition w hyperglycemia", "category", "E08"},
+			{"Z00", "General exam", "category", ""},
+		}},
+	}
+
+	for _, test := range tests {
+		concepts, err := test.parse(strings.NewReader(test.input))
+		assert.Nil(err, test.name)
+		assert.Equal(test.expected, concepts, test.name)
+	}
+
+	_, err := parseIcd10cmTabular(strings.NewReader("<ICD10CM.tabular><chapter>"))
+	assert.NotNil(err)
+
+	_, err = parseClaml(strings.NewReader("<ClaML><Class code=\"A00\">"))
+	assert.NotNil(err)
+}
+
+func Test_MergeIcd10cmTabularAndOrder(t *testing.T) {
+	assert := assert.New(t)
+
+	tabular, err := parseIcd10cmTabular(strings.NewReader(icd10cmTabularXml))
+	assert.Nil(err)
+
+	order, err := parseIcd10cmOrder(strings.NewReader(icd10cmOrderLines(
+		[3]string{"E08", "0", "Diabetes due to underlying condition"},
+		[3]string{"E0800", "1", "Diab due to undrl cond w hyprosm w/o nonket hyprgly-hypros coma"},
+	)))
+	assert.Nil(err)
+
+	assert.Equal([]icd10Concept{
+		{"4", "Endocrine, nutritional and metabolic diseases (E00-E89)", "chapter", ""},
+		{"E08-E13", "Diabetes mellitus (E08-E13)", "block", "4"},
+		{"E08", "Diabetes due to underlying condition", "category", "E08-E13"},
+		{"E08.00", "Diab due to undrl cond w hyprosm w/o nonket hyprgly-hypros coma", "category", "E08"},
+	}, mergeIcd10cmTabularAndOrder(tabular, order))
+}
@@ -3,40 +3,152 @@ package importer
 import (
 	"database/sql"
 	"encoding/csv"
+	"fmt"
+	"github.com/mlapshin/fhirterm"
 	"io"
-	"io/ioutil"
 	"log"
-	"os"
-	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
 )
 
-type unzipCallback func(extractedPath string) error
+const createVersionsTableStmt = `
+CREATE TABLE IF NOT EXISTS terminology_versions
+(
+  system character varying NOT NULL primary key,
+  version character varying,
+  imported_at character varying,
+  release_date character varying,
+  row_count integer,
+  checksum character varying,
+  source character varying
+)`
 
-func unpackZipArchive(zipPath string, callback unzipCallback) error {
-	tempPath, err := ioutil.TempDir("", "fhirterm-import")
+// Columns added to terminology_versions tables created by
+// previous fhirterm versions
+var versionsTableNewColumns = []string{
+	"release_date character varying",
+	"row_count integer",
+	"checksum character varying",
+	"source character varying",
+}
+
+// Transaction of import, statements are written for SQLite and
+// rewritten for PostgreSQL by its connections
+type importTx struct {
+	*sql.Tx
+	dialect *fhirterm.Dialect
+	// see fhirterm.DB.ReplaceRelease
+	replaceRelease bool
+	// not nil when tables are loaded into staging schema
+	staging *stagingTx
+}
+
+func (tx *importTx) Exec(query string, args ...interface{}) (sql.Result, error) {
+	if tx.staging != nil {
+		return tx.staging.exec(tx.Tx, query, args...)
+	}
+
+	return tx.Tx.Exec(query, args...)
+}
+
+// Adds rowid column to statement creating table which rows are
+// identified by rowid, see fhirterm.Dialect.RowidColumn
+func (tx *importTx) withRowid(createStmt string) string {
+	i := strings.LastIndex(createStmt, ")")
+	return strings.TrimRight(createStmt[:i], " \n") + tx.dialect.RowidColumn + "\n" + createStmt[i:]
+}
+
+// Import is loaded in single transaction into copy of SQLite
+// database or into staging schema of PostgreSQL database (see
+// staging.go), so failed import leaves database untouched. Server
+// must be restarted after import: it loads code systems and their
+// versions at startup and keeps replaced SQLite file open.
+func inTransaction(db *fhirterm.DB, callback func(tx *importTx) error) error {
+	if db.File != "" {
+		return importSqliteCopy(db, callback)
+	}
+
+	return loadInTransaction(db, callback)
+}
+
+func loadInTransaction(db *fhirterm.DB, callback func(tx *importTx) error) error {
+	sqlTx, err := db.Begin()
 	if err != nil {
 		return err
 	}
 
-	defer os.RemoveAll(tempPath)
+	tx := &importTx{Tx: sqlTx, dialect: db.Dialect, replaceRelease: db.ReplaceRelease}
+
+	if db.Dialect.ImportsIntoStagingSchema {
+		tx.staging, err = beginStaging(sqlTx)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
 
-	unzipCmd := exec.Command("unzip", zipPath, "-d", tempPath)
-	if err := unzipCmd.Run(); err != nil {
-		log.Fatalf("Cannot unzip archive: %s", err)
+	err = callback(tx)
+	if err != nil {
+		tx.Rollback()
+		log.Print("Import failed, changes are rolled back")
+		return err
+	}
 
+	err = tx.Commit()
+	if err != nil || tx.staging == nil {
 		return err
 	}
 
-	log.Printf("Unzipped %s => %s", zipPath, tempPath)
-	return callback(tempPath)
+	return tx.staging.swap(db)
+}
+
+// Opens release and imports it in single transaction
+func importRelease(db *fhirterm.DB, path string, callback func(tx *importTx, r *release) error) error {
+	return withRelease(path, func(r *release) error {
+		return inTransaction(db, func(tx *importTx) error {
+			return callback(tx, r)
+		})
+	})
 }
 
-func importCsv(db *sql.DB, csvPath string, comma rune, fpr int, insertStmt string) (int, error) {
-	file, err := os.Open(csvPath)
+const maxLoggedRowErrors = 100
+
+// Counts rows which failed to import, every such row fails whole
+// import, only first maxLoggedRowErrors of them are logged
+type rowErrors struct {
+	file  string
+	count int
+}
+
+func (e *rowErrors) add(line int, err error) {
+	e.count++
+
+	if e.count <= maxLoggedRowErrors {
+		log.Printf("%s:%d: %s", e.file, line, err)
+	} else if e.count == maxLoggedRowErrors+1 {
+		log.Printf("Too many errors in %s, rest of them is not logged", e.file)
+	}
+}
+
+func (e *rowErrors) err(rows int) error {
+	if e.count == 0 {
+		return nil
+	}
+
+	return fmt.Errorf("%d of %d rows of %s failed to import", e.count, rows, e.file)
+}
+
+func importCsv(tx *importTx, f releaseFile, comma rune, fpr int, insertStmt string) (int, error) {
+	return importCsvProjection(tx, f, comma, fpr, insertStmt, nil)
+}
 
+// Same as importCsv, but only fields with specified indices are
+// passed to insert statement, -1 index means NULL value
+func importCsvProjection(tx *importTx, f releaseFile, comma rune, fpr int, insertStmt string, indices []int) (int, error) {
+	file, err := f.open()
 	if err != nil {
-		log.Fatalf("Cannot open %s: %s", csvPath, err)
-		return 0, err
+		return 0, fmt.Errorf("Cannot open %s: %s", f.name, err)
 	}
 	defer file.Close()
 
@@ -44,44 +156,243 @@ func importCsv(db *sql.DB, csvPath string, comma rune, fpr int, insertStmt strin
 	reader.Comma = comma
 	reader.FieldsPerRecord = fpr
 
-	rowIdx := 0
-	var tx *sql.Tx
-	var stmt *sql.Stmt
+	// skip first row (useless header)
+	_, err = reader.Read()
+	if err == io.EOF {
+		return 0, nil
+	} else if err != nil {
+		return 0, fmt.Errorf("Cannot read header of %s: %s", f.name, err)
+	}
+
+	stmt, err := tx.Prepare(insertStmt)
+	if err != nil {
+		return 0, err
+	}
+	defer stmt.Close()
+
 	stmtArgs := make([]interface{}, fpr)
+	if indices != nil {
+		stmtArgs = make([]interface{}, len(indices))
+	}
+
+	rows, imported := 0, 0
+	errors := &rowErrors{file: f.name}
 
 	for {
 		row, err := reader.Read()
 
 		if err == io.EOF {
 			break
+		}
+
+		rows++
+		if parseErr, ok := err.(*csv.ParseError); ok {
+			errors.add(parseErr.Line, parseErr.Err)
+			continue
 		} else if err != nil {
-			return 0, err
+			return imported, err
 		}
 
-		// insert 10000 rows per transaction
-		if (rowIdx % 10000) == 0 {
-			if tx != nil {
-				tx.Commit()
+		if indices == nil {
+			for i, v := range row {
+				stmtArgs[i] = interface{}(v)
 			}
+		} else {
+			for i, idx := range indices {
+				if idx < 0 {
+					stmtArgs[i] = nil
+				} else {
+					stmtArgs[i] = interface{}(row[idx])
+				}
+			}
+		}
 
-			tx, _ = db.Begin()
-			stmt, err = tx.Prepare(insertStmt)
-			if err != nil {
-				return 0, err
+		_, err = stmt.Exec(stmtArgs...)
+		if err != nil {
+			line, _ := reader.FieldPos(0)
+			// rest of rows would fail in aborted transaction anyway
+			if tx.dialect.AbortsTransactionOnError {
+				return imported, fmt.Errorf("%s:%d: %s", f.name, line, err)
 			}
+
+			errors.add(line, err)
+			continue
 		}
 
-		if rowIdx > 0 { // skip first row (useless header)
-			for i, v := range row {
-				stmtArgs[i] = interface{}(v)
+		imported++
+	}
+
+	return imported, errors.err(rows)
+}
+
+func readCsvHeader(f releaseFile, comma rune) ([]string, error) {
+	file, err := f.open()
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.Comma = comma
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, err
+	}
+
+	// some CSV files start with UTF-8 byte order mark
+	if len(header) > 0 {
+		header[0] = strings.TrimPrefix(header[0], "\ufeff")
+	}
+
+	return header, nil
+}
+
+type csvColumn struct {
+	Header string
+	Column string
+}
+
+// Imports CSV file with header into table, columns are matched
+// by header names (case-insensitive), missing ones are left NULL
+func importCsvColumns(tx *importTx, f releaseFile, comma rune, table string, columns []csvColumn) (int, error) {
+	header, err := readCsvHeader(f, comma)
+	if err != nil {
+		return 0, err
+	}
+
+	headerIdx := make(map[string]int)
+	for i, h := range header {
+		headerIdx[strings.ToLower(strings.TrimSpace(h))] = i
+	}
+
+	indices := make([]int, len(columns))
+	names := make([]string, len(columns))
+	for i, c := range columns {
+		idx, found := headerIdx[strings.ToLower(c.Header)]
+		if !found {
+			log.Printf("Column %s is missing in %s", c.Header, f.name)
+			idx = -1
+		}
+
+		indices[i] = idx
+		names[i] = c.Column
+	}
+
+	insertStmt := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)",
+		table, strings.Join(names, ", "),
+		strings.TrimRight(strings.Repeat("?, ", len(names)), ", "))
+
+	return importCsvProjection(tx, f, comma, len(header), insertStmt, indices)
+}
+
+// Describes loaded release of code system
+type releaseInfo struct {
+	system      string
+	version     string
+	releaseDate string
+	rowCount    int
+}
+
+func createVersionsTable(tx *importTx) error {
+	_, err := tx.Exec(createVersionsTableStmt)
+	if err != nil {
+		return err
+	}
+
+	columns, err := tableColumns(tx, "terminology_versions")
+	if err != nil {
+		return err
+	}
+
+	for _, c := range versionsTableNewColumns {
+		if !columns[strings.Fields(c)[0]] {
+			_, err = tx.Exec("ALTER TABLE terminology_versions ADD COLUMN " + c)
+			if err != nil {
+				return err
 			}
+		}
+	}
+
+	return nil
+}
 
-			_, err = stmt.Exec(stmtArgs...)
+func tableColumns(tx *importTx, table string) (map[string]bool, error) {
+	rows, err := tx.Query(tx.dialect.ColumnsQuery, table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		err = rows.Scan(&name)
+		if err != nil {
+			return nil, err
 		}
 
-		rowIdx++
+		result[name] = true
+	}
+
+	return result, rows.Err()
+}
+
+func countRows(tx *importTx, query string, args ...interface{}) (int, error) {
+	var count int
+	err := tx.QueryRow(query, args...).Scan(&count)
+	return count, err
+}
+
+// Records which release of code system is loaded. Concept tables
+// are not versioned, so every database keeps single release of code
+// system. Import of different version fails unless
+// DB.ReplaceRelease is set, several releases are served from
+// separate databases listed in config (see Registry.InitNamespaces).
+func recordRelease(tx *importTx, r *release, info releaseInfo) error {
+	err := createVersionsTable(tx)
+	if err != nil {
+		return err
+	}
+
+	if info.version == "" {
+		info.version = "unknown"
+	}
+
+	checksum, err := r.checksum()
+	if err != nil {
+		return err
+	}
+
+	var previous string
+	err = tx.QueryRow("SELECT version FROM terminology_versions WHERE system = ?", info.system).Scan(&previous)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+
+	if previous != "" && previous != info.version {
+		if !tx.replaceRelease {
+			return fmt.Errorf("Database already contains %s version %s, import version %s into separate database to serve both releases or replace it explicitly",
+				info.system, previous, info.version)
+		}
+
+		log.Printf("%s version %s is replaced with version %s", info.system, previous, info.version)
+	}
+
+	_, err = tx.Exec("DELETE FROM terminology_versions WHERE system = ?", info.system)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`INSERT INTO terminology_versions
+                    (system, version, imported_at, release_date, row_count, checksum, source)
+                    VALUES (?, ?, ?, NULLIF(?, ''), ?, ?, ?)`,
+		info.system, info.version, time.Now().Format(time.RFC3339), info.releaseDate,
+		info.rowCount, checksum, filepath.Base(r.path))
+	if err != nil {
+		return err
 	}
-	tx.Commit()
 
-	return rowIdx - 1, nil
+	log.Printf("Recorded %s version %s (%d concepts)", info.system, info.version, info.rowCount)
+	return nil
 }
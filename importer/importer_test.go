@@ -0,0 +1,60 @@
+package importer
+
+import (
+	"github.com/mlapshin/fhirterm"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Creates SQLite database in temporary directory, statements
+// create and fill tables used by test
+func openTestDb(t *testing.T, stmts ...string) (*fhirterm.DB, func()) {
+	dir, err := ioutil.TempDir("", "fhirterm")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := fhirterm.OpenDatabase(filepath.Join(dir, "test.db3"))
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+
+	closeDb := func() {
+		db.Close()
+		os.RemoveAll(dir)
+	}
+
+	for _, stmt := range stmts {
+		if _, err = db.Exec(stmt); err != nil {
+			closeDb()
+			t.Fatalf("%s: %s", stmt, err)
+		}
+	}
+
+	return db, closeDb
+}
+
+// Writes release files (names are relative and use forward slashes)
+// to temporary directory, caller removes it
+func writeTestRelease(t *testing.T, files map[string]string) string {
+	dir, err := ioutil.TempDir("", "fhirterm")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err = os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+
+		if err = ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return dir
+}
@@ -1,10 +1,15 @@
 package importer
 
 import (
+	"bytes"
 	"database/sql"
 	"fmt"
+	"github.com/mlapshin/fhirterm"
 	"log"
 	"path"
+	"regexp"
+	"strings"
+	"unicode"
 )
 
 const createTableStmt = `
@@ -61,32 +66,434 @@ CREATE TABLE loinc_loincs
 )
 `
 
-const insertRowStmt = `
-INSERT INTO loinc_loincs
+// Header names which differ from column names of loinc_loincs table
+var loincHeaderAliases = map[string]string{
+	"time_aspct":    "time_aspect",
+	"scale_typ":     "scale_type",
+	"method_typ":    "method_type",
+	"chng_type":     "change_type",
+	"exmpl_answers": "example_answers",
+	"unitsrequired": "units_required",
+}
+
+var loincIntegerColumns = map[string]bool{
+	"date_last_changed":   true,
+	"classtype":           true,
+	"common_test_rank":    true,
+	"common_order_rank":   true,
+	"common_si_test_rank": true,
+}
+
+// Locations of main LOINC table in different release layouts
+var loincCsvPatterns = []string{
+	"(?i)/LoincTable/Loinc\\.csv$",
+	"(?i)/loinc\\.csv$",
+}
+
+var loincVersionRegexp = regexp.MustCompile("(\\d+\\.\\d+)")
+
+// Converts LOINC_NUM, VersionLastChanged and similar header
+// names to snake case column names
+func loincHeaderToColumn(header string) string {
+	var buf bytes.Buffer
+	runes := []rune(strings.TrimSpace(header))
+
+	for i, r := range runes {
+		if unicode.IsUpper(r) && i > 0 && unicode.IsLower(runes[i-1]) {
+			buf.WriteRune('_')
+		}
+
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			buf.WriteRune(unicode.ToLower(r))
+		} else {
+			buf.WriteRune('_')
+		}
+	}
+
+	column := buf.String()
+	if alias, found := loincHeaderAliases[column]; found {
+		return alias
+	}
+
+	return column
+}
+
+// Columns missing in loinc_loincs table are added as text columns,
+// so data of newer LOINC releases is preserved
+func loincInsertStmt(tx *importTx, header []string) (string, error) {
+	existingColumns, err := tableColumns(tx, "loinc_loincs")
+	if err != nil {
+		return "", err
+	}
+
+	columns := make([]string, 0, len(header))
+	values := make([]string, 0, len(header))
+	hasLoincNum := false
+
+	for _, h := range header {
+		column := loincHeaderToColumn(h)
+		hasLoincNum = hasLoincNum || column == "loinc_num"
+
+		if !existingColumns[column] {
+			_, err = tx.Exec(fmt.Sprintf("ALTER TABLE loinc_loincs ADD COLUMN %s text", column))
+			if err != nil {
+				return "", err
+			}
+
+			existingColumns[column] = true
+			log.Printf("Added column %s to loinc_loincs table", column)
+		}
+
+		columns = append(columns, column)
+
+		if loincIntegerColumns[column] {
+			values = append(values, "CAST(? AS integer)")
+		} else {
+			values = append(values, "?")
+		}
+	}
+
+	if !hasLoincNum {
+		return "", fmt.Errorf("LOINC table does not contain LOINC_NUM column")
+	}
+
+	return fmt.Sprintf("INSERT INTO loinc_loincs (%s) VALUES (%s)",
+		strings.Join(columns, ", "), strings.Join(values, ", ")), nil
+}
+
+var createLoincAccessoryTblStmts = map[string]string{
+	"loinc_parts": `
+CREATE TABLE loinc_parts
+(
+  part_number character varying,
+  part_type_name character varying,
+  part_name character varying,
+  part_display_name character varying,
+  status character varying
+)`,
+
+	"loinc_part_links": `
+CREATE TABLE loinc_part_links
+(
+  loinc_num character varying,
+  part_number character varying,
+  part_type_name character varying,
+  link_type_name character varying,
+  property character varying
+)`,
+
+	"loinc_hierarchy": `
+CREATE TABLE loinc_hierarchy
+(
+  path_to_root text,
+  sequence integer,
+  immediate_parent character varying,
+  code character varying,
+  code_text text
+)`,
+
+	"loinc_answer_list_rows": `
+CREATE TABLE loinc_answer_list_rows
+(
+  answer_list_id character varying,
+  answer_list_name text,
+  answer_list_oid character varying,
+  answer_string_id character varying,
+  local_answer_code character varying,
+  sequence_number integer,
+  display_text text
+)`,
+
+	"loinc_answers": `
+CREATE TABLE loinc_answers
+(
+  answer_string_id character varying,
+  display_text text
+)`,
+
+	"loinc_answer_list_links": `
+CREATE TABLE loinc_answer_list_links
+(
+  loinc_num character varying,
+  answer_list_id character varying,
+  answer_list_link_type character varying
+)`,
+}
+
+var createLoincAccessoryIndexStmts = []string{
+	"CREATE INDEX loinc_loincs_on_loinc_num_idx ON loinc_loincs(loinc_num)",
+	"CREATE INDEX loinc_parts_on_part_number_idx ON loinc_parts(part_number)",
+	"CREATE INDEX loinc_part_links_on_part_number_idx ON loinc_part_links(part_number)",
+	"CREATE INDEX loinc_hierarchy_on_immediate_parent_idx ON loinc_hierarchy(immediate_parent)",
+	"CREATE INDEX loinc_answer_list_rows_on_answer_list_id_idx ON loinc_answer_list_rows(answer_list_id)",
+	"CREATE INDEX loinc_answers_on_answer_string_id_idx ON loinc_answers(answer_string_id)",
+	"CREATE INDEX loinc_answer_list_links_on_loinc_num_idx ON loinc_answer_list_links(loinc_num)",
+	"CREATE INDEX loinc_answer_list_links_on_answer_list_id_idx ON loinc_answer_list_links(answer_list_id)",
+}
+
+const fillLoincAnswersStmt = `
+INSERT INTO loinc_answers (answer_string_id, display_text)
+SELECT answer_string_id, max(display_text)
+FROM loinc_answer_list_rows
+WHERE answer_string_id IS NOT NULL AND answer_string_id <> ''
+GROUP BY answer_string_id`
+
+type loincAccessoryFile struct {
+	pattern string
+	table   string
+	columns []csvColumn
+}
+
+// Accessory files are optional, older releases don't contain them
+var loincAccessoryFiles = []loincAccessoryFile{
+	{
+		"(?i)/PartFile/Part\\.csv$",
+		"loinc_parts",
+		[]csvColumn{
+			{"PartNumber", "part_number"},
+			{"PartTypeName", "part_type_name"},
+			{"PartName", "part_name"},
+			{"PartDisplayName", "part_display_name"},
+			{"Status", "status"},
+		},
+	},
+	{
+		"(?i)/LoincPartLink(_Primary|_Supplementary)?\\.csv$",
+		"loinc_part_links",
+		[]csvColumn{
+			{"LoincNumber", "loinc_num"},
+			{"PartNumber", "part_number"},
+			{"PartTypeName", "part_type_name"},
+			{"LinkTypeName", "link_type_name"},
+			{"Property", "property"},
+		},
+	},
+	{
+		"(?i)/MultiAxialHierarchy\\.csv$",
+		"loinc_hierarchy",
+		[]csvColumn{
+			{"PATH_TO_ROOT", "path_to_root"},
+			{"SEQUENCE", "sequence"},
+			{"IMMEDIATE_PARENT", "immediate_parent"},
+			{"CODE", "code"},
+			{"CODE_TEXT", "code_text"},
+		},
+	},
+	{
+		"(?i)/AnswerList\\.csv$",
+		"loinc_answer_list_rows",
+		[]csvColumn{
+			{"AnswerListId", "answer_list_id"},
+			{"AnswerListName", "answer_list_name"},
+			{"AnswerListOID", "answer_list_oid"},
+			{"AnswerStringId", "answer_string_id"},
+			{"LocalAnswerCode", "local_answer_code"},
+			{"SequenceNumber", "sequence_number"},
+			{"DisplayText", "display_text"},
+		},
+	},
+	{
+		"(?i)/LoincAnswerListLink\\.csv$",
+		"loinc_answer_list_links",
+		[]csvColumn{
+			{"LoincNumber", "loinc_num"},
+			{"AnswerListId", "answer_list_id"},
+			{"AnswerListLinkType", "answer_list_link_type"},
+		},
+	},
+}
+
+func createLoincAccessoryTables(tx *importTx) error {
+	for tblName, stmt := range createLoincAccessoryTblStmts {
+		_, err := tx.Exec("DROP TABLE IF EXISTS " + tblName)
+		if err != nil {
+			return err
+		}
+
+		// answers are identified by rowid, see fhirterm.LoincNamespace
+		if tblName == "loinc_answers" {
+			stmt = tx.withRowid(stmt)
+		}
+
+		_, err = tx.Exec(stmt)
+		if err != nil {
+			return err
+		}
+
+		log.Printf("Created %s table", tblName)
+	}
+
+	return nil
+}
+
+func importLoincAccessoryFiles(tx *importTx, r *release) error {
+	err := createLoincAccessoryTables(tx)
+	if err != nil {
+		return err
+	}
+
+	for _, af := range loincAccessoryFiles {
+		csvFiles := r.findAll(af.pattern)
+		if len(csvFiles) == 0 {
+			log.Printf("No %s file in LOINC archive, skipping", af.pattern)
+			continue
+		}
+
+		for _, csvFile := range csvFiles {
+			log.Printf("Importing %s", csvFile.name)
+
+			importedRows, err := importCsvColumns(tx, csvFile, ',', af.table, af.columns)
+			if err != nil {
+				return err
+			}
+
+			log.Printf("Imported %d rows into %s table", importedRows, af.table)
+		}
+	}
+
+	err = execStmt(tx, fillLoincAnswersStmt, "Filling loinc_answers table")
+	if err != nil {
+		return err
+	}
+
+	log.Print("Creating indices")
+	for _, s := range createLoincAccessoryIndexStmts {
+		err = execStmt(tx, s, "")
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// File names look like deDE15LinguisticVariant.csv
+var loincVariantRegexp = regexp.MustCompile("(?i)/([a-z]{2})([a-z]{2})\\d+LinguisticVariant\\.csv$")
+
+var loincVariantColumns = []csvColumn{
+	{"LOINC_NUM", "loinc_num"},
+	{"COMPONENT", "component"},
+	{"PROPERTY", "property"},
+	{"TIME_ASPCT", "time_aspect"},
+	{"SYSTEM", "system"},
+	{"SCALE_TYP", "scale_type"},
+	{"METHOD_TYP", "method_type"},
+	{"CLASS", "class"},
+	{"SHORTNAME", "shortname"},
+	{"LONG_COMMON_NAME", "long_common_name"},
+	{"RELATEDNAMES2", "relatednames2"},
+	{"LinguisticVariantDisplayName", "display_name"},
+}
+
+const createLoincVariantTableStmt = `
+CREATE TABLE %s
+(
+  loinc_num character varying,
+  component text,
+  property text,
+  time_aspect text,
+  system text,
+  scale_type text,
+  method_type text,
+  class text,
+  shortname text,
+  long_common_name text,
+  relatednames2 text,
+  display_name text
+)`
 
-VALUES
+const createLoincVariantsTableStmt = `
+CREATE TABLE loinc_linguistic_variants
 (
-  ?,?,?,?,?,?,?,?,?,
-  CAST(? AS integer),
-  ?,?,?,?,?,
-  CAST(? AS integer),
-  ?,?,?,?,?,?,?,?,?,?,?,?,?,?,
-  ?,?,?,?,?,?,?,?,?,?,?,?,?,?,
-  CAST(? AS integer),
-  CAST(? AS integer),
-  CAST(? AS integer),
-  ?
+  language character varying NOT NULL primary key,
+  table_name character varying
 )`
 
-const loincColumnsCount = 48
+func dropLoincVariantTables(tx *importTx) error {
+	rows, err := tx.Query("SELECT name FROM (" + tx.dialect.TablesQuery + ") AS t WHERE name LIKE 'loinc_variants_%'")
+	if err != nil {
+		return err
+	}
+
+	tables := make([]string, 0)
+	for rows.Next() {
+		var name string
+		err = rows.Scan(&name)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+
+		tables = append(tables, name)
+	}
+	rows.Close()
+
+	for _, t := range append(tables, "loinc_linguistic_variants") {
+		_, err = tx.Exec("DROP TABLE IF EXISTS " + t)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Every linguistic variant goes to its own table (loinc_variants_de_de
+// for deDE) registered in loinc_linguistic_variants under BCP-47 tag
+func importLoincLinguisticVariants(tx *importTx, r *release) error {
+	err := dropLoincVariantTables(tx)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(createLoincVariantsTableStmt)
+	if err != nil {
+		return err
+	}
+
+	for _, file := range r.files() {
+		m := loincVariantRegexp.FindStringSubmatch(file)
+		if m == nil {
+			continue
+		}
+
+		language := strings.ToLower(m[1]) + "-" + strings.ToUpper(m[2])
+		table := "loinc_variants_" + strings.ToLower(m[1]) + "_" + strings.ToLower(m[2])
+
+		_, err = tx.Exec(fmt.Sprintf(createLoincVariantTableStmt, table))
+		if err != nil {
+			return err
+		}
+
+		log.Printf("Importing %s", file)
+		importedRows, err := importCsvColumns(tx, r.file(file), ',', table, loincVariantColumns)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(fmt.Sprintf("CREATE INDEX %s_on_loinc_num_idx ON %s(loinc_num)", table, table))
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec("INSERT INTO loinc_linguistic_variants VALUES (?, ?)", language, table)
+		if err != nil {
+			return err
+		}
+
+		log.Printf("Imported %d rows into %s table", importedRows, table)
+	}
+
+	return nil
+}
 
-func createLoincTable(db *sql.DB) error {
-	_, err := db.Exec("DROP TABLE IF EXISTS loinc_loincs")
+func createLoincTable(tx *importTx) error {
+	_, err := tx.Exec("DROP TABLE IF EXISTS loinc_loincs")
 	if err != nil {
 		return err
 	}
 
-	_, err = db.Exec(createTableStmt)
+	_, err = tx.Exec(tx.withRowid(createTableStmt))
 	if err != nil {
 		return err
 	}
@@ -95,8 +502,18 @@ func createLoincTable(db *sql.DB) error {
 	return nil
 }
 
-func importLoincCsv(db *sql.DB, csvPath string) error {
-	insertedRows, err := importCsv(db, csvPath, ',', loincColumnsCount, insertRowStmt)
+func importLoincCsv(tx *importTx, csvFile releaseFile) error {
+	header, err := readCsvHeader(csvFile, ',')
+	if err != nil {
+		return err
+	}
+
+	insertStmt, err := loincInsertStmt(tx, header)
+	if err != nil {
+		return err
+	}
+
+	insertedRows, err := importCsv(tx, csvFile, ',', len(header), insertStmt)
 
 	if err != nil {
 		return err
@@ -106,17 +523,76 @@ func importLoincCsv(db *sql.DB, csvPath string) error {
 	return nil
 }
 
-func ImportLoinc(db *sql.DB, filePath string) error {
+// Release version is taken from archive name (Loinc_2.72.zip) or,
+// if it's not there, from latest VersionLastChanged value
+func detectLoincVersion(tx *importTx, filePath string) string {
+	if m := loincVersionRegexp.FindString(path.Base(filePath)); m != "" {
+		return m
+	}
+
+	columns, err := tableColumns(tx, "loinc_loincs")
+	if err == nil && columns["version_last_changed"] {
+		var version sql.NullString
+		row := tx.QueryRow(`SELECT version_last_changed FROM loinc_loincs
+                        ORDER BY CAST(version_last_changed AS real) DESC LIMIT 1`)
+
+		if row.Scan(&version) == nil && version.Valid {
+			return version.String
+		}
+	}
+
+	return "unknown"
+}
+
+func ImportLoinc(db *fhirterm.DB, filePath string) error {
 	log.Printf("Importing LOINC dataset")
 
-	err := unpackZipArchive(filePath, func(p string) error {
-		err := createLoincTable(db)
+	err := importRelease(db, filePath, func(tx *importTx, r *release) error {
+		var csvFile releaseFile
+		found := false
+		for _, pattern := range loincCsvPatterns {
+			csvFile, found = r.find(pattern)
+			if found {
+				break
+			}
+		}
+
+		if !found {
+			return fmt.Errorf("Could not find Loinc.csv file in LOINC archive")
+		}
+
+		log.Printf("Importing %s", csvFile.name)
+
+		err := createLoincTable(tx)
 		if err != nil {
 			return err
 		}
 
-		err = importLoincCsv(db, path.Join(p, "loinc.csv"))
-		return err
+		err = importLoincCsv(tx, csvFile)
+		if err != nil {
+			return err
+		}
+
+		err = importLoincAccessoryFiles(tx, r)
+		if err != nil {
+			return err
+		}
+
+		err = importLoincLinguisticVariants(tx, r)
+		if err != nil {
+			return err
+		}
+
+		count, err := countRows(tx, "SELECT COUNT(*) FROM loinc_loincs")
+		if err != nil {
+			return err
+		}
+
+		return recordRelease(tx, r, releaseInfo{
+			system:   "http://loinc.org",
+			version:  detectLoincVersion(tx, filePath),
+			rowCount: count,
+		})
 	})
 
 	if err != nil {
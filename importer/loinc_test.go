@@ -0,0 +1,166 @@
+package importer
+
+import (
+	"github.com/mlapshin/fhirterm"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_LoincHeaderToColumn(t *testing.T) {
+	assert := assert.New(t)
+
+	tests := []struct {
+		header string
+		column string
+	}{
+		{"LOINC_NUM", "loinc_num"},
+		{"COMPONENT", "component"},
+		{" CLASSTYPE ", "classtype"},
+		{"TIME_ASPCT", "time_aspect"},
+		{"SCALE_TYP", "scale_type"},
+		{"METHOD_TYP", "method_type"},
+		{"CHNG_TYPE", "change_type"},
+		{"EXMPL_ANSWERS", "example_answers"},
+		{"UNITSREQUIRED", "units_required"},
+		{"VersionLastChanged", "version_last_changed"},
+		{"DisplayName", "display_name"},
+		{"HL7_FIELD_SUBFIELD_ID", "hl7_field_subfield_id"},
+		{"AskAtOrderEntry", "ask_at_order_entry"},
+		{"Status-Reason", "status_reason"},
+	}
+
+	for _, test := range tests {
+		assert.Equal(test.column, loincHeaderToColumn(test.header), test.header)
+	}
+}
+
+func Test_ImportLoincTableLayout(t *testing.T) {
+	assert := assert.New(t)
+	db, closeDb := openTestDb(t)
+	defer closeDb()
+
+	dir := writeTestRelease(t, map[string]string{
+		"Loinc_2.72/LoincTable/Loinc.csv": `"LOINC_NUM","COMPONENT","TIME_ASPCT","CLASSTYPE","VersionLastChanged","LONG_COMMON_NAME","DisplayName"
+"2345-7","Glucose","Pt","1","2.70","Glucose [Mass/volume] in Serum or Plasma","Glucose [Mass/Vol]"
+"718-7","Hemoglobin","Pt","1","2.72","Hemoglobin [Mass/volume] in Blood","Hemoglobin [Mass/Vol]"
+`,
+		"Loinc_2.72/AccessoryFiles/PanelsAndForms/Loinc.csv": `"LOINC_NUM"
+"0000-0"
+`,
+	})
+	defer os.RemoveAll(dir)
+
+	assert.Nil(ImportLoinc(db, filepath.Join(dir, "Loinc_2.72")))
+
+	var timeAspect, displayName string
+	var classType int
+	err := db.QueryRow("SELECT time_aspect, classtype, display_name FROM loinc_loincs WHERE loinc_num = '718-7'").
+		Scan(&timeAspect, &classType, &displayName)
+	assert.Nil(err)
+	assert.Equal("Pt", timeAspect, "header aliases map to table columns")
+	assert.Equal(1, classType)
+	assert.Equal("Hemoglobin [Mass/Vol]", displayName, "unknown header is added as column")
+
+	var count int
+	assert.Nil(db.QueryRow("SELECT COUNT(*) FROM loinc_loincs").Scan(&count))
+	assert.Equal(2, count, "LoincTable/Loinc.csv is preferred to other Loinc.csv files")
+
+	versions, err := fhirterm.ReadTerminologyVersions(db)
+	assert.Nil(err)
+	assert.Equal("2.72", versions["http://loinc.org"].Version)
+	assert.Equal(2, versions["http://loinc.org"].RowCount)
+}
+
+func Test_ImportLoincVersionLastChanged(t *testing.T) {
+	assert := assert.New(t)
+	db, closeDb := openTestDb(t)
+	defer closeDb()
+
+	dir := writeTestRelease(t, map[string]string{
+		"loinc/loinc.csv": `"LOINC_NUM","VersionLastChanged"
+"2345-7","2.72"
+"718-7","2.46"
+`,
+	})
+	defer os.RemoveAll(dir)
+
+	assert.Nil(ImportLoinc(db, filepath.Join(dir, "loinc")))
+
+	versions, err := fhirterm.ReadTerminologyVersions(db)
+	assert.Nil(err)
+	assert.Equal("2.72", versions["http://loinc.org"].Version,
+		"release version is latest VersionLastChanged without version in file name")
+}
+
+func Test_ImportLoincWithoutLoincNum(t *testing.T) {
+	assert := assert.New(t)
+	db, closeDb := openTestDb(t)
+	defer closeDb()
+
+	dir := writeTestRelease(t, map[string]string{
+		"loinc/Loinc.csv": "\"CODE\",\"COMPONENT\"\n\"2345-7\",\"Glucose\"\n",
+	})
+	defer os.RemoveAll(dir)
+
+	err := ImportLoinc(db, filepath.Join(dir, "loinc"))
+	assert.EqualError(err, "Error during importing LOINC: LOINC table does not contain LOINC_NUM column")
+}
+
+func Test_ImportLoincAccessoryFiles(t *testing.T) {
+	assert := assert.New(t)
+	db, closeDb := openTestDb(t)
+	defer closeDb()
+
+	dir := writeTestRelease(t, map[string]string{
+		"Loinc/LoincTable/Loinc.csv": `"LOINC_NUM","COMPONENT","LONG_COMMON_NAME"
+"2345-7","Glucose","Glucose [Mass/volume] in Serum or Plasma"
+"72166-2","Tobacco smoking status","Tobacco smoking status"
+`,
+		"Loinc/AccessoryFiles/PartFile/Part.csv": `"PartNumber","PartTypeName","PartName","PartDisplayName","Status"
+"LP14635-4","COMPONENT","Glucose","Glucose","ACTIVE"
+`,
+		"Loinc/AccessoryFiles/PartFile/LoincPartLink_Primary.csv": `"LoincNumber","LongCommonName","PartNumber","PartName","PartCodeSystem","PartTypeName","LinkTypeName","Property"
+"2345-7","Glucose","LP14635-4","Glucose","http://loinc.org","COMPONENT","Primary","http://loinc.org/property/COMPONENT"
+`,
+		"Loinc/AccessoryFiles/ComponentHierarchyBySystem/MultiAxialHierarchy.csv": `"PATH_TO_ROOT","SEQUENCE","IMMEDIATE_PARENT","CODE","CODE_TEXT"
+"","1","","LP29693-6","Laboratory"
+"LP29693-6","1","LP29693-6","2345-7","Glucose"
+`,
+		"Loinc/AccessoryFiles/AnswerFile/AnswerList.csv": `"AnswerListId","AnswerListName","AnswerListOID","ExtDefinedYN","ExtDefinedAnswerListCodeSystem","ExtDefinedAnswerListLink","AnswerStringId","LocalAnswerCode","LocalAnswerCodeSystem","SequenceNumber","DisplayText"
+"LL2201-3","Smoking status","","N","","","LA18976-3","1","","1","Current every day smoker"
+"LL2201-3","Smoking status","","N","","","LA15920-4","2","","2","Former smoker"
+"LL1-1","Other","","N","","","LA15920-4","1","","1","Former smoker"
+`,
+		"Loinc/AccessoryFiles/AnswerFile/LoincAnswerListLink.csv": `"LoincNumber","LongCommonName","AnswerListId","AnswerListName","AnswerListLinkType","ApplicableContext"
+"72166-2","Tobacco smoking status","LL2201-3","Smoking status","NORMATIVE",""
+`,
+	})
+	defer os.RemoveAll(dir)
+
+	assert.Nil(ImportLoinc(db, filepath.Join(dir, "Loinc")))
+
+	var answers int
+	assert.Nil(db.QueryRow("SELECT COUNT(*) FROM loinc_answers WHERE rowid IS NOT NULL").Scan(&answers))
+	assert.Equal(2, answers, "answers of several lists are stored once")
+
+	ns := fhirterm.NewLoincNamespace(db)
+	codes := func(property string, value string) []string {
+		contains, err := ns.Filter(&fhirterm.NsFilter{
+			Include: [][]fhirterm.NsPredicate{{{Property: property, Op: "=", Value: value}}},
+		})
+		assert.Nil(err)
+
+		result := make([]string, 0, len(contains))
+		for _, c := range contains {
+			result = append(result, c.Code)
+		}
+
+		return result
+	}
+
+	assert.Equal([]string{"LA15920-4", "LA18976-3"}, codes("answers-for", "72166-2"))
+	assert.Equal([]string{"2345-7"}, codes("COMPONENT", "LP14635-4"))
+	assert.Equal([]string{"2345-7"}, codes("ancestor", "LP29693-6"))
+}
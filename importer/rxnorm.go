@@ -0,0 +1,258 @@
+package importer
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/mlapshin/fhirterm"
+	"log"
+	"path"
+	"regexp"
+	"strings"
+)
+
+var createRxnormTblStmts = map[string]string{
+	"rxnorm_conso": `
+CREATE TABLE rxnorm_conso
+(
+  rxcui bigint,
+  rxaui character varying,
+  tty character varying,
+  code character varying,
+  str text,
+  suppress character varying
+)`,
+
+	"rxnorm_relationships": `
+CREATE TABLE rxnorm_relationships
+(
+  source_rxcui bigint,
+  rel character varying,
+  rela character varying,
+  target_rxcui bigint
+)`,
+
+	"rxnorm_attributes": `
+CREATE TABLE rxnorm_attributes
+(
+  rxcui bigint,
+  atn character varying,
+  atv text
+)`,
+
+	"rxnorm_concepts": `
+CREATE TABLE rxnorm_concepts
+(
+  rxcui bigint NOT NULL PRIMARY KEY,
+  tty character varying,
+  str text
+)`,
+}
+
+var createRxnormIndexStmts = []string{
+	"CREATE INDEX rxnorm_conso_on_rxcui_idx ON rxnorm_conso(rxcui)",
+	"CREATE INDEX rxnorm_concepts_on_tty_idx ON rxnorm_concepts(tty)",
+	"CREATE INDEX rxnorm_relationships_on_rela_target_rxcui_idx ON rxnorm_relationships(rela, target_rxcui)",
+	"CREATE INDEX rxnorm_attributes_on_atn_atv_idx ON rxnorm_attributes(atn, atv)",
+}
+
+// One row per concept, synonyms (SY, TMSY, PSN) are not used for
+// display. When concept has several atoms, name of most specific
+// term type wins (clinical and branded drugs before their components,
+// forms and ingredients), entry terms (ET) are used last.
+const fillRxnormConceptsStmt = `
+INSERT INTO rxnorm_concepts (rxcui, tty, str)
+SELECT rxcui, tty, str
+FROM (SELECT rxcui, tty, str,
+             row_number() OVER (
+               PARTITION BY rxcui
+               ORDER BY CASE tty
+                          WHEN 'SCD' THEN 1 WHEN 'SBD' THEN 2 WHEN 'GPCK' THEN 3 WHEN 'BPCK' THEN 4
+                          WHEN 'SCDC' THEN 5 WHEN 'SBDC' THEN 6 WHEN 'SCDF' THEN 7 WHEN 'SBDF' THEN 8
+                          WHEN 'SCDG' THEN 9 WHEN 'SBDG' THEN 10 WHEN 'MIN' THEN 11 WHEN 'PIN' THEN 12
+                          WHEN 'IN' THEN 13 WHEN 'BN' THEN 14 WHEN 'DF' THEN 15 WHEN 'DFG' THEN 16
+                          WHEN 'ET' THEN 98 ELSE 97
+                        END, rxaui) AS n
+      FROM rxnorm_conso
+      WHERE tty NOT IN ('SY', 'TMSY', 'PSN') AND suppress <> 'O') AS c
+WHERE n = 1`
+
+// RRF fields: RXCUI|LAT|TS|LUI|STT|SUI|ISPREF|RXAUI|SAUI|SCUI|SDUI|SAB|TTY|CODE|STR|SRL|SUPPRESS|CVF|
+var rxnconsoFile = rrfFile{
+	name:       "RXNCONSO.RRF",
+	insertStmt: "INSERT INTO rxnorm_conso VALUES (CAST(? AS integer), ?, ?, ?, ?, ?)",
+	fields:     []int{0, 7, 12, 13, 14, 16},
+	sabField:   11,
+}
+
+// RRF fields: RXCUI1|RXAUI1|STYPE1|REL|RXCUI2|RXAUI2|STYPE2|RELA|RUI|SRUI|SAB|SL|DIR|RG|SUPPRESS|CVF|
+// Relationship reads as "RXCUI2 RELA RXCUI1", so RXCUI2 is stored as source.
+var rxnrelFile = rrfFile{
+	name:       "RXNREL.RRF",
+	insertStmt: "INSERT INTO rxnorm_relationships VALUES (CAST(? AS integer), ?, ?, CAST(? AS integer))",
+	fields:     []int{4, 3, 7, 0},
+	sabField:   10,
+}
+
+// RRF fields: RXCUI|LUI|SUI|RXAUI|STYPE|CODE|ATUI|SATUI|ATN|SAB|ATV|SUPPRESS|CVF|
+var rxnsatFile = rrfFile{
+	name:       "RXNSAT.RRF",
+	insertStmt: "INSERT INTO rxnorm_attributes VALUES (CAST(? AS integer), ?, ?)",
+	fields:     []int{0, 8, 10},
+	sabField:   9,
+}
+
+// Release date in archive name, like RxNorm_full_01022024.zip
+var rxnormVersionRegexp = regexp.MustCompile("\\d{8}")
+
+type rrfFile struct {
+	name       string
+	insertStmt string
+	fields     []int
+	sabField   int
+}
+
+// Only rows with SAB = RXNORM are imported, other sources are
+// not part of RxNorm code system
+func importRrf(tx *importTx, rf releaseFile, f rrfFile) (int, error) {
+	file, err := rf.open()
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 1024*1024), 16*1024*1024)
+
+	stmt, err := tx.Prepare(f.insertStmt)
+	if err != nil {
+		return 0, err
+	}
+	defer stmt.Close()
+
+	line, rows, imported := 0, 0, 0
+	args := make([]interface{}, len(f.fields))
+	errors := &rowErrors{file: rf.name}
+
+	for scanner.Scan() {
+		line++
+		fields := strings.Split(scanner.Text(), "|")
+		if len(fields) <= f.sabField || fields[f.sabField] != "RXNORM" {
+			continue
+		}
+
+		rows++
+		malformed := false
+		for i, idx := range f.fields {
+			if idx >= len(fields) {
+				malformed = true
+				break
+			}
+
+			args[i] = fields[idx]
+		}
+
+		if malformed {
+			errors.add(line, fmt.Errorf("malformed row: %s", scanner.Text()))
+			continue
+		}
+
+		_, err = stmt.Exec(args...)
+		if err != nil {
+			if tx.dialect.AbortsTransactionOnError {
+				return imported, fmt.Errorf("%s:%d: %s", rf.name, line, err)
+			}
+
+			errors.add(line, err)
+			continue
+		}
+
+		imported++
+	}
+
+	if err = scanner.Err(); err != nil {
+		return imported, err
+	}
+
+	return imported, errors.err(rows)
+}
+
+func createRxnormTables(tx *importTx) error {
+	for tblName, stmt := range createRxnormTblStmts {
+		_, err := tx.Exec("DROP TABLE IF EXISTS " + tblName)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(stmt)
+		if err != nil {
+			return err
+		}
+
+		log.Printf("Created %s table", tblName)
+	}
+
+	return nil
+}
+
+func ImportRxnorm(db *fhirterm.DB, filePath string) error {
+	log.Printf("Importing RxNorm dataset")
+
+	err := importRelease(db, filePath, func(tx *importTx, r *release) error {
+		err := createRxnormTables(tx)
+		if err != nil {
+			return err
+		}
+
+		for _, f := range []rrfFile{rxnconsoFile, rxnrelFile, rxnsatFile} {
+			rrf, found := r.find("(?i)/rrf/" + strings.Replace(f.name, ".", "\\.", -1) + "$")
+			if !found {
+				return fmt.Errorf("Could not find file %s in RxNorm archive", f.name)
+			}
+
+			log.Printf("Importing %s", rrf.name)
+			importedRows, err := importRrf(tx, rrf, f)
+			if err != nil {
+				return err
+			}
+
+			log.Printf("Imported %d rows from %s", importedRows, f.name)
+		}
+
+		err = execStmt(tx, fillRxnormConceptsStmt, "Filling rxnorm_concepts table")
+		if err != nil {
+			return err
+		}
+
+		log.Print("Creating indices")
+		for _, s := range createRxnormIndexStmts {
+			err = execStmt(tx, s, "")
+			if err != nil {
+				return err
+			}
+		}
+
+		info := releaseInfo{
+			system:  "http://www.nlm.nih.gov/research/umls/rxnorm",
+			version: rxnormVersionRegexp.FindString(path.Base(filePath)),
+		}
+
+		// release archives are named like RxNorm_full_01012024.zip
+		if info.version != "" {
+			v := info.version
+			info.releaseDate = v[4:8] + "-" + v[0:2] + "-" + v[2:4]
+		}
+
+		info.rowCount, err = countRows(tx, "SELECT COUNT(*) FROM rxnorm_concepts")
+		if err != nil {
+			return err
+		}
+
+		return recordRelease(tx, r, info)
+	})
+
+	if err != nil {
+		return fmt.Errorf("Error during importing RxNorm: %s", err)
+	} else {
+		return nil
+	}
+}
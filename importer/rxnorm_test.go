@@ -0,0 +1,38 @@
+package importer
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func Test_FillRxnormConcepts(t *testing.T) {
+	assert := assert.New(t)
+	db, closeDb := openTestDb(t,
+		createRxnormTblStmts["rxnorm_conso"],
+		createRxnormTblStmts["rxnorm_concepts"],
+		`INSERT INTO rxnorm_conso VALUES
+     (1, 'A4', 'SY', '1', 'synonym', 'N'),
+     (1, 'A3', 'ET', '1', 'entry term', 'N'),
+     (1, 'A2', 'SCD', '1', 'clinical drug', 'N'),
+     (1, 'A1', 'SCDC', '1', 'component', 'N'),
+     (2, 'A6', 'IN', '2', 'obsolete', 'O'),
+     (2, 'A7', 'ET', '2', 'ingredient entry', 'N'),
+     (2, 'A5', 'IN', '2', 'ingredient', 'N')`)
+	defer closeDb()
+
+	_, err := db.Exec(fillRxnormConceptsStmt)
+	assert.Nil(err)
+
+	rows, err := db.Query("SELECT rxcui, tty, str FROM rxnorm_concepts ORDER BY rxcui")
+	assert.Nil(err)
+	defer rows.Close()
+
+	result := make([][3]string, 0)
+	for rows.Next() {
+		var r [3]string
+		assert.Nil(rows.Scan(&r[0], &r[1], &r[2]))
+		result = append(result, r)
+	}
+
+	assert.Equal([][3]string{{"1", "SCD", "clinical drug"}, {"2", "IN", "ingredient"}}, result)
+}
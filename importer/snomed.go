@@ -6,9 +6,9 @@ import (
 	"database/sql"
 	"encoding/binary"
 	"fmt"
+	"github.com/mlapshin/fhirterm"
+	"io"
 	"log"
-	"os"
-	"path/filepath"
 	"regexp"
 	"strings"
 )
@@ -18,13 +18,24 @@ var createTblStmts map[string]string
 var createIndexStmts = []string{
 	"CREATE INDEX snomed_is_a_relationships_on_source_id_idx ON snomed_is_a_relationships(source_id)",
 	"CREATE INDEX snomed_is_a_relationships_on_destination_id_idx ON snomed_is_a_relationships(destination_id)",
+	"CREATE INDEX snomed_concepts_on_id_idx ON snomed_concepts(id)",
+	"CREATE INDEX snomed_descriptions_on_concept_id_idx ON snomed_descriptions(concept_id)",
+	"CREATE INDEX snomed_historical_associations_on_source_id_idx ON snomed_historical_associations(source_id)",
+	"CREATE INDEX snomed_attribute_relationships_on_type_id_destination_id_idx ON snomed_attribute_relationships(type_id, destination_id)",
+	"CREATE INDEX snomed_attribute_relationships_on_source_id_idx ON snomed_attribute_relationships(source_id)",
+	"CREATE INDEX snomed_refset_members_on_refset_id_idx ON snomed_refset_members(refset_id)",
 }
 
+// Term of any latest description of concept, written with window
+// function since PostgreSQL has no bare columns in GROUP BY queries
 const fillConceptsNoHistoryStmt = `
 INSERT INTO snomed_concepts_no_history
 (concept_id, effective_time, term)
-SELECT concept_id, max(effective_time), term
-FROM snomed_descriptions GROUP BY concept_id`
+SELECT concept_id, effective_time, term
+FROM (SELECT concept_id, effective_time, term,
+             row_number() OVER (PARTITION BY concept_id ORDER BY effective_time DESC) AS n
+      FROM snomed_descriptions) AS d
+WHERE n = 1`
 
 const fillIsARelationsipsStmt = `
 INSERT INTO snomed_is_a_relationships
@@ -32,6 +43,41 @@ INSERT INTO snomed_is_a_relationships
 SELECT id, source_id, destination_id FROM snomed_relationships
 WHERE type_id = 116680003 AND active = 1`
 
+// Every relationship except is-a (116680003), latest state only
+const fillAttributeRelationshipsStmt = `
+INSERT INTO snomed_attribute_relationships
+(id, source_id, destination_id, type_id, relationship_group)
+SELECT r.id, r.source_id, r.destination_id, r.type_id, r.relationship_group
+FROM snomed_relationships AS r
+JOIN (SELECT id, max(effective_time) AS effective_time
+      FROM snomed_relationships GROUP BY id) AS l
+ON l.id = r.id AND l.effective_time = r.effective_time
+WHERE r.type_id <> 116680003 AND r.active = 1`
+
+const fillRefsetMembersStmt = `
+INSERT INTO snomed_refset_members
+(refset_id, referenced_component_id)
+SELECT DISTINCT r.refset_id, r.referenced_component_id
+FROM snomed_simple_refsets AS r
+JOIN (SELECT id, max(effective_time) AS effective_time
+      FROM snomed_simple_refsets GROUP BY id) AS l
+ON l.id = r.id AND l.effective_time = r.effective_time
+WHERE r.active = 1`
+
+// Keeps only latest active state of SAME AS (900000000000527005),
+// REPLACED BY (900000000000526001) and POSSIBLY EQUIVALENT TO
+// (900000000000523009) historical associations
+const fillHistoricalAssociationsStmt = `
+INSERT INTO snomed_historical_associations
+(source_id, target_id, refset_id)
+SELECT a.referenced_component_id, a.target_component_id, a.refset_id
+FROM snomed_associations AS a
+JOIN (SELECT id, max(effective_time) AS effective_time
+      FROM snomed_associations GROUP BY id) AS l
+ON l.id = a.id AND l.effective_time = a.effective_time
+WHERE a.active = 1
+AND a.refset_id IN (900000000000527005, 900000000000526001, 900000000000523009)`
+
 func init() {
 	createTblStmts = make(map[string]string)
 	createTblStmts["snomed_concepts"] = `
@@ -82,6 +128,16 @@ CREATE TABLE snomed_is_a_relationships
   destination_id bigint
 )`
 
+	createTblStmts["snomed_attribute_relationships"] = `
+CREATE TABLE snomed_attribute_relationships
+(
+  id bigint,
+  source_id bigint,
+  destination_id bigint,
+  type_id bigint,
+  relationship_group integer
+)`
+
 	createTblStmts["snomed_concepts_no_history"] = `
 CREATE TABLE snomed_concepts_no_history
 (
@@ -90,6 +146,44 @@ CREATE TABLE snomed_concepts_no_history
   term text
 )`
 
+	createTblStmts["snomed_associations"] = `
+CREATE TABLE snomed_associations
+(
+  id text,
+  effective_time integer,
+  active integer,
+  module_id integer,
+  refset_id integer,
+  referenced_component_id integer,
+  target_component_id integer
+)`
+
+	createTblStmts["snomed_historical_associations"] = `
+CREATE TABLE snomed_historical_associations
+(
+  source_id bigint,
+  target_id bigint,
+  refset_id bigint
+)`
+
+	createTblStmts["snomed_simple_refsets"] = `
+CREATE TABLE snomed_simple_refsets
+(
+  id text,
+  effective_time integer,
+  active integer,
+  module_id integer,
+  refset_id integer,
+  referenced_component_id integer
+)`
+
+	createTblStmts["snomed_refset_members"] = `
+CREATE TABLE snomed_refset_members
+(
+  refset_id bigint,
+  referenced_component_id bigint
+)`
+
 	createTblStmts["snomed_ancestors_descendants"] = `
 CREATE TABLE snomed_ancestors_descendants
 (
@@ -138,43 +232,37 @@ CAST(? AS integer),
 CAST(? AS integer)
 )`
 
-func dirContent(root string) ([]string, error) {
-	result := make([]string, 100)
-
-	walker := func(path string, f os.FileInfo, err error) error {
-		result = append(result, path)
-		return nil
-	}
-
-	err := filepath.Walk(root, walker)
-
-	if err != nil {
-		return nil, err
-	}
-
-	return result, nil
-}
-
-func findFile(files []string, rexp string) (string, bool) {
-	r, _ := regexp.Compile(rexp)
-
-	for _, file := range files {
-		if r.MatchString(file) {
-			return file, true
-		}
-	}
+const insertAssocStmt = `
+INSERT INTO snomed_associations
+VALUES (
+?,
+CAST(? AS integer),
+CAST(? AS integer),
+CAST(? AS integer),
+CAST(? AS integer),
+CAST(? AS integer),
+CAST(? AS integer)
+)`
 
-	return "", false
-}
+const insertSimpleRefsetStmt = `
+INSERT INTO snomed_simple_refsets
+VALUES (
+?,
+CAST(? AS integer),
+CAST(? AS integer),
+CAST(? AS integer),
+CAST(? AS integer),
+CAST(? AS integer)
+)`
 
-func createSnomedTables(db *sql.DB) error {
+func createSnomedTables(tx *importTx) error {
 	for tblName, stmt := range createTblStmts {
-		_, err := db.Exec("DROP TABLE IF EXISTS " + tblName)
+		_, err := tx.Exec("DROP TABLE IF EXISTS " + tblName)
 		if err != nil {
 			return err
 		}
 
-		_, err = db.Exec(stmt)
+		_, err = tx.Exec(stmt)
 		if err != nil {
 			return err
 		}
@@ -185,15 +273,15 @@ func createSnomedTables(db *sql.DB) error {
 	return nil
 }
 
-func importSnomedConcepts(db *sql.DB, files []string) error {
-	csvPath, found := findFile(files, "SnomedCT_Release_INT_\\d{8}/RF2Release/Full/Terminology/sct2_Concept_Full_INT_\\d{8}.txt$")
-	log.Printf("Importing %s", csvPath)
+func importSnomedConcepts(tx *importTx, r *release) error {
+	csvFile, found := r.find("SnomedCT_Release_INT_\\d{8}/RF2Release/Full/Terminology/sct2_Concept_Full_INT_\\d{8}.txt$")
+	log.Printf("Importing %s", csvFile.name)
 
 	if !found {
 		return fmt.Errorf("Could not find file sct2_Concept_Full_INT_XXXXXXXX.txt in SNOMED archive")
 	}
 
-	importedRows, err := importCsv(db, csvPath, '\t', 5, insertConceptsStmt)
+	importedRows, err := importCsv(tx, csvFile, '\t', 5, insertConceptsStmt)
 
 	if err != nil {
 		return err
@@ -204,15 +292,15 @@ func importSnomedConcepts(db *sql.DB, files []string) error {
 	return nil
 }
 
-func importSnomedRelationships(db *sql.DB, files []string) error {
-	csvPath, found := findFile(files, "SnomedCT_Release_INT_\\d{8}/RF2Release/Full/Terminology/sct2_Relationship_Full_INT_\\d{8}.txt$")
-	log.Printf("Importing %s", csvPath)
+func importSnomedRelationships(tx *importTx, r *release) error {
+	csvFile, found := r.find("SnomedCT_Release_INT_\\d{8}/RF2Release/Full/Terminology/sct2_Relationship_Full_INT_\\d{8}.txt$")
+	log.Printf("Importing %s", csvFile.name)
 
 	if !found {
 		return fmt.Errorf("Could not find file sct2_Relationship_Full_INT_XXXXXXXX.txt in SNOMED archive")
 	}
 
-	importedRows, err := importCsv(db, csvPath, '\t', 10, insertRelsStmt)
+	importedRows, err := importCsv(tx, csvFile, '\t', 10, insertRelsStmt)
 
 	if err != nil {
 		return err
@@ -223,58 +311,112 @@ func importSnomedRelationships(db *sql.DB, files []string) error {
 	return nil
 }
 
-func escapeQuotes(f string) error {
-	fixedCsv, _ := os.Create(f + "-fixed")
-	csvFile, _ := os.Open(f)
+// Quotes text fields (like description terms), otherwise
+// encoding/csv fails to load values containing quote characters.
+// File is converted on the fly.
+func escapeQuotes(f releaseFile, fieldIndices ...int) releaseFile {
+	return releaseFile{
+		name: f.name,
+		open: func() (io.ReadCloser, error) {
+			csvFile, err := f.open()
+			if err != nil {
+				return nil, err
+			}
 
-	scanner := bufio.NewScanner(csvFile)
-	for scanner.Scan() {
-		line := scanner.Text()
+			pr, pw := io.Pipe()
+			go func() {
+				defer csvFile.Close()
+
+				scanner := bufio.NewScanner(csvFile)
+				for scanner.Scan() {
+					line := scanner.Text()
+
+					if strings.ContainsRune(line, '"') {
+						fields := strings.Split(line, "\t")
+						for _, i := range fieldIndices {
+							if i < len(fields) {
+								fields[i] = "\"" + strings.Replace(fields[i], "\"", "\"\"", -1) + "\""
+							}
+						}
+						line = strings.Join(fields, "\t")
+					}
+
+					if _, err := pw.Write([]byte(line + "\n")); err != nil {
+						return
+					}
+				}
+
+				pw.CloseWithError(scanner.Err())
+			}()
+
+			return pr, nil
+		},
+	}
+}
 
-		if strings.ContainsRune(line, '"') {
-			fields := strings.Split(line, "\t")
-			fields[7] = "\"" + strings.Replace(fields[7], "\"", "\"\"", -1) + "\""
-			line = strings.Join(fields, "\t")
-		}
+func importSnomedDescriptions(tx *importTx, r *release) error {
+	csvFile, found := r.find("SnomedCT_Release_INT_\\d{8}/RF2Release/Full/Terminology/sct2_Description_Full-en_INT_\\d{8}.txt$")
+	log.Printf("Importing %s", csvFile.name)
+
+	if !found {
+		return fmt.Errorf("Could not find file sct2_Description_Full_INT_XXXXXXXX.txt in SNOMED archive")
+	}
 
-		fixedCsv.Write([]byte(line + "\n"))
+	importedRows, err := importCsv(tx, escapeQuotes(csvFile, 7), '\t', 9, insertDescStmt)
+
+	if err != nil {
+		return err
 	}
 
-	fixedCsv.Close()
-	csvFile.Close()
+	log.Printf("Imported %d rows into snomed_descriptions table", importedRows)
 
 	return nil
 }
 
-func importSnomedDescriptions(db *sql.DB, files []string) error {
-	csvPath, found := findFile(files, "SnomedCT_Release_INT_\\d{8}/RF2Release/Full/Terminology/sct2_Description_Full-en_INT_\\d{8}.txt$")
-	log.Printf("Importing %s", csvPath)
+func importSnomedAssociations(tx *importTx, r *release) error {
+	csvFile, found := r.find("SnomedCT_Release_INT_\\d{8}/RF2Release/Full/Refset/Content/der2_cRefset_AssociationReferenceFull_INT_\\d{8}.txt$")
+	log.Printf("Importing %s", csvFile.name)
 
 	if !found {
-		return fmt.Errorf("Could not find file sct2_Description_Full_INT_XXXXXXXX.txt in SNOMED archive")
+		return fmt.Errorf("Could not find file der2_cRefset_AssociationReferenceFull_INT_XXXXXXXX.txt in SNOMED archive")
 	}
 
-	// Escape quotes in TSV file
-	// Otherwise, encounding/csv will fail to corretly load this file
-	escapeQuotes(csvPath)
+	importedRows, err := importCsv(tx, csvFile, '\t', 7, insertAssocStmt)
+
+	if err != nil {
+		return err
+	}
 
-	importedRows, err := importCsv(db, csvPath+"-fixed", '\t', 9, insertDescStmt)
+	log.Printf("Imported %d rows into snomed_associations table", importedRows)
+
+	return nil
+}
+
+func importSnomedSimpleRefsets(tx *importTx, r *release) error {
+	csvFile, found := r.find("SnomedCT_Release_INT_\\d{8}/RF2Release/Full/Refset/Content/der2_Refset_SimpleFull_INT_\\d{8}.txt$")
+	log.Printf("Importing %s", csvFile.name)
+
+	if !found {
+		return fmt.Errorf("Could not find file der2_Refset_SimpleFull_INT_XXXXXXXX.txt in SNOMED archive")
+	}
+
+	importedRows, err := importCsv(tx, csvFile, '\t', 6, insertSimpleRefsetStmt)
 
 	if err != nil {
 		return err
 	}
 
-	log.Printf("Imported %d rows into snomed_descriptions table", importedRows)
+	log.Printf("Imported %d rows into snomed_simple_refsets table", importedRows)
 
 	return nil
 }
 
-func execStmt(db *sql.DB, stmt string, logMessage string) error {
+func execStmt(tx *importTx, stmt string, logMessage string) error {
 	if len(logMessage) > 0 {
 		log.Print(logMessage)
 	}
 
-	_, err := db.Exec(stmt)
+	_, err := tx.Exec(stmt)
 
 	if err != nil {
 		return err
@@ -286,6 +428,8 @@ func execStmt(db *sql.DB, stmt string, logMessage string) error {
 }
 
 func rowsToIntSlice(rows *sql.Rows, slice []int64) ([]int64, error) {
+	defer rows.Close()
+
 	for rows.Next() {
 		var i int64
 		err := rows.Scan(&i)
@@ -301,8 +445,6 @@ func rowsToIntSlice(rows *sql.Rows, slice []int64) ([]int64, error) {
 		return nil, err
 	}
 
-	rows.Close()
-
 	return slice, nil
 }
 
@@ -310,10 +452,10 @@ func getAncestorsDescendants(stmt *sql.Stmt, slice *[]int64) {
 
 }
 
-func prewalkSnomedGraph(db *sql.DB) error {
+func prewalkSnomedGraph(tx *importTx) error {
 	log.Print("Prewalking SNOMED-CT graph...")
 
-	rows, err := db.Query(`SELECT source_id FROM snomed_is_a_relationships
+	rows, err := tx.Query(`SELECT source_id FROM snomed_is_a_relationships
 														UNION
 														SELECT destination_id FROM snomed_is_a_relationships`)
 	if err != nil {
@@ -321,14 +463,46 @@ func prewalkSnomedGraph(db *sql.DB) error {
 	}
 
 	concepts := make([]int64, 0, 355000)
-	concepts, _ = rowsToIntSlice(rows, concepts)
+	concepts, err = rowsToIntSlice(rows, concepts)
+	if err != nil {
+		return err
+	}
 
 	log.Printf("Collected %d concepts", len(concepts))
 
-	var insertTx *sql.Tx
-	var insertStmt *sql.Stmt
-	var getAncestorsStmt *sql.Stmt
-	var getDescendantsStmt *sql.Stmt
+	insertStmt, err := tx.Prepare(`INSERT INTO snomed_ancestors_descendants
+                                (concept_id, ancestors, descendants)
+                                VALUES (?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer insertStmt.Close()
+
+	getAncestorsStmt, err :=
+		tx.Prepare(`WITH RECURSIVE t(destination_id) AS (
+								SELECT destination_id FROM snomed_is_a_relationships
+								WHERE source_id = ?
+								UNION
+								SELECT sr.destination_id FROM snomed_is_a_relationships AS sr
+								JOIN t ON t.destination_id = sr.source_id
+								) SELECT destination_id FROM t`)
+	if err != nil {
+		return err
+	}
+	defer getAncestorsStmt.Close()
+
+	getDescendantsStmt, err :=
+		tx.Prepare(`WITH RECURSIVE t(source_id) AS (
+								SELECT source_id FROM snomed_is_a_relationships
+								WHERE destination_id = ?
+								UNION
+								SELECT sr.source_id FROM snomed_is_a_relationships AS sr
+								JOIN t ON t.source_id = sr.destination_id
+								) SELECT source_id FROM t`)
+	if err != nil {
+		return err
+	}
+	defer getDescendantsStmt.Close()
 
 	ancestors := make([]int64, 0, 200000)
 	descendants := make([]int64, 0, 200000)
@@ -337,39 +511,6 @@ func prewalkSnomedGraph(db *sql.DB) error {
 	descendantsBuf := new(bytes.Buffer)
 
 	for index, concept := range concepts {
-		if index%20000 == 0 {
-			if insertTx != nil {
-				insertTx.Commit()
-			}
-
-			insertTx, _ = db.Begin()
-
-			insertStmt, err = insertTx.Prepare(`INSERT INTO snomed_ancestors_descendants
-                                          (concept_id, ancestors, descendants)
-                                          VALUES (?, ?, ?)`)
-			if err != nil {
-				return err
-			}
-
-			getAncestorsStmt, _ =
-				insertTx.Prepare(`WITH RECURSIVE t(destination_id) AS (
-													SELECT destination_id FROM snomed_is_a_relationships
-													WHERE source_id = ?
-													UNION
-													SELECT sr.destination_id FROM snomed_is_a_relationships AS sr
-													JOIN t ON t.destination_id = sr.source_id
-													) SELECT destination_id FROM t`)
-
-			getDescendantsStmt, _ =
-				insertTx.Prepare(`WITH RECURSIVE t(source_id) AS (
-													SELECT source_id FROM snomed_is_a_relationships
-													WHERE destination_id = ?
-													UNION
-													SELECT sr.source_id FROM snomed_is_a_relationships AS sr
-													JOIN t ON t.source_id = sr.destination_id
-													) SELECT source_id FROM t`)
-		}
-
 		if index%20000 == 0 && index != 0 {
 			log.Printf("Processed %d concepts...", index)
 		}
@@ -379,14 +520,20 @@ func prewalkSnomedGraph(db *sql.DB) error {
 			return err
 		}
 		ancestors = ancestors[0:0]
-		ancestors, _ = rowsToIntSlice(rows, ancestors)
+		ancestors, err = rowsToIntSlice(rows, ancestors)
+		if err != nil {
+			return err
+		}
 
 		rows, err = getDescendantsStmt.Query(concept)
 		if err != nil {
 			return err
 		}
 		descendants = descendants[0:0]
-		descendants, _ = rowsToIntSlice(rows, descendants)
+		descendants, err = rowsToIntSlice(rows, descendants)
+		if err != nil {
+			return err
+		}
 
 		ancestorsBuf.Reset()
 		descendantsBuf.Reset()
@@ -405,41 +552,69 @@ func prewalkSnomedGraph(db *sql.DB) error {
 			return err
 		}
 	}
-	// final commit
-	insertTx.Commit()
 	log.Print("Done")
 
 	return nil
 }
 
-func ImportSnomed(db *sql.DB, filePath string) error {
+var snomedReleaseDateRegexp = regexp.MustCompile("sct2_Concept_Full_INT_(\\d{4})(\\d{2})(\\d{2})\\.txt$")
+
+// Version is recorded in FHIR form, like
+// http://snomed.info/sct/900000000000207008/version/20150131
+func recordSnomedRelease(tx *importTx, r *release) error {
+	info := releaseInfo{system: "http://snomed.info/sct"}
+
+	if f, found := r.find(snomedReleaseDateRegexp.String()); found {
+		m := snomedReleaseDateRegexp.FindStringSubmatch(f.name)
+		info.version = "http://snomed.info/sct/900000000000207008/version/" + m[1] + m[2] + m[3]
+		info.releaseDate = m[1] + "-" + m[2] + "-" + m[3]
+	}
+
+	var err error
+	info.rowCount, err = countRows(tx, "SELECT COUNT(*) FROM snomed_concepts_no_history")
+	if err != nil {
+		return err
+	}
+
+	return recordRelease(tx, r, info)
+}
+
+func ImportSnomed(db *fhirterm.DB, filePath string) error {
 	log.Printf("Importing SNOMED-CT dataset")
 
-	error := unpackZipArchive(filePath, func(p string) error {
-		files, err := dirContent(p)
+	error := importRelease(db, filePath, func(tx *importTx, r *release) error {
+		err := createSnomedTables(tx)
+		if err != nil {
+			return err
+		}
 
-		err = createSnomedTables(db)
+		err = importSnomedConcepts(tx, r)
 		if err != nil {
 			return err
 		}
 
-		err = importSnomedConcepts(db, files)
+		err = importSnomedRelationships(tx, r)
 		if err != nil {
 			return err
 		}
 
-		err = importSnomedRelationships(db, files)
+		err = importSnomedDescriptions(tx, r)
 		if err != nil {
 			return err
 		}
 
-		err = importSnomedDescriptions(db, files)
+		err = importSnomedAssociations(tx, r)
+		if err != nil {
+			return err
+		}
+
+		err = importSnomedSimpleRefsets(tx, r)
 		if err != nil {
 			return err
 		}
 
 		err = execStmt(
-			db,
+			tx,
 			fillIsARelationsipsStmt,
 			"Filling snomed_is_a_relationships table")
 
@@ -448,7 +623,16 @@ func ImportSnomed(db *sql.DB, filePath string) error {
 		}
 
 		err = execStmt(
-			db,
+			tx,
+			fillAttributeRelationshipsStmt,
+			"Filling snomed_attribute_relationships table")
+
+		if err != nil {
+			return err
+		}
+
+		err = execStmt(
+			tx,
 			fillConceptsNoHistoryStmt,
 			"Filling snomed_concepts_no_history table")
 
@@ -456,21 +640,39 @@ func ImportSnomed(db *sql.DB, filePath string) error {
 			return err
 		}
 
+		err = execStmt(
+			tx,
+			fillHistoricalAssociationsStmt,
+			"Filling snomed_historical_associations table")
+
+		if err != nil {
+			return err
+		}
+
+		err = execStmt(
+			tx,
+			fillRefsetMembersStmt,
+			"Filling snomed_refset_members table")
+
+		if err != nil {
+			return err
+		}
+
 		log.Print("Creating indices")
 		for _, s := range createIndexStmts {
-			err = execStmt(db, s, "")
+			err = execStmt(tx, s, "")
 
 			if err != nil {
 				return err
 			}
 		}
 
-		err = prewalkSnomedGraph(db)
+		err = prewalkSnomedGraph(tx)
 		if err != nil {
 			return err
 		}
 
-		return nil
+		return recordSnomedRelease(tx, r)
 	})
 
 	if error != nil {
@@ -0,0 +1,173 @@
+package importer
+
+import (
+	"fmt"
+	"github.com/mlapshin/fhirterm"
+	"log"
+	"regexp"
+	"strconv"
+)
+
+// SNOMED-CT to ICD-10 maps are distributed as RF2 extended map
+// refsets: ICD-10 complex map in International release and ICD-10-CM
+// map in US edition
+var snomedIcd10MapRefsets = []struct {
+	refsetId int64
+	system   string
+}{
+	{447562003, fhirterm.Icd10Url},
+	{6011000124106, fhirterm.Icd10CmUrl},
+}
+
+const createSnomedExtendedMapTblStmt = `
+CREATE TABLE snomed_extended_map_rows
+(
+  id text,
+  effective_time integer,
+  active integer,
+  module_id integer,
+  refset_id integer,
+  referenced_component_id integer,
+  map_group integer,
+  map_priority integer,
+  map_rule text,
+  map_advice text,
+  map_target text,
+  correlation_id integer,
+  map_category_id integer
+)`
+
+const createSnomedIcd10MapsTblStmt = `
+CREATE TABLE snomed_icd10_maps
+(
+  refset_id bigint,
+  source_id bigint,
+  system text,
+  target text,
+  map_group integer,
+  map_priority integer,
+  map_rule text,
+  map_advice text,
+  correlation_id bigint
+)`
+
+const insertExtendedMapStmt = `
+INSERT INTO snomed_extended_map_rows
+VALUES (
+?,
+CAST(? AS integer),
+CAST(? AS integer),
+CAST(? AS integer),
+CAST(? AS integer),
+CAST(? AS integer),
+CAST(? AS integer),
+CAST(? AS integer),
+?,
+?,
+?,
+CAST(? AS integer),
+CAST(? AS integer)
+)`
+
+// Latest active state of map rows, rows without target (source
+// concept can't be mapped) are skipped
+const fillSnomedIcd10MapsStmt = `
+INSERT INTO snomed_icd10_maps
+(refset_id, source_id, system, target, map_group, map_priority, map_rule, map_advice, correlation_id)
+SELECT m.refset_id, m.referenced_component_id, ?, m.map_target, m.map_group, m.map_priority,
+       m.map_rule, m.map_advice, m.correlation_id
+FROM snomed_extended_map_rows AS m
+JOIN (SELECT id, max(effective_time) AS effective_time
+      FROM snomed_extended_map_rows GROUP BY id) AS l
+ON l.id = m.id AND l.effective_time = m.effective_time
+WHERE m.active = 1 AND m.refset_id = ? AND m.map_target <> ''`
+
+var snomedExtendedMapRegexp = regexp.MustCompile("der2_iisssccRefset_ExtendedMap(Full|Snapshot)_[^/]*_(\\d{4})(\\d{2})(\\d{2})\\.txt$")
+
+// Full release file is preferred, map packages of some editions
+// contain only snapshot
+func findSnomedExtendedMap(r *release) (releaseFile, []string, bool) {
+	var snapshot releaseFile
+	var snapshotMatch []string
+
+	for _, f := range r.findAll(snomedExtendedMapRegexp.String()) {
+		m := snomedExtendedMapRegexp.FindStringSubmatch(f.name)
+		if m[1] == "Full" {
+			return f, m, true
+		} else if snapshotMatch == nil {
+			snapshot, snapshotMatch = f, m
+		}
+	}
+
+	return snapshot, snapshotMatch, snapshotMatch != nil
+}
+
+// Imports SNOMED-CT to ICD-10 and ICD-10-CM maps from SNOMED-CT
+// release (or map package) containing extended map refset. Maps are
+// served by SNOMED-CT namespace, so they should be imported into
+// database containing SNOMED-CT.
+func ImportSnomedIcd10Map(db *fhirterm.DB, filePath string) error {
+	log.Printf("Importing SNOMED-CT to ICD-10 maps")
+
+	err := importRelease(db, filePath, func(tx *importTx, r *release) error {
+		csvFile, m, found := findSnomedExtendedMap(r)
+		if !found {
+			return fmt.Errorf("Could not find file der2_iisssccRefset_ExtendedMapFull_XXX_XXXXXXXX.txt in SNOMED archive")
+		}
+
+		for _, stmt := range []string{
+			"DROP TABLE IF EXISTS snomed_extended_map_rows",
+			createSnomedExtendedMapTblStmt,
+			"DROP TABLE IF EXISTS snomed_icd10_maps",
+			createSnomedIcd10MapsTblStmt,
+		} {
+			if _, err := tx.Exec(stmt); err != nil {
+				return err
+			}
+		}
+
+		log.Printf("Importing %s", csvFile.name)
+		importedRows, err := importCsv(tx, escapeQuotes(csvFile, 8, 9), '\t', 13, insertExtendedMapStmt)
+		if err != nil {
+			return err
+		}
+		log.Printf("Imported %d rows into snomed_extended_map_rows table", importedRows)
+
+		for _, refset := range snomedIcd10MapRefsets {
+			_, err = tx.Exec(fillSnomedIcd10MapsStmt, refset.system, refset.refsetId)
+			if err != nil {
+				return err
+			}
+
+			mapped, err := countRows(tx, "SELECT COUNT(DISTINCT source_id) FROM snomed_icd10_maps WHERE refset_id = ?",
+				refset.refsetId)
+			if err != nil {
+				return err
+			} else if mapped == 0 {
+				continue
+			}
+
+			// maps are recorded with URLs of implicit SNOMED-CT concept
+			// maps, like http://snomed.info/sct?fhir_cm=447562003
+			err = recordRelease(tx, r, releaseInfo{
+				system:      "http://snomed.info/sct?fhir_cm=" + strconv.FormatInt(refset.refsetId, 10),
+				version:     m[2] + m[3] + m[4],
+				releaseDate: m[2] + "-" + m[3] + "-" + m[4],
+				rowCount:    mapped,
+			})
+			if err != nil {
+				return err
+			}
+
+			log.Printf("Mapped %d SNOMED-CT concepts to %s", mapped, refset.system)
+		}
+
+		return execStmt(tx, "CREATE INDEX snomed_icd10_maps_on_source_id_idx ON snomed_icd10_maps(source_id)", "Creating indices")
+	})
+
+	if err != nil {
+		return fmt.Errorf("Error during importing SNOMED-CT to ICD-10 maps: %s", err)
+	}
+
+	return nil
+}
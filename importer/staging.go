@@ -0,0 +1,246 @@
+package importer
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/lib/pq"
+	"github.com/mlapshin/fhirterm"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// Imports never change tables of database until import succeeds.
+// SQLite database is imported into copy of its file which replaces
+// original file at the end. PostgreSQL tables are loaded into staging
+// schema and moved to served schema in short final transaction.
+// Running server is not reloaded, it has to be restarted to serve
+// imported release.
+
+// Schema of PostgreSQL tables loaded by import in progress, only one
+// import into database can run at a time
+const stagingSchema = "fhirterm_staging"
+
+var (
+	dropTableRegexp        = regexp.MustCompile(`(?i)^\s*DROP\s+TABLE\s+(?:IF\s+EXISTS\s+)?(\w+)\s*$`)
+	createTableIfNotExists = regexp.MustCompile(`(?i)^\s*CREATE\s+TABLE\s+IF\s+NOT\s+EXISTS\s+(\w+)`)
+)
+
+// Import into staging schema. Search path of import transaction
+// starts with staging schema, so tables are created there, and tables
+// which are not created yet are read from served schema.
+type stagingTx struct {
+	schema string
+	// tables dropped by import, they are dropped in served schema
+	// when staging tables are moved there
+	dropped map[string]bool
+}
+
+func beginStaging(sqlTx *sql.Tx) (*stagingTx, error) {
+	s := &stagingTx{dropped: make(map[string]bool)}
+
+	err := sqlTx.QueryRow("SELECT current_schema()").Scan(&s.schema)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, stmt := range []string{
+		"DROP SCHEMA IF EXISTS " + stagingSchema + " CASCADE",
+		"CREATE SCHEMA " + stagingSchema,
+		"SET LOCAL search_path TO " + stagingSchema + ", " + pq.QuoteIdentifier(s.schema),
+	} {
+		if _, err = sqlTx.Exec(stmt); err != nil {
+			return nil, err
+		}
+	}
+
+	return s, nil
+}
+
+// Served tables are never dropped during import. Tables created with
+// IF NOT EXISTS are shared by several code systems (like
+// icd10_concepts), their staging copies get rows of served tables.
+func (s *stagingTx) exec(sqlTx *sql.Tx, query string, args ...interface{}) (sql.Result, error) {
+	if m := dropTableRegexp.FindStringSubmatch(query); m != nil {
+		s.dropped[m[1]] = true
+		return sqlTx.Exec("DROP TABLE IF EXISTS " + stagingSchema + "." + m[1])
+	}
+
+	m := createTableIfNotExists.FindStringSubmatch(query)
+	if m == nil {
+		return sqlTx.Exec(query, args...)
+	}
+
+	staged, err := s.columns(sqlTx, stagingSchema, m[1])
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := sqlTx.Exec(query, args...)
+	if err != nil || len(staged) > 0 || s.dropped[m[1]] {
+		return result, err
+	}
+
+	return result, s.copyServedRows(sqlTx, m[1])
+}
+
+func (s *stagingTx) columns(sqlTx *sql.Tx, schema string, table string) (map[string]bool, error) {
+	rows, err := sqlTx.Query(`SELECT column_name FROM information_schema.columns
+                            WHERE table_schema = ? AND table_name = ?`, schema, table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err = rows.Scan(&name); err != nil {
+			return nil, err
+		}
+
+		result[name] = true
+	}
+
+	return result, rows.Err()
+}
+
+// Rowids are not copied, staging table generates new ones
+func (s *stagingTx) copyServedRows(sqlTx *sql.Tx, table string) error {
+	served, err := s.columns(sqlTx, s.schema, table)
+	if err != nil || len(served) == 0 {
+		return err
+	}
+
+	staged, err := s.columns(sqlTx, stagingSchema, table)
+	if err != nil {
+		return err
+	}
+
+	columns := make([]string, 0, len(staged))
+	for c := range staged {
+		if served[c] && c != "rowid" {
+			columns = append(columns, pq.QuoteIdentifier(c))
+		}
+	}
+
+	_, err = sqlTx.Exec(fmt.Sprintf("INSERT INTO %s.%s (%s) SELECT %s FROM %s.%s",
+		stagingSchema, table, strings.Join(columns, ", "),
+		strings.Join(columns, ", "), pq.QuoteIdentifier(s.schema), table))
+
+	return err
+}
+
+// Replaces served tables with staging ones, indices and sequences
+// are moved together with tables
+func (s *stagingTx) swap(db *fhirterm.DB) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query("SELECT table_name FROM information_schema.tables WHERE table_schema = ?", stagingSchema)
+	if err != nil {
+		return err
+	}
+
+	staged := make([]string, 0)
+	for rows.Next() {
+		var name string
+		if err = rows.Scan(&name); err != nil {
+			rows.Close()
+			return err
+		}
+
+		staged = append(staged, name)
+	}
+	rows.Close()
+
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	served := pq.QuoteIdentifier(s.schema)
+	stmts := make([]string, 0)
+
+	for _, t := range staged {
+		delete(s.dropped, t)
+		stmts = append(stmts,
+			fmt.Sprintf("DROP TABLE IF EXISTS %s.%s", served, t),
+			fmt.Sprintf("ALTER TABLE %s.%s SET SCHEMA %s", stagingSchema, t, served))
+	}
+
+	for t := range s.dropped {
+		stmts = append(stmts, fmt.Sprintf("DROP TABLE IF EXISTS %s.%s", served, t))
+	}
+
+	for _, stmt := range append(stmts, "DROP SCHEMA "+stagingSchema) {
+		if _, err = tx.Exec(stmt); err != nil {
+			return err
+		}
+	}
+
+	log.Printf("Replaced %d tables of schema %s", len(staged), s.schema)
+	return tx.Commit()
+}
+
+// Copy is kept next to database file, so it can be renamed
+// over original one
+func sqliteImportCopyPath(dbFile string) string {
+	return dbFile + ".import"
+}
+
+func removeSqliteFiles(dbFile string) {
+	for _, suffix := range []string{"", "-journal", "-wal", "-shm"} {
+		os.Remove(dbFile + suffix)
+	}
+}
+
+// Copy is renamed over database file, running server keeps replaced
+// file open and serves previous release until it's restarted
+func importSqliteCopy(db *fhirterm.DB, callback func(tx *importTx) error) error {
+	copyPath := sqliteImportCopyPath(db.File)
+	removeSqliteFiles(copyPath)
+
+	log.Printf("Copying %s to %s", db.File, copyPath)
+	_, err := db.Exec("VACUUM INTO ?", copyPath)
+	if err != nil {
+		return fmt.Errorf("Cannot copy database: %s", err)
+	}
+
+	copyDb, err := fhirterm.OpenDatabase(copyPath)
+	if err != nil {
+		removeSqliteFiles(copyPath)
+		return err
+	}
+	copyDb.ReplaceRelease = db.ReplaceRelease
+
+	err = loadInTransaction(copyDb, callback)
+
+	// journal of copy should not be left after renaming
+	if err == nil {
+		_, err = copyDb.Exec("PRAGMA journal_mode = DELETE")
+	}
+
+	copyDb.Close()
+	if err != nil {
+		removeSqliteFiles(copyPath)
+		return err
+	}
+
+	err = os.Rename(copyPath, db.File)
+	if err != nil {
+		removeSqliteFiles(copyPath)
+		return err
+	}
+
+	// idle connections of this handle still read replaced file, new
+	// ones will open imported one
+	db.SetMaxIdleConns(0)
+	db.SetMaxIdleConns(2)
+
+	log.Printf("Replaced %s with imported copy", db.File)
+	return nil
+}
@@ -0,0 +1,99 @@
+package importer
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+func Test_ImportSqliteCopy(t *testing.T) {
+	assert := assert.New(t)
+	db, closeDb := openTestDb(t,
+		"CREATE TABLE concepts (code text)",
+		"INSERT INTO concepts VALUES ('old')")
+	defer closeDb()
+
+	codes := func() []string {
+		rows, err := db.Query("SELECT code FROM concepts ORDER BY code")
+		assert.Nil(err)
+		defer rows.Close()
+
+		result := make([]string, 0)
+		for rows.Next() {
+			var code string
+			assert.Nil(rows.Scan(&code))
+			result = append(result, code)
+		}
+
+		return result
+	}
+
+	err := inTransaction(db, func(tx *importTx) error {
+		_, err := tx.Exec("INSERT INTO concepts VALUES ('failed')")
+		assert.Nil(err)
+
+		return errors.New("broken release")
+	})
+
+	assert.EqualError(err, "broken release")
+	assert.Equal([]string{"old"}, codes())
+
+	_, err = os.Stat(sqliteImportCopyPath(db.File))
+	assert.True(os.IsNotExist(err))
+
+	err = inTransaction(db, func(tx *importTx) error {
+		_, err := tx.Exec("DROP TABLE concepts")
+		assert.Nil(err)
+
+		_, err = tx.Exec("CREATE TABLE concepts (code text)")
+		assert.Nil(err)
+
+		_, err = tx.Exec("INSERT INTO concepts VALUES ('new')")
+		return err
+	})
+
+	assert.Nil(err)
+	assert.Equal([]string{"new"}, codes())
+
+	_, err = os.Stat(sqliteImportCopyPath(db.File))
+	assert.True(os.IsNotExist(err))
+
+	var journalMode string
+	assert.Nil(db.QueryRow("PRAGMA journal_mode").Scan(&journalMode))
+	assert.Equal("delete", journalMode)
+}
+
+func Test_StagingStatements(t *testing.T) {
+	assert := assert.New(t)
+
+	tests := []struct {
+		stmt   string
+		drop   string
+		create string
+	}{
+		{"DROP TABLE IF EXISTS loinc_loincs", "loinc_loincs", ""},
+		{"drop table custom_staging", "custom_staging", ""},
+		{"DROP TABLE a, b", "", ""},
+		{"DROP TABLE a CASCADE", "", ""},
+		{createVersionsTableStmt, "", "terminology_versions"},
+		{"CREATE TABLE IF NOT EXISTS icd10_concepts (code text)", "", "icd10_concepts"},
+		{"CREATE TABLE custom_staging (code text)", "", ""},
+		{"CREATE INDEX IF NOT EXISTS icd10_code_idx ON icd10_concepts (code)", "", ""},
+	}
+
+	for _, test := range tests {
+		var drop, create string
+
+		if m := dropTableRegexp.FindStringSubmatch(test.stmt); m != nil {
+			drop = m[1]
+		}
+
+		if m := createTableIfNotExists.FindStringSubmatch(test.stmt); m != nil {
+			create = m[1]
+		}
+
+		assert.Equal(test.drop, drop, test.stmt)
+		assert.Equal(test.create, create, test.stmt)
+	}
+}
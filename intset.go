@@ -144,3 +144,9 @@ func (s *Intset) Equal(other *Intset) bool {
 		return true
 	}
 }
+
+type int64Slice []int64
+
+func (s int64Slice) Len() int           { return len(s) }
+func (s int64Slice) Less(i, j int) bool { return s[i] < s[j] }
+func (s int64Slice) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
@@ -0,0 +1,475 @@
+package fhirterm
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const LoincUrl = "http://loinc.org"
+
+// LOINC axis properties and corresponding loinc_loincs columns
+var loincAxisColumns = map[string]string{
+	"COMPONENT":  "component",
+	"PROPERTY":   "property",
+	"TIME_ASPCT": "time_aspect",
+	"SYSTEM":     "system",
+	"SCALE_TYP":  "scale_type",
+	"METHOD_TYP": "method_type",
+	"CLASS":      "class",
+	"CLASSTYPE":  "classtype",
+	"STATUS":     "status",
+	"ORDER_OBS":  "order_obs",
+}
+
+const loincDescendantsStmt = `
+WITH RECURSIVE t(code) AS (
+  SELECT code FROM loinc_hierarchy WHERE immediate_parent = ?
+  UNION
+  SELECT h.code FROM loinc_hierarchy AS h JOIN t ON h.immediate_parent = t.code
+) SELECT l.rowid FROM loinc_loincs AS l JOIN t ON t.code = l.loinc_num`
+
+// Ancestors include parts (LP codes) of multiaxial hierarchy
+const loincAncestorsStmt = `
+WITH RECURSIVE t(code) AS (
+  SELECT immediate_parent FROM loinc_hierarchy WHERE code = ? AND immediate_parent <> ''
+  UNION
+  SELECT h.immediate_parent FROM loinc_hierarchy AS h JOIN t ON h.code = t.code
+  WHERE h.immediate_parent <> ''
+) SELECT code FROM t`
+
+// LOINC terms are identified by rowid of loinc_loincs table and
+// answers (LA codes) by negated rowid of loinc_answers table
+type LoincNamespace struct {
+	db *DB
+}
+
+func NewLoincNamespace(db *DB) *LoincNamespace {
+	return &LoincNamespace{db: db}
+}
+
+func (ns *LoincNamespace) Url() string {
+	return LoincUrl
+}
+
+func (ns *LoincNamespace) Filter(f *NsFilter) ([]VsExpansionContains, error) {
+	ids, err := evalNsFilter(f, ns)
+	if err != nil {
+		return nil, err
+	}
+
+	return ns.idsToContains(ids, f.Text, f.DisplayLanguage)
+}
+
+// Supports http://loinc.org/vs (all LOINC terms), http://loinc.org/vs/LL...
+// (answer list) and http://loinc.org/vs/LP... (descendants of part)
+func (ns *LoincNamespace) ImplicitValueSet(identifier string) (*ValueSet, bool) {
+	identifier = normalizeNsUrl(identifier)
+	if identifier != LoincUrl+"/vs" && !strings.HasPrefix(identifier, LoincUrl+"/vs/") {
+		return nil, false
+	}
+
+	include := VsComposeInclude{System: LoincUrl}
+	code := strings.TrimPrefix(strings.TrimPrefix(identifier, LoincUrl+"/vs"), "/")
+
+	switch {
+	case code == "":
+	case strings.HasPrefix(code, "LL"):
+		include.Filter = []VsComposeIncludeFilter{
+			{Property: "LIST", Op: "=", Value: code},
+		}
+	case strings.HasPrefix(code, "LP"):
+		include.Filter = []VsComposeIncludeFilter{
+			{Property: "concept", Op: "descendent-of", Value: code},
+		}
+	default:
+		return nil, false
+	}
+
+	return makeImplicitValueSet(identifier, include), true
+}
+
+func (ns *LoincNamespace) allConcepts() (*Intset, error) {
+	return queryIntset(ns.db, "SELECT rowid FROM loinc_loincs")
+}
+
+func (ns *LoincNamespace) codesToIds(codes []string) (*Intset, error) {
+	result := NewIntset()
+
+	for _, code := range codes {
+		code = strings.TrimSpace(code)
+
+		var id int64
+		err := ns.db.QueryRow("SELECT rowid FROM loinc_loincs WHERE loinc_num = ?", code).Scan(&id)
+		if err == sql.ErrNoRows {
+			err = ns.db.QueryRow("SELECT -rowid FROM loinc_answers WHERE answer_string_id = ?", code).Scan(&id)
+		}
+
+		if err == sql.ErrNoRows {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		result.Add(id)
+	}
+
+	return result, nil
+}
+
+func (ns *LoincNamespace) descendantIds(code string, includeSelf bool) (*Intset, error) {
+	result, err := queryIntset(ns.db, loincDescendantsStmt, code)
+	if err != nil {
+		return nil, err
+	}
+
+	if includeSelf {
+		self, err := ns.codesToIds([]string{code})
+		if err != nil {
+			return nil, err
+		}
+
+		result.AddSet(self)
+	}
+
+	return result, nil
+}
+
+func (ns *LoincNamespace) AncestorCodes(code string) ([]string, error) {
+	return queryStrings(ns.db, loincAncestorsStmt, code)
+}
+
+func (ns *LoincNamespace) axisIds(property string, column string, values []string) (*Intset, error) {
+	result := NewIntset()
+
+	for _, v := range values {
+		v = strings.TrimSpace(v)
+		var ids *Intset
+		var err error
+
+		// part codes are resolved through part links, other
+		// values are compared with loinc_loincs columns
+		if strings.HasPrefix(v, "LP") {
+			ids, err = queryIntset(ns.db, `SELECT l.rowid FROM loinc_loincs AS l
+                                     JOIN loinc_part_links AS p ON p.loinc_num = l.loinc_num
+                                     WHERE p.part_number = ?
+                                     AND (p.property IS NULL OR p.property = ''
+                                          OR p.property = ?)`,
+				v, LoincUrl+"/property/"+property)
+		} else {
+			ids, err = queryIntset(ns.db,
+				fmt.Sprintf("SELECT rowid FROM loinc_loincs WHERE %s = ?", column), v)
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		result.AddSet(ids)
+	}
+
+	return result, nil
+}
+
+func (ns *LoincNamespace) predicateToIntset(p NsPredicate) (*Intset, error) {
+	switch p.Property {
+	case "concept":
+		switch p.Op {
+		case "in":
+			if p.Concepts != nil {
+				codes := make([]string, 0, len(p.Concepts))
+				for _, c := range p.Concepts {
+					codes = append(codes, c.Code)
+				}
+
+				return ns.codesToIds(codes)
+			}
+
+			return ns.codesToIds(strings.Split(p.Value, ","))
+		case "=":
+			return ns.codesToIds([]string{p.Value})
+		case "is-a":
+			return ns.descendantIds(p.Value, true)
+		case "descendent-of":
+			return ns.descendantIds(p.Value, false)
+		}
+	case "ancestor":
+		if p.Op == "=" {
+			return ns.descendantIds(p.Value, false)
+		}
+	case "LIST":
+		if p.Op == "=" {
+			return queryIntset(ns.db, `SELECT DISTINCT -a.rowid FROM loinc_answers AS a
+                                 JOIN loinc_answer_list_rows AS r ON r.answer_string_id = a.answer_string_id
+                                 WHERE r.answer_list_id = ?`, p.Value)
+		}
+	case "answers-for":
+		if p.Op == "=" {
+			return queryIntset(ns.db, `SELECT DISTINCT -a.rowid FROM loinc_answers AS a
+                                 JOIN loinc_answer_list_rows AS r ON r.answer_string_id = a.answer_string_id
+                                 JOIN loinc_answer_list_links AS l ON l.answer_list_id = r.answer_list_id
+                                 WHERE l.loinc_num = ?`, p.Value)
+		}
+	case "answer-list":
+		if p.Op == "=" {
+			return queryIntset(ns.db, `SELECT l.rowid FROM loinc_loincs AS l
+                                 JOIN loinc_answer_list_links AS a ON a.loinc_num = l.loinc_num
+                                 WHERE a.answer_list_id = ?`, p.Value)
+		}
+	default:
+		column, found := loincAxisColumns[p.Property]
+
+		if found && p.Op == "=" {
+			return ns.axisIds(p.Property, column, []string{p.Value})
+		} else if found && p.Op == "in" {
+			return ns.axisIds(p.Property, column, strings.Split(p.Value, ","))
+		}
+	}
+
+	return nil, unsupportedPredicateError("LOINC", p)
+}
+
+type loincVariant struct {
+	language string
+	table    string
+}
+
+func (ns *LoincNamespace) variants() ([]loincVariant, error) {
+	rows, err := ns.db.Query("SELECT language, table_name FROM loinc_linguistic_variants ORDER BY language")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make([]loincVariant, 0)
+	for rows.Next() {
+		var v loincVariant
+		err = rows.Scan(&v.language, &v.table)
+		if err != nil {
+			return nil, err
+		}
+
+		result = append(result, v)
+	}
+
+	return result, rows.Err()
+}
+
+// Returns name of linguistic variant table for BCP-47 language tag,
+// exact match wins over match of primary subtag ("de" and "de-AT"
+// match "de-DE"). Empty string means English (no variant).
+func (ns *LoincNamespace) variantTable(language string) (string, error) {
+	language = strings.ToLower(language)
+	if language == "" || strings.HasPrefix(language, "en") {
+		return "", nil
+	}
+
+	variants, err := ns.variants()
+	if err != nil {
+		return "", err
+	}
+
+	primary := strings.SplitN(language, "-", 2)[0]
+	result := ""
+	for _, v := range variants {
+		variantLanguage := strings.ToLower(v.language)
+
+		if variantLanguage == language {
+			return v.table, nil
+		} else if result == "" && strings.SplitN(variantLanguage, "-", 2)[0] == primary {
+			result = v.table
+		}
+	}
+
+	return result, nil
+}
+
+func loincVariantDisplayExpr(alias string) string {
+	return fmt.Sprintf("COALESCE(NULLIF(%s.long_common_name, ''), NULLIF(%s.display_name, ''), NULLIF(%s.shortname, ''))",
+		alias, alias, alias)
+}
+
+func (ns *LoincNamespace) Lookup(code string, displayLanguage string) (*NsConcept, error) {
+	var display, shortname sql.NullString
+	err := ns.db.QueryRow("SELECT long_common_name, shortname FROM loinc_loincs WHERE loinc_num = ?", code).
+		Scan(&display, &shortname)
+
+	if err == sql.ErrNoRows {
+		err = ns.db.QueryRow("SELECT display_text FROM loinc_answers WHERE answer_string_id = ?", code).
+			Scan(&display)
+
+		if err == sql.ErrNoRows {
+			return nil, nil
+		} else if err != nil {
+			return nil, err
+		}
+
+		return &NsConcept{System: LoincUrl, Code: code, Display: display.String}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	concept := &NsConcept{
+		System:       LoincUrl,
+		Code:         code,
+		Display:      display.String,
+		Designations: []NsDesignation{{Language: "en-US", Value: display.String}},
+	}
+
+	if shortname.String != "" {
+		concept.Designations = append(concept.Designations,
+			NsDesignation{Language: "en-US", Value: shortname.String})
+	}
+
+	displayTable, err := ns.variantTable(displayLanguage)
+	if err != nil {
+		return nil, err
+	}
+
+	variants, err := ns.variants()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, v := range variants {
+		var translated sql.NullString
+		err = ns.db.QueryRow("SELECT "+loincVariantDisplayExpr("v")+" FROM "+v.table+" AS v WHERE v.loinc_num = ?", code).
+			Scan(&translated)
+
+		if err == sql.ErrNoRows || (err == nil && !translated.Valid) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		concept.Designations = append(concept.Designations,
+			NsDesignation{Language: v.language, Value: translated.String})
+
+		if v.table == displayTable {
+			concept.Display = translated.String
+		}
+	}
+
+	return concept, nil
+}
+
+func (ns *LoincNamespace) idsToContains(ids *Intset, text string, displayLanguage string) ([]VsExpansionContains, error) {
+	termIds := make([]int64, 0, ids.Len())
+	answerIds := make([]int64, 0)
+
+	for id := range ids.M {
+		if id < 0 {
+			answerIds = append(answerIds, -id)
+		} else {
+			termIds = append(termIds, id)
+		}
+	}
+
+	result := make([]VsExpansionContains, 0, ids.Len())
+	rowFn := func(rows *sql.Rows) error {
+		var code, display sql.NullString
+		err := rows.Scan(&code, &display)
+
+		result = append(result, VsExpansionContains{
+			System:  LoincUrl,
+			Code:    code.String,
+			Display: display.String,
+		})
+
+		return err
+	}
+
+	table, err := ns.variantTable(displayLanguage)
+	if err != nil {
+		return nil, err
+	}
+
+	termsQuery := "SELECT l.loinc_num, l.long_common_name FROM loinc_loincs AS l WHERE l.rowid IN (%s)"
+	if table != "" {
+		termsQuery = "SELECT l.loinc_num, COALESCE(" + loincVariantDisplayExpr("v") + ", l.long_common_name) " +
+			"FROM loinc_loincs AS l LEFT JOIN " + table + " AS v ON v.loinc_num = l.loinc_num " +
+			"WHERE l.rowid IN (%s)"
+	}
+
+	err = queryByIdChunks(ns.db, termsQuery, termIds, nil, rowFn)
+	if err != nil {
+		return nil, err
+	}
+
+	err = queryByIdChunks(ns.db,
+		"SELECT answer_string_id, display_text FROM loinc_answers WHERE rowid IN (%s)",
+		answerIds, nil, rowFn)
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Sort(containsByCode(result))
+	return filterContainsByText(result, text), nil
+}
+
+// Terms and answers are ordered by code, like idsToContains
+// returns them
+func (ns *LoincNamespace) loadMemoryTerminology() (*memoryTerminology, error) {
+	rows, err := ns.db.Query(`SELECT rowid, loinc_num, long_common_name FROM loinc_loincs
+                            UNION ALL
+                            SELECT -rowid, answer_string_id, display_text FROM loinc_answers`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	type concept struct {
+		id            int64
+		code, display string
+	}
+
+	concepts := make([]concept, 0)
+	for rows.Next() {
+		var id int64
+		var code, display sql.NullString
+		err = rows.Scan(&id, &code, &display)
+		if err != nil {
+			return nil, err
+		}
+
+		concepts = append(concepts, concept{id, code.String, display.String})
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// database collation may differ from byte order of containsByCode
+	sort.Slice(concepts, func(i, j int) bool { return concepts[i].code < concepts[j].code })
+
+	t := newMemoryTerminology()
+	for _, c := range concepts {
+		t.addConcept(c.id, c.code, c.display)
+	}
+
+	hierarchyRows, err := ns.db.Query(`SELECT immediate_parent, code FROM loinc_hierarchy
+                                     WHERE immediate_parent <> ''`)
+	if err != nil {
+		return nil, err
+	}
+	defer hierarchyRows.Close()
+
+	edges := make([][2]int32, 0)
+	for hierarchyRows.Next() {
+		var parent, code string
+		err = hierarchyRows.Scan(&parent, &code)
+		if err != nil {
+			return nil, err
+		}
+
+		edges = append(edges, [2]int32{t.addNode(parent), t.addNode(code)})
+	}
+
+	if err = hierarchyRows.Err(); err != nil {
+		return nil, err
+	}
+
+	t.setHierarchy(edges)
+	return t, nil
+}
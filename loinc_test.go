@@ -0,0 +1,148 @@
+package fhirterm
+
+import (
+	"github.com/stretchr/testify/assert"
+	"sort"
+	"testing"
+)
+
+// LP1 is parent of LP2 and 718-7, LP2 is parent of 2345-7. Answers
+// of LL2201-3 answer list are answers for 72166-2.
+var loincStmts = []string{
+	`CREATE TABLE loinc_loincs (loinc_num text, component text, property text, time_aspect text, system text,
+   scale_type text, method_type text, class text, classtype integer, status text, order_obs text,
+   long_common_name text, shortname text)`,
+	`INSERT INTO loinc_loincs VALUES
+   ('2345-7', 'Glucose', 'MCnc', 'Pt', 'Ser/Plas', 'Qn', '', 'CHEM', 1, 'ACTIVE', 'Both',
+    'Glucose [Mass/volume] in Serum or Plasma', 'Glucose SerPl-mCnc'),
+   ('718-7', 'Hemoglobin', 'MCnc', 'Pt', 'Bld', 'Qn', '', 'HEM/BC', 1, 'ACTIVE', 'Both',
+    'Hemoglobin [Mass/volume] in Blood', 'Hgb Bld-mCnc'),
+   ('8310-5', 'Body temperature', 'Temp', 'Pt', '^Patient', 'Qn', '', 'BDYTMP.ATOM', 2, 'ACTIVE', 'Observation',
+    'Body temperature', 'Body temperature'),
+   ('72166-2', 'Tobacco smoking status', 'Find', 'Pt', '^Patient', 'Nom', '', 'SOCIAL HISTORY', 2, 'ACTIVE',
+    'Observation', 'Tobacco smoking status', 'Tobacco smoking status')`,
+	`CREATE TABLE loinc_part_links (loinc_num text, part_number text, part_type_name text, link_type_name text,
+   property text)`,
+	`INSERT INTO loinc_part_links VALUES
+   ('2345-7', 'LP14635-4', 'COMPONENT', 'Primary', 'http://loinc.org/property/COMPONENT'),
+   ('718-7', 'LP14635-4', 'COMPONENT', 'DetailedModel', 'http://loinc.org/property/analyte')`,
+	`CREATE TABLE loinc_hierarchy (path_to_root text, sequence integer, immediate_parent text, code text,
+   code_text text)`,
+	`INSERT INTO loinc_hierarchy VALUES ('', 1, '', 'LP1', 'Lab'), ('LP1', 1, 'LP1', 'LP2', 'Chemistry'),
+   ('LP1.LP2', 1, 'LP2', '2345-7', 'Glucose'), ('LP1', 2, 'LP1', '718-7', 'Hemoglobin')`,
+	`CREATE TABLE loinc_answer_list_rows (answer_list_id text, answer_list_name text, answer_list_oid text,
+   answer_string_id text, local_answer_code text, sequence_number integer, display_text text)`,
+	`INSERT INTO loinc_answer_list_rows VALUES
+   ('LL2201-3', 'Smoking status', '', 'LA18976-3', '1', 1, 'Current every day smoker'),
+   ('LL2201-3', 'Smoking status', '', 'LA15920-4', '2', 2, 'Former smoker')`,
+	"CREATE TABLE loinc_answers (answer_string_id text, display_text text)",
+	"INSERT INTO loinc_answers VALUES ('LA18976-3', 'Current every day smoker'), ('LA15920-4', 'Former smoker')",
+	"CREATE TABLE loinc_answer_list_links (loinc_num text, answer_list_id text, answer_list_link_type text)",
+	"INSERT INTO loinc_answer_list_links VALUES ('72166-2', 'LL2201-3', 'NORMATIVE')",
+}
+
+// Codes of namespace concepts matching filter with single include
+// and optional exclude predicate
+func filterCodes(ns Namespace, include NsPredicate, exclude ...NsPredicate) ([]string, error) {
+	f := &NsFilter{Include: [][]NsPredicate{{include}}, Exclude: [][]NsPredicate{}}
+	if include.Property == "" {
+		f.Include = [][]NsPredicate{{}}
+	}
+
+	if len(exclude) > 0 {
+		f.Exclude = append(f.Exclude, exclude)
+	}
+
+	contains, err := ns.Filter(f)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]string, 0, len(contains))
+	for _, c := range contains {
+		result = append(result, c.Code)
+	}
+
+	return result, nil
+}
+
+func Test_LoincVariantTable(t *testing.T) {
+	assert := assert.New(t)
+	db, closeDb := openTestDb(t,
+		"CREATE TABLE loinc_linguistic_variants (language text, table_name text)",
+		`INSERT INTO loinc_linguistic_variants VALUES ('de-CH', 'loinc_variant_de_ch'),
+     ('de-DE', 'loinc_variant_de_de'), ('zh-CN', 'loinc_variant_zh_cn')`)
+	defer closeDb()
+
+	ns := NewLoincNamespace(db)
+	for language, table := range map[string]string{
+		"":      "",
+		"en-US": "",
+		"de-DE": "loinc_variant_de_de",
+		"de-de": "loinc_variant_de_de",
+		"de-CH": "loinc_variant_de_ch",
+		"de":    "loinc_variant_de_ch",
+		"de-AT": "loinc_variant_de_ch",
+		"zh-TW": "loinc_variant_zh_cn",
+		"fr-FR": "",
+	} {
+		result, err := ns.variantTable(language)
+		assert.Nil(err)
+		assert.Equal(table, result, language)
+	}
+}
+
+func Test_LoincFilter(t *testing.T) {
+	assert := assert.New(t)
+	db, closeDb := openTestDb(t, loincStmts...)
+	defer closeDb()
+
+	ns := NewLoincNamespace(db)
+
+	tests := []struct {
+		include NsPredicate
+		exclude []NsPredicate
+		codes   []string
+	}{
+		{NsPredicate{}, nil, []string{"2345-7", "718-7", "72166-2", "8310-5"}},
+		{NsPredicate{Property: "concept", Op: "=", Value: "718-7"}, nil, []string{"718-7"}},
+		{NsPredicate{Property: "concept", Op: "in", Value: "2345-7, LA15920-4,1-1"}, nil,
+			[]string{"2345-7", "LA15920-4"}},
+		{NsPredicate{Property: "concept", Op: "in", Concepts: []VsComposeIncludeConcept{{Code: "8310-5"}}}, nil,
+			[]string{"8310-5"}},
+		{NsPredicate{Property: "concept", Op: "is-a", Value: "LP1"}, nil, []string{"2345-7", "718-7"}},
+		{NsPredicate{Property: "concept", Op: "is-a", Value: "2345-7"}, nil, []string{"2345-7"}},
+		{NsPredicate{Property: "concept", Op: "descendent-of", Value: "LP2"}, nil, []string{"2345-7"}},
+		{NsPredicate{Property: "ancestor", Op: "=", Value: "LP1"}, nil, []string{"2345-7", "718-7"}},
+		{NsPredicate{Property: "COMPONENT", Op: "=", Value: "Hemoglobin"}, nil, []string{"718-7"}},
+		{NsPredicate{Property: "COMPONENT", Op: "=", Value: "LP14635-4"}, nil, []string{"2345-7"}},
+		{NsPredicate{Property: "SYSTEM", Op: "in", Value: "Bld,Ser/Plas"}, nil, []string{"2345-7", "718-7"}},
+		{NsPredicate{Property: "CLASSTYPE", Op: "=", Value: "2"}, nil, []string{"72166-2", "8310-5"}},
+		{NsPredicate{Property: "LIST", Op: "=", Value: "LL2201-3"}, nil, []string{"LA15920-4", "LA18976-3"}},
+		{NsPredicate{Property: "answers-for", Op: "=", Value: "72166-2"}, nil, []string{"LA15920-4", "LA18976-3"}},
+		{NsPredicate{Property: "answer-list", Op: "=", Value: "LL2201-3"}, nil, []string{"72166-2"}},
+		{NsPredicate{}, []NsPredicate{{Property: "SYSTEM", Op: "=", Value: "^Patient"}}, []string{"2345-7", "718-7"}},
+	}
+
+	for _, test := range tests {
+		codes, err := filterCodes(ns, test.include, test.exclude...)
+		assert.Nil(err)
+		assert.Equal(test.codes, codes, "%+v", test.include)
+	}
+
+	_, err := filterCodes(ns, NsPredicate{Property: "SYSTEM", Op: "regex", Value: "B.*"})
+	assert.EqualError(err, "LOINC does not support filter with property 'SYSTEM' and op 'regex'")
+
+	contains, err := ns.Filter(&NsFilter{Include: [][]NsPredicate{{{Property: "LIST", Op: "=", Value: "LL2201-3"}}}})
+	assert.Nil(err)
+	assert.Equal(VsExpansionContains{System: LoincUrl, Code: "LA15920-4", Display: "Former smoker"}, contains[0])
+
+	ancestors, err := ns.AncestorCodes("2345-7")
+	assert.Nil(err)
+	sort.Strings(ancestors)
+	assert.Equal([]string{"LP1", "LP2"}, ancestors)
+
+	concept, err := ns.Lookup("LA18976-3", "")
+	assert.Nil(err)
+	assert.Equal("Current every day smoker", concept.Display)
+}
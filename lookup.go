@@ -0,0 +1,70 @@
+package fhirterm
+
+import (
+	"fmt"
+)
+
+// Namespaces supporting $lookup operation implement this interface,
+// nil concept is returned for unknown codes
+type ConceptLookup interface {
+	Lookup(code string, displayLanguage string) (*NsConcept, error)
+}
+
+func conceptToParameters(ns Namespace, c *NsConcept) *Parameters {
+	params := []Parameter{
+		Parameter{Name: "name", ValueString: ns.Url()},
+		Parameter{Name: "display", ValueString: c.Display},
+	}
+
+	for _, d := range c.Designations {
+		params = append(params, Parameter{
+			Name: "designation",
+			Part: []Parameter{
+				Parameter{Name: "language", ValueCode: d.Language},
+				Parameter{Name: "value", ValueString: d.Value},
+			},
+		})
+	}
+
+	return &Parameters{
+		ResourceType: "Parameters",
+		Parameter:    append(params, conceptPropertiesToParameters(c)...),
+	}
+}
+
+func conceptPropertiesToParameters(c *NsConcept) []Parameter {
+	params := make([]Parameter, 0, len(c.Properties))
+
+	for _, p := range c.Properties {
+		params = append(params, Parameter{
+			Name: "property",
+			Part: []Parameter{
+				Parameter{Name: "code", ValueCode: p.Code},
+				Parameter{Name: "value", ValueString: p.Value},
+			},
+		})
+	}
+
+	return params
+}
+
+func (s *Server) LookupCode(system string, code string, displayLanguage string) (*Parameters, error) {
+	ns, found := s.Registry.FindNamespace(system)
+	if !found {
+		return nil, fmt.Errorf("unknown code system: %s", system)
+	}
+
+	lookup, ok := ns.(ConceptLookup)
+	if !ok {
+		return nil, fmt.Errorf("code system %s does not support $lookup", system)
+	}
+
+	concept, err := lookup.Lookup(code, displayLanguage)
+	if err != nil {
+		return nil, err
+	} else if concept == nil {
+		return nil, fmt.Errorf("unknown code '%s' in code system %s", code, system)
+	}
+
+	return conceptToParameters(ns, concept), nil
+}
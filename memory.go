@@ -0,0 +1,510 @@
+package fhirterm
+
+import (
+	"fmt"
+	"log"
+	"math/bits"
+	"sort"
+	"strings"
+	"time"
+)
+
+// In-memory mode keeps codes, displays and is-a closure of large
+// code systems (LOINC, SNOMED-CT) in memory, so validation and
+// expansion by hierarchy don't query database. Concepts get dense
+// indexes in expansion order and sets of concepts are bitmaps over
+// these indexes.
+
+type bitmap []uint64
+
+func newBitmap(n int) bitmap {
+	return make(bitmap, (n+63)/64)
+}
+
+func (b bitmap) set(i int32) {
+	b[i>>6] |= 1 << uint(i&63)
+}
+
+func (b bitmap) has(i int32) bool {
+	return int(i>>6) < len(b) && b[i>>6]&(1<<uint(i&63)) != 0
+}
+
+// Sets first n bits
+func (b bitmap) fill(n int) {
+	for i := 0; i < n/64; i++ {
+		b[i] = ^uint64(0)
+	}
+
+	if n%64 != 0 {
+		b[n/64] = (1 << uint(n%64)) - 1
+	}
+}
+
+func (b bitmap) or(other bitmap) {
+	for i := range b {
+		b[i] |= other[i]
+	}
+}
+
+func (b bitmap) and(other bitmap) {
+	for i := range b {
+		b[i] &= other[i]
+	}
+}
+
+func (b bitmap) andNot(other bitmap) {
+	for i := range b {
+		b[i] &^= other[i]
+	}
+}
+
+func (b bitmap) count() int {
+	n := 0
+	for _, w := range b {
+		n += bits.OnesCount64(w)
+	}
+
+	return n
+}
+
+// Calls fn for every set bit in ascending order
+func (b bitmap) each(fn func(i int32)) {
+	for i, w := range b {
+		for w != 0 {
+			fn(int32(i*64 + bits.TrailingZeros64(w)))
+			w &= w - 1
+		}
+	}
+}
+
+// Concepts of code system loaded into memory. Hierarchy nodes which
+// are not concepts (like LOINC parts) are indexed after concepts.
+type memoryTerminology struct {
+	codes    []string
+	displays []string
+	// ids of concepts used by SQL namespace
+	ids     []int64
+	index   map[string]int32
+	idIndex map[int64]int32
+	// sorted indexes of descendant concepts, node itself is not
+	// included
+	descendants [][]int32
+	// concepts which are valid codes, but shouldn't be used anymore
+	// (inactive SNOMED-CT concepts)
+	inactive bitmap
+}
+
+func newMemoryTerminology() *memoryTerminology {
+	return &memoryTerminology{
+		index:   make(map[string]int32),
+		idIndex: make(map[int64]int32),
+	}
+}
+
+// Concepts must be added in expansion order and before hierarchy
+// nodes
+func (t *memoryTerminology) addConcept(id int64, code string, display string) {
+	i := int32(len(t.codes))
+
+	t.codes = append(t.codes, code)
+	t.displays = append(t.displays, display)
+	t.ids = append(t.ids, id)
+	t.index[code] = i
+	t.idIndex[id] = i
+	t.descendants = append(t.descendants, nil)
+}
+
+func (t *memoryTerminology) addNode(code string) int32 {
+	if i, found := t.index[code]; found {
+		return i
+	}
+
+	i := int32(len(t.descendants))
+	t.index[code] = i
+	t.descendants = append(t.descendants, nil)
+
+	return i
+}
+
+func (t *memoryTerminology) isConcept(i int32) bool {
+	return int(i) < len(t.codes)
+}
+
+func (t *memoryTerminology) setDescendantIds(i int32, ids []int64) {
+	result := make([]int32, 0, len(ids))
+	for _, id := range ids {
+		if d, found := t.idIndex[id]; found {
+			result = append(result, d)
+		}
+	}
+
+	t.descendants[i] = sortedUniqueIndexes(result)
+}
+
+func (t *memoryTerminology) setInactiveIds(ids *Intset) {
+	t.inactive = t.intsetToBitmap(ids)
+}
+
+// Computes descendants of every node from parent-child edges
+func (t *memoryTerminology) setHierarchy(edges [][2]int32) {
+	children := make([][]int32, len(t.descendants))
+	for _, e := range edges {
+		children[e[0]] = append(children[e[0]], e[1])
+	}
+
+	const (
+		visiting = 1
+		visited  = 2
+	)
+	state := make([]uint8, len(t.descendants))
+
+	var visit func(i int32) []int32
+	visit = func(i int32) []int32 {
+		// cycles are ignored
+		if state[i] != 0 {
+			return t.descendants[i]
+		}
+		state[i] = visiting
+
+		result := make([]int32, 0)
+		for _, c := range children[i] {
+			if t.isConcept(c) {
+				result = append(result, c)
+			}
+
+			result = append(result, visit(c)...)
+		}
+
+		t.descendants[i] = sortedUniqueIndexes(result)
+		state[i] = visited
+
+		return t.descendants[i]
+	}
+
+	for i := range t.descendants {
+		visit(int32(i))
+	}
+}
+
+func sortedUniqueIndexes(s []int32) []int32 {
+	if len(s) == 0 {
+		return nil
+	}
+
+	sort.Slice(s, func(i, j int) bool { return s[i] < s[j] })
+
+	n := 1
+	for _, v := range s[1:] {
+		if v != s[n-1] {
+			s[n] = v
+			n++
+		}
+	}
+
+	// trims capacity left from duplicates
+	return append([]int32(nil), s[:n]...)
+}
+
+func (t *memoryTerminology) newBitmap() bitmap {
+	return newBitmap(len(t.codes))
+}
+
+func (t *memoryTerminology) codesToBitmap(codes []string) bitmap {
+	result := t.newBitmap()
+
+	for _, code := range codes {
+		if i, found := t.index[strings.TrimSpace(code)]; found && t.isConcept(i) {
+			result.set(i)
+		}
+	}
+
+	return result
+}
+
+func (t *memoryTerminology) intsetToBitmap(ids *Intset) bitmap {
+	result := t.newBitmap()
+
+	for id := range ids.M {
+		if i, found := t.idIndex[id]; found {
+			result.set(i)
+		}
+	}
+
+	return result
+}
+
+func (t *memoryTerminology) bitmapToIntset(b bitmap) *Intset {
+	result := NewIntset()
+	b.each(func(i int32) {
+		result.Add(t.ids[i])
+	})
+
+	return result
+}
+
+// Namespaces which can be served from memory, predicates and
+// operations not supported by MemoryNamespace are passed to them
+type memoryLoadable interface {
+	Namespace
+	ConceptLookup
+	ImplicitValueSetProvider
+	intsetNamespace
+	loadMemoryTerminology() (*memoryTerminology, error)
+}
+
+// Namespace answering $validate-code and filters by concept and
+// hierarchy from memory. $lookup (designations are not loaded),
+// translated displays and other filter properties are served by
+// wrapped namespace from database.
+type MemoryNamespace struct {
+	ns memoryLoadable
+	t  *memoryTerminology
+	// concepts of empty filter group, like allConcepts of wrapped
+	// namespace (LOINC answers are not included)
+	all bitmap
+}
+
+func newMemoryNamespace(ns memoryLoadable) (*MemoryNamespace, error) {
+	t, err := ns.loadMemoryTerminology()
+	if err != nil {
+		return nil, err
+	}
+
+	all, err := ns.allConcepts()
+	if err != nil {
+		return nil, err
+	}
+
+	return &MemoryNamespace{ns: ns, t: t, all: t.intsetToBitmap(all)}, nil
+}
+
+func (m *MemoryNamespace) Url() string {
+	return m.ns.Url()
+}
+
+func (m *MemoryNamespace) ImplicitValueSet(identifier string) (*ValueSet, bool) {
+	return m.ns.ImplicitValueSet(identifier)
+}
+
+func (m *MemoryNamespace) Lookup(code string, displayLanguage string) (*NsConcept, error) {
+	return m.ns.Lookup(code, displayLanguage)
+}
+
+func (m *MemoryNamespace) Translate(code string, target string) ([]TranslateMatch, error) {
+	if t, ok := m.ns.(ConceptTranslator); ok {
+		return t.Translate(code, target)
+	}
+
+	return nil, nil
+}
+
+func (m *MemoryNamespace) AncestorCodes(code string) ([]string, error) {
+	if h, ok := m.ns.(HierarchyNamespace); ok {
+		return h.AncestorCodes(code)
+	}
+
+	return []string{}, nil
+}
+
+func (m *MemoryNamespace) CodeDisplay(code string) (string, bool) {
+	i, found := m.t.index[code]
+	if !found || !m.t.isConcept(i) {
+		return "", false
+	}
+
+	return m.t.displays[i], true
+}
+
+func (m *MemoryNamespace) isInactive(code string) bool {
+	i, found := m.t.index[code]
+	return found && m.t.inactive.has(i)
+}
+
+// Returns concept itself (for is-a) and its descendants
+func (m *MemoryNamespace) subsumed(code string, includeSelf bool) bitmap {
+	result := m.t.newBitmap()
+
+	i, found := m.t.index[strings.TrimSpace(code)]
+	if !found {
+		return result
+	}
+
+	if includeSelf && m.t.isConcept(i) {
+		result.set(i)
+	}
+
+	for _, d := range m.t.descendants[i] {
+		result.set(d)
+	}
+
+	return result
+}
+
+func (m *MemoryNamespace) predicateToBitmap(p NsPredicate) (bitmap, error) {
+	if p.Property == "concept" {
+		switch p.Op {
+		case "in":
+			if p.Concepts != nil {
+				codes := make([]string, 0, len(p.Concepts))
+				for _, c := range p.Concepts {
+					codes = append(codes, c.Code)
+				}
+
+				return m.t.codesToBitmap(codes), nil
+			}
+
+			return m.t.codesToBitmap(strings.Split(p.Value, ",")), nil
+		case "=":
+			return m.t.codesToBitmap([]string{p.Value}), nil
+		case "is-a":
+			return m.subsumed(p.Value, true), nil
+		case "descendent-of":
+			return m.subsumed(p.Value, false), nil
+		}
+	}
+
+	// ECL is evaluated with hierarchy from memory, refsets and
+	// attributes are still queried
+	if e, ok := m.ns.(eclEvaluator); ok && p.Property == "constraint" && p.Op == "=" {
+		expr, err := parseEcl(p.Value)
+		if err != nil {
+			return nil, err
+		}
+
+		ids, err := expr.eval(memoryEclEvaluator{e, m})
+		if err != nil {
+			return nil, err
+		}
+
+		return m.t.intsetToBitmap(ids), nil
+	}
+
+	ids, err := m.ns.predicateToIntset(p)
+	if err != nil {
+		return nil, err
+	}
+
+	return m.t.intsetToBitmap(ids), nil
+}
+
+// Same semantics as evalPredicateGroups
+func (m *MemoryNamespace) evalPredicateGroups(groups [][]NsPredicate) (bitmap, error) {
+	result := m.t.newBitmap()
+
+	for _, group := range groups {
+		groupSet := m.t.newBitmap()
+		if len(group) == 0 {
+			groupSet.or(m.all)
+		} else {
+			groupSet.fill(len(m.t.codes))
+		}
+
+		for _, p := range group {
+			s, err := m.predicateToBitmap(p)
+			if err != nil {
+				return nil, err
+			}
+
+			groupSet.and(s)
+		}
+
+		result.or(groupSet)
+	}
+
+	return result, nil
+}
+
+func (m *MemoryNamespace) Filter(f *NsFilter) ([]VsExpansionContains, error) {
+	// only English displays are loaded
+	language := strings.ToLower(f.DisplayLanguage)
+	if language != "" && !strings.HasPrefix(language, "en") {
+		return m.ns.Filter(f)
+	}
+
+	included, err := m.evalPredicateGroups(f.Include)
+	if err != nil {
+		return nil, err
+	}
+
+	excluded, err := m.evalPredicateGroups(f.Exclude)
+	if err != nil {
+		return nil, err
+	}
+
+	included.andNot(excluded)
+
+	system := m.ns.Url()
+	result := make([]VsExpansionContains, 0, included.count())
+	included.each(func(i int32) {
+		result = append(result, VsExpansionContains{
+			System:  system,
+			Code:    m.t.codes[i],
+			Display: m.t.displays[i],
+		})
+	})
+
+	return filterContainsByText(result, f.Text), nil
+}
+
+type memoryEclEvaluator struct {
+	eclEvaluator
+	m *MemoryNamespace
+}
+
+func (e memoryEclEvaluator) allConcepts() (*Intset, error) {
+	return e.m.t.bitmapToIntset(e.m.all), nil
+}
+
+func (e memoryEclEvaluator) descendants(id int64) ([]int64, error) {
+	i, found := e.m.t.idIndex[id]
+	if !found {
+		return []int64{}, nil
+	}
+
+	result := make([]int64, 0, len(e.m.t.descendants[i]))
+	for _, d := range e.m.t.descendants[i] {
+		result = append(result, e.m.t.ids[d])
+	}
+
+	return result, nil
+}
+
+// Returns namespace serving data from database, memory namespaces
+// are unwrapped
+func unwrapNamespace(ns Namespace) Namespace {
+	if m, ok := ns.(*MemoryNamespace); ok {
+		return m.ns
+	}
+
+	return ns
+}
+
+// Replaces every registered release of listed code systems with
+// MemoryNamespace, loading takes a while for SNOMED-CT
+func (r *Registry) LoadIntoMemory(urls []string) error {
+	for _, url := range urls {
+		registered, found := r.namespaces[normalizeNsUrl(url)]
+		if !found {
+			return fmt.Errorf("code system %s is not served by any database", url)
+		}
+
+		for i, v := range registered {
+			loadable, ok := v.ns.(memoryLoadable)
+			if !ok {
+				return fmt.Errorf("code system %s can't be loaded into memory", url)
+			}
+
+			start := time.Now()
+			m, err := newMemoryNamespace(loadable)
+			if err != nil {
+				return fmt.Errorf("Failed to load %s into memory: %s", url, err)
+			}
+
+			registered[i].ns = m
+			log.Printf("%s version '%s' is loaded into memory (%d concepts) in %s",
+				url, v.version, len(m.t.codes), time.Since(start))
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,102 @@
+package fhirterm
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// Hierarchy: P (part, not a concept) -> A -> B, A -> C, P -> C.
+// D is not included into empty filter group, like LOINC answers.
+type fakeLoadableNamespace struct {
+	fakeNamespace
+}
+
+func (ns fakeLoadableNamespace) Lookup(code string, displayLanguage string) (*NsConcept, error) {
+	return &NsConcept{System: ns.url, Code: code, Designations: []NsDesignation{{Value: "Synonym of " + code}}}, nil
+}
+
+func (ns fakeLoadableNamespace) ImplicitValueSet(identifier string) (*ValueSet, bool) {
+	return nil, false
+}
+
+func (ns fakeLoadableNamespace) allConcepts() (*Intset, error) {
+	return NewIntsetFromSlice([]int64{1, 2, 3}), nil
+}
+
+func (ns fakeLoadableNamespace) predicateToIntset(p NsPredicate) (*Intset, error) {
+	if p.Property == "STATUS" {
+		return NewIntsetFromSlice([]int64{2, 42}), nil
+	}
+
+	return nil, unsupportedPredicateError("fake", p)
+}
+
+func (ns fakeLoadableNamespace) loadMemoryTerminology() (*memoryTerminology, error) {
+	t := newMemoryTerminology()
+	t.addConcept(1, "A", "Alpha")
+	t.addConcept(2, "B", "Beta")
+	t.addConcept(3, "C", "Gamma")
+	t.addConcept(-1, "D", "Delta")
+
+	p := t.addNode("P")
+	t.setHierarchy([][2]int32{{p, 0}, {0, 1}, {0, 2}, {p, 2}})
+
+	return t, nil
+}
+
+func Test_MemoryNamespace(t *testing.T) {
+	assert := assert.New(t)
+	url := "http://example.org/memory"
+
+	s := &Server{Registry: NewRegistry()}
+	s.Registry.RegisterNamespace(fakeLoadableNamespace{fakeNamespace{url, ""}})
+	assert.EqualError(s.Registry.LoadIntoMemory([]string{"http://example.org/unknown"}),
+		"code system http://example.org/unknown is not served by any database")
+	assert.Nil(s.Registry.LoadIntoMemory([]string{url}))
+
+	ns, _ := s.Registry.FindNamespace(url)
+	codes := func(f *NsFilter) []string {
+		contains, err := ns.Filter(f)
+		assert.Nil(err)
+
+		result := make([]string, 0)
+		for _, c := range contains {
+			result = append(result, c.Code)
+		}
+
+		return result
+	}
+
+	is := func(op string, value string) []NsPredicate {
+		return []NsPredicate{{Property: "concept", Op: op, Value: value}}
+	}
+
+	assert.Equal([]string{"A", "B", "C"}, codes(&NsFilter{Include: [][]NsPredicate{is("is-a", "P")}}),
+		"part itself is not a concept")
+	assert.Equal([]string{"A", "B", "C"}, codes(&NsFilter{Include: [][]NsPredicate{is("is-a", "A")}}))
+	assert.Equal([]string{"B", "C"}, codes(&NsFilter{Include: [][]NsPredicate{is("descendent-of", "A")}}))
+	assert.Equal([]string{"A", "C"}, codes(&NsFilter{
+		Include: [][]NsPredicate{is("in", "A, C,X")},
+	}))
+	assert.Equal([]string{"C"}, codes(&NsFilter{
+		Include: [][]NsPredicate{is("is-a", "A")},
+		Exclude: [][]NsPredicate{is("=", "A"), {{Property: "STATUS", Op: "=", Value: "x"}}},
+	}), "unsupported predicates are evaluated by wrapped namespace")
+	assert.Equal([]string{"B"}, codes(&NsFilter{Include: [][]NsPredicate{nil}, Text: "et"}))
+	assert.Equal([]string{"D"}, codes(&NsFilter{Include: [][]NsPredicate{is("=", "D")}}))
+
+	_, err := ns.Filter(&NsFilter{Include: [][]NsPredicate{{{Property: "foo", Op: "=", Value: "x"}}}})
+	assert.EqualError(err, "fake does not support filter with property 'foo' and op '='")
+
+	result, err := s.ValidateCode(url, "B", "")
+	assert.Nil(err)
+	assert.Equal(validationResult(true, "", "Beta"), result)
+
+	result, err = s.ValidateCode(url, "P", "")
+	assert.Nil(err)
+	assert.Equal(validationResult(false, "Unknown code 'P' in code system "+url, ""), result)
+
+	result, err = s.ValidateCode(url, "B", "synonym of b")
+	assert.Nil(err)
+	assert.Equal(validationResult(true, "", ""), result, "designations are looked up by wrapped namespace")
+}
@@ -0,0 +1,412 @@
+package fhirterm
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+)
+
+type Namespace interface {
+	Url() string
+	Filter(filter *NsFilter) ([]VsExpansionContains, error)
+}
+
+// Namespaces which identify concepts by int64 ids implement this
+// interface to evaluate NsFilter with Intset operations
+type intsetNamespace interface {
+	allConcepts() (*Intset, error)
+	predicateToIntset(p NsPredicate) (*Intset, error)
+}
+
+type versionedNamespace struct {
+	ns      Namespace
+	version string
+}
+
+// Namespaces served by Server. Several releases of code system can
+// be registered (each one is loaded from its own database), first
+// registered is default.
+type Registry struct {
+	namespaces map[string][]versionedNamespace
+}
+
+// UCUM needs no database, so it's always registered
+func NewRegistry() *Registry {
+	r := &Registry{namespaces: make(map[string][]versionedNamespace)}
+	r.RegisterNamespace(NewUcumNamespace())
+
+	return r
+}
+
+func (r *Registry) RegisterNamespace(ns Namespace) {
+	r.RegisterNamespaceVersion(ns, "")
+}
+
+// Registers release of code system, namespace registered earlier
+// with the same version is replaced
+func (r *Registry) RegisterNamespaceVersion(ns Namespace, version string) {
+	url := normalizeNsUrl(ns.Url())
+
+	for i, v := range r.namespaces[url] {
+		if v.version == version {
+			r.namespaces[url][i].ns = ns
+			return
+		}
+	}
+
+	r.namespaces[url] = append(r.namespaces[url], versionedNamespace{ns, version})
+}
+
+func (r *Registry) FindNamespace(url string) (Namespace, bool) {
+	registered, found := r.namespaces[normalizeNsUrl(url)]
+	if !found {
+		return nil, false
+	}
+
+	return registered[0].ns, true
+}
+
+// Returns namespace serving specified release of code system and
+// its version, empty version means default release
+func (r *Registry) FindNamespaceVersion(url string, version string) (Namespace, string, error) {
+	registered, found := r.namespaces[normalizeNsUrl(url)]
+	if !found {
+		return nil, "", fmt.Errorf("unknown code system: %s", url)
+	}
+
+	if version == "" {
+		return registered[0].ns, registered[0].version, nil
+	}
+
+	available := make([]string, 0, len(registered))
+	for _, v := range registered {
+		if v.version == version {
+			return v.ns, v.version, nil
+		}
+
+		if v.version != "" {
+			available = append(available, v.version)
+		}
+	}
+
+	return nil, "", fmt.Errorf("version %s of code system %s is not available (available versions: %s)",
+		version, url, strings.Join(available, ", "))
+}
+
+// Namespaces with concept hierarchy implement this interface, it's
+// used by $closure. Code itself is not included into its ancestors,
+// unknown code has no ancestors.
+type HierarchyNamespace interface {
+	AncestorCodes(code string) ([]string, error)
+}
+
+// Namespaces which define implicit value sets (like
+// http://snomed.info/sct?fhir_vs=isa/X) implement this interface
+type ImplicitValueSetProvider interface {
+	ImplicitValueSet(identifier string) (*ValueSet, bool)
+}
+
+func (r *Registry) FindImplicitValueSet(identifier string) (*ValueSet, bool) {
+	for _, registered := range r.namespaces {
+		if p, ok := registered[0].ns.(ImplicitValueSetProvider); ok {
+			if vs, found := p.ImplicitValueSet(identifier); found {
+				return vs, true
+			}
+		}
+	}
+
+	return nil, false
+}
+
+// Returns unescaped value of fhir_vs parameter if identifier is
+// implicit value set URL of specified system
+func implicitValueSetParam(identifier string, system string) (string, bool) {
+	parts := strings.SplitN(identifier, "?", 2)
+	if len(parts) != 2 || normalizeNsUrl(parts[0]) != normalizeNsUrl(system) {
+		return "", false
+	}
+
+	if parts[1] == "fhir_vs" {
+		return "", true
+	}
+
+	if !strings.HasPrefix(parts[1], "fhir_vs=") {
+		return "", false
+	}
+
+	value, err := url.PathUnescape(strings.TrimPrefix(parts[1], "fhir_vs="))
+	if err != nil {
+		return "", false
+	}
+
+	return value, true
+}
+
+func makeImplicitValueSet(identifier string, include VsComposeInclude) *ValueSet {
+	return &ValueSet{
+		ResourceType: "ValueSet",
+		Identifier:   identifier,
+		Name:         identifier,
+		Compose: &VsCompose{
+			Include: []VsComposeInclude{include},
+		},
+	}
+}
+
+// Returns built-in namespaces which have data in database
+func builtinNamespaces(db *DB) ([]Namespace, error) {
+	result := make([]Namespace, 0)
+
+	tables := []struct {
+		table string
+		ns    Namespace
+	}{
+		{"snomed_concepts_no_history", NewSnomedNamespace(db)},
+		{"loinc_loincs", NewLoincNamespace(db)},
+		{"rxnorm_concepts", NewRxnormNamespace(db)},
+	}
+
+	for _, t := range tables {
+		exists, err := TableExists(db, t.table)
+		if err != nil {
+			return nil, err
+		} else if exists {
+			result = append(result, t.ns)
+		}
+	}
+
+	exists, err := TableExists(db, "icd10_concepts")
+	if err != nil || !exists {
+		return result, err
+	}
+
+	for _, system := range []string{Icd10Url, Icd10CmUrl} {
+		var loaded bool
+		err = db.QueryRow("SELECT EXISTS (SELECT 1 FROM icd10_concepts WHERE system = ?)", system).
+			Scan(&loaded)
+		if err != nil {
+			return nil, err
+		} else if loaded {
+			result = append(result, NewIcd10Namespace(db, system))
+		}
+	}
+
+	return result, nil
+}
+
+// Registers namespaces of code systems loaded into database with
+// versions of releases recorded in terminology_versions table.
+// Release already registered from another database is skipped.
+func (r *Registry) InitNamespaces(db *DB, source string) error {
+	versions, err := ReadTerminologyVersions(db)
+	if err != nil {
+		return err
+	}
+
+	builtin, err := builtinNamespaces(db)
+	if err != nil {
+		return err
+	}
+
+	custom, err := customNamespaces(db)
+	if err != nil {
+		return err
+	}
+
+	register := func(ns Namespace, version string) {
+		if _, _, err := r.FindNamespaceVersion(ns.Url(), version); err == nil {
+			log.Printf("%s version '%s' from %s is skipped, it's already registered", ns.Url(), version, source)
+			return
+		}
+
+		r.RegisterNamespaceVersion(ns, version)
+		log.Printf("%s version '%s' is served from %s", ns.Url(), version, source)
+	}
+
+	for _, ns := range builtin {
+		register(ns, versions[ns.Url()].Version)
+	}
+
+	for _, ns := range custom {
+		register(ns, versions[ns.system].Version)
+	}
+
+	return nil
+}
+
+// Predicates inside an include (or exclude) group are intersected,
+// groups are united. Empty group means whole namespace.
+func evalPredicateGroups(groups [][]NsPredicate, ns intsetNamespace) (*Intset, error) {
+	result := NewIntset()
+
+	for _, group := range groups {
+		var groupSet *Intset
+		var err error
+
+		if len(group) == 0 {
+			groupSet, err = ns.allConcepts()
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		for _, p := range group {
+			s, err := ns.predicateToIntset(p)
+			if err != nil {
+				return nil, err
+			}
+
+			if groupSet == nil {
+				groupSet = s
+			} else {
+				groupSet = groupSet.Intersect(s)
+			}
+		}
+
+		result.AddSet(groupSet)
+	}
+
+	return result, nil
+}
+
+func evalNsFilter(f *NsFilter, ns intsetNamespace) (*Intset, error) {
+	included, err := evalPredicateGroups(f.Include, ns)
+	if err != nil {
+		return nil, err
+	}
+
+	excluded, err := evalPredicateGroups(f.Exclude, ns)
+	if err != nil {
+		return nil, err
+	}
+
+	return included.Difference(excluded), nil
+}
+
+func unsupportedPredicateError(ns string, p NsPredicate) error {
+	return fmt.Errorf("%s does not support filter with property '%s' and op '%s'",
+		ns, p.Property, p.Op)
+}
+
+func blobToInt64Slice(blob []byte) ([]int64, error) {
+	result := make([]int64, len(blob)/8)
+	err := binary.Read(bytes.NewReader(blob), binary.LittleEndian, result)
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func sqlPlaceholders(n int) string {
+	return strings.TrimRight(strings.Repeat("?,", n), ",")
+}
+
+// Maximal number of bound parameters per query, older SQLite
+// versions limit it to 999
+const sqlChunkSize = 500
+
+// Executes query for every chunk of ids, query should contain
+// single %s which is replaced with placeholders for chunk values
+func queryByIdChunks(db *DB, query string, ids []int64, prefixArgs []interface{}, rowFn func(rows *sql.Rows) error) error {
+	for start := 0; start < len(ids); start += sqlChunkSize {
+		end := start + sqlChunkSize
+		if end > len(ids) {
+			end = len(ids)
+		}
+
+		chunk := ids[start:end]
+		args := make([]interface{}, 0, len(prefixArgs)+len(chunk))
+		args = append(args, prefixArgs...)
+		for _, id := range chunk {
+			args = append(args, id)
+		}
+
+		// statement of last partial chunk is unlikely to be reused,
+		// so it's not cached
+		q := fmt.Sprintf(query, sqlPlaceholders(len(chunk)))
+		var rows *sql.Rows
+		var err error
+		if len(chunk) == sqlChunkSize {
+			rows, err = db.Query(q, args...)
+		} else {
+			rows, err = db.DB.Query(q, args...)
+		}
+		if err != nil {
+			return err
+		}
+
+		for rows.Next() {
+			err = rowFn(rows)
+			if err != nil {
+				rows.Close()
+				return err
+			}
+		}
+
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func rowsToIntset(rows *sql.Rows) (*Intset, error) {
+	result := NewIntset()
+
+	for rows.Next() {
+		var i int64
+		err := rows.Scan(&i)
+		if err != nil {
+			return nil, err
+		}
+
+		result.Add(i)
+	}
+
+	return result, rows.Err()
+}
+
+func queryIntset(db *DB, query string, args ...interface{}) (*Intset, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return rowsToIntset(rows)
+}
+
+func queryStrings(db *DB, query string, args ...interface{}) ([]string, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make([]string, 0)
+	for rows.Next() {
+		var s string
+		err = rows.Scan(&s)
+		if err != nil {
+			return nil, err
+		}
+
+		result = append(result, s)
+	}
+
+	return result, rows.Err()
+}
+
+type containsByCode []VsExpansionContains
+
+func (s containsByCode) Len() int           { return len(s) }
+func (s containsByCode) Less(i, j int) bool { return s[i].Code < s[j].Code }
+func (s containsByCode) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
@@ -0,0 +1,122 @@
+package fhirterm
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+type fakeIntsetNamespace struct{}
+
+func (ns fakeIntsetNamespace) allConcepts() (*Intset, error) {
+	return NewIntsetFromSlice([]int64{1, 2, 3, 4, 5, 6, 7, 8, 9}), nil
+}
+
+func (ns fakeIntsetNamespace) predicateToIntset(p NsPredicate) (*Intset, error) {
+	switch p.Value {
+	case "odd":
+		return NewIntsetFromSlice([]int64{1, 3, 5, 7, 9}), nil
+	case "small":
+		return NewIntsetFromSlice([]int64{1, 2, 3, 4}), nil
+	case "three":
+		return NewIntsetFromSlice([]int64{3}), nil
+	}
+
+	return nil, fmt.Errorf("unknown value %s", p.Value)
+}
+
+func Test_EvalNsFilter(t *testing.T) {
+	assert := assert.New(t)
+	ns := fakeIntsetNamespace{}
+
+	r, err := evalNsFilter(&NsFilter{
+		Include: [][]NsPredicate{
+			[]NsPredicate{NsPredicate{Value: "odd"}, NsPredicate{Value: "small"}},
+			[]NsPredicate{NsPredicate{Value: "three"}},
+		},
+	}, ns)
+
+	assert.Nil(err)
+	assert.True(r.Equal(NewIntsetFromSlice([]int64{1, 3})),
+		"Predicates in group are intersected and groups are united")
+
+	r, err = evalNsFilter(&NsFilter{
+		Include: [][]NsPredicate{[]NsPredicate{}},
+		Exclude: [][]NsPredicate{
+			[]NsPredicate{NsPredicate{Value: "odd"}},
+			[]NsPredicate{NsPredicate{Value: "small"}},
+		},
+	}, ns)
+
+	assert.Nil(err)
+	assert.True(r.Equal(NewIntsetFromSlice([]int64{6, 8})),
+		"Empty include group means whole namespace")
+
+	_, err = evalNsFilter(&NsFilter{
+		Include: [][]NsPredicate{[]NsPredicate{NsPredicate{Value: "foo"}}},
+	}, ns)
+
+	assert.NotNil(err)
+}
+
+func Test_ImplicitValueSetParam(t *testing.T) {
+	assert := assert.New(t)
+
+	p, found := implicitValueSetParam("http://snomed.info/sct?fhir_vs=ecl/%3C%3C%2073211009", SnomedUrl)
+	assert.True(found)
+	assert.Equal("ecl/<< 73211009", p)
+
+	p, found = implicitValueSetParam("http://snomed.info/sct/?fhir_vs", SnomedUrl)
+	assert.True(found)
+	assert.Equal("", p)
+
+	_, found = implicitValueSetParam("http://loinc.org?fhir_vs=isa/123", SnomedUrl)
+	assert.False(found)
+
+	_, found = implicitValueSetParam("http://snomed.info/sct", SnomedUrl)
+	assert.False(found)
+}
+
+type fakeNamespace struct {
+	url     string
+	display string
+}
+
+func (ns fakeNamespace) Url() string {
+	return ns.url
+}
+
+func (ns fakeNamespace) Filter(f *NsFilter) ([]VsExpansionContains, error) {
+	return []VsExpansionContains{
+		VsExpansionContains{System: ns.url, Code: "1", Display: ns.display},
+	}, nil
+}
+
+func Test_NamespaceVersions(t *testing.T) {
+	assert := assert.New(t)
+	url := "http://example.org/versioned"
+	r := NewRegistry()
+
+	r.RegisterNamespaceVersion(fakeNamespace{url, "first"}, "1.0")
+	r.RegisterNamespaceVersion(fakeNamespace{url + "/", "second"}, "2.0")
+
+	ns, found := r.FindNamespace(url)
+	assert.True(found)
+	assert.Equal("first", ns.(fakeNamespace).display, "First registered release is default")
+
+	ns, version, err := r.FindNamespaceVersion(url, "")
+	assert.Nil(err)
+	assert.Equal("1.0", version)
+
+	ns, version, err = r.FindNamespaceVersion(url+"/", "2.0")
+	assert.Nil(err)
+	assert.Equal("2.0", version)
+	assert.Equal("second", ns.(fakeNamespace).display)
+
+	_, _, err = r.FindNamespaceVersion(url, "3.0")
+	assert.EqualError(err, "version 3.0 of code system http://example.org/versioned is not available (available versions: 1.0, 2.0)")
+
+	r.RegisterNamespaceVersion(fakeNamespace{url, "replaced"}, "1.0")
+	ns, _, _ = r.FindNamespaceVersion(url, "1.0")
+	assert.Equal("replaced", ns.(fakeNamespace).display, "Release with the same version is replaced")
+}
@@ -63,6 +63,70 @@ func (this RestStorage) FindValueSetById(id string) (*ValueSet, error) {
 	return &vs, nil
 }
 
+// First value set found by identifier search parameter is returned
+func (this RestStorage) FindValueSetByIdentifier(identifier string) (*ValueSet, error) {
+	body, err := this.request(
+		"GET",
+		"/ValueSet",
+		HttpParams{"identifier": identifier})
+
+	if err != nil {
+		return nil, err
+	}
+
+	var bundle struct {
+		Entry []struct {
+			Resource *ValueSet `json:"resource"`
+		} `json:"entry"`
+	}
+
+	err = json.Unmarshal(body, &bundle)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, e := range bundle.Entry {
+		if e.Resource != nil {
+			return e.Resource, nil
+		}
+	}
+
+	return nil, fmt.Errorf("unknown value set: %s", identifier)
+}
+
+// Searches by source-system and source-code parameters, every
+// entry of returned Bundle is expected to be ConceptMap
+func (this RestStorage) FindConceptMaps(system string, code string) ([]*ConceptMap, error) {
+	body, err := this.request(
+		"GET",
+		"/ConceptMap",
+		HttpParams{"source-system": system, "source-code": code})
+
+	if err != nil {
+		return nil, err
+	}
+
+	var bundle struct {
+		Entry []struct {
+			Resource *ConceptMap `json:"resource"`
+		} `json:"entry"`
+	}
+
+	err = json.Unmarshal(body, &bundle)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]*ConceptMap, 0, len(bundle.Entry))
+	for _, e := range bundle.Entry {
+		if e.Resource != nil {
+			result = append(result, e.Resource)
+		}
+	}
+
+	return result, nil
+}
+
 func MakeRestStorage(cfg JsonObject) (Storage, error) {
 	baseUrl, ok := cfg["base_url"].(string)
 	if !ok {
@@ -0,0 +1,229 @@
+package fhirterm
+
+import (
+	"database/sql"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const RxnormUrl = "http://www.nlm.nih.gov/research/umls/rxnorm"
+
+// Relationships followed from ingredient to every drug concept
+// containing it: SCDC and SCDF have ingredient, SCD consists of SCDC,
+// SBD is tradename of SCD and so on
+var rxnormContainsRelas = []string{"has_ingredient", "consists_of", "isa", "tradename_of", "contains"}
+
+type RxnormNamespace struct {
+	db *DB
+}
+
+func NewRxnormNamespace(db *DB) *RxnormNamespace {
+	return &RxnormNamespace{db: db}
+}
+
+func (ns *RxnormNamespace) Url() string {
+	return RxnormUrl
+}
+
+func (ns *RxnormNamespace) Filter(f *NsFilter) ([]VsExpansionContains, error) {
+	ids, err := evalNsFilter(f, ns)
+	if err != nil {
+		return nil, err
+	}
+
+	return ns.idsToContains(ids, f.Text)
+}
+
+func (ns *RxnormNamespace) Lookup(code string, displayLanguage string) (*NsConcept, error) {
+	rxcui, err := strconv.ParseInt(code, 10, 64)
+	if err != nil {
+		return nil, nil
+	}
+
+	var display string
+	err = ns.db.QueryRow("SELECT str FROM rxnorm_concepts WHERE rxcui = ?", rxcui).Scan(&display)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	concept := &NsConcept{System: RxnormUrl, Code: code, Display: display}
+
+	rows, err := ns.db.Query("SELECT DISTINCT str FROM rxnorm_conso WHERE rxcui = ?", rxcui)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		d := NsDesignation{Language: "en"}
+		err = rows.Scan(&d.Value)
+		if err != nil {
+			return nil, err
+		}
+
+		concept.Designations = append(concept.Designations, d)
+	}
+
+	return concept, rows.Err()
+}
+
+func (ns *RxnormNamespace) allConcepts() (*Intset, error) {
+	return queryIntset(ns.db, "SELECT rxcui FROM rxnorm_concepts")
+}
+
+// Concepts X for which "X rela target" holds for any of targets
+func (ns *RxnormNamespace) relatedSources(relas []string, targets *Intset) (*Intset, error) {
+	result := NewIntset()
+	args := make([]interface{}, 0, len(relas))
+	for _, r := range relas {
+		args = append(args, r)
+	}
+
+	query := `SELECT source_rxcui FROM rxnorm_relationships
+            WHERE rela IN (` + sqlPlaceholders(len(relas)) + `) AND target_rxcui IN (%s)`
+
+	err := queryByIdChunks(ns.db, query, targets.ToInt64Slice(), args,
+		func(rows *sql.Rows) error {
+			var id int64
+			err := rows.Scan(&id)
+			result.Add(id)
+			return err
+		})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func (ns *RxnormNamespace) containingIngredients(ingredients *Intset) (*Intset, error) {
+	result := NewIntset()
+	frontier := ingredients
+
+	for frontier.Len() > 0 {
+		related, err := ns.relatedSources(rxnormContainsRelas, frontier)
+		if err != nil {
+			return nil, err
+		}
+
+		frontier = related.Difference(result)
+		result.AddSet(frontier)
+	}
+
+	return result, nil
+}
+
+func (ns *RxnormNamespace) predicateToIntset(p NsPredicate) (*Intset, error) {
+	if p.Op != "=" && p.Op != "in" {
+		return nil, unsupportedPredicateError("RxNorm", p)
+	}
+
+	values := strings.Split(p.Value, ",")
+	if p.Op == "in" && p.Concepts != nil {
+		values = make([]string, 0, len(p.Concepts))
+		for _, c := range p.Concepts {
+			values = append(values, c.Code)
+		}
+	}
+
+	for i, v := range values {
+		values[i] = strings.TrimSpace(v)
+	}
+
+	switch p.Property {
+	case "concept":
+		return parseRxcuis(values), nil
+	case "TTY":
+		result := NewIntset()
+		for _, tty := range values {
+			ids, err := queryIntset(ns.db, "SELECT rxcui FROM rxnorm_concepts WHERE tty = ?", tty)
+			if err != nil {
+				return nil, err
+			}
+
+			result.AddSet(ids)
+		}
+
+		return result, nil
+	case "ingredient":
+		return ns.containingIngredients(parseRxcuis(values))
+	}
+
+	// any other property is either relationship name (has_ingredient,
+	// tradename_of, ...) or RXNSAT attribute name
+	var isRela bool
+	err := ns.db.QueryRow("SELECT EXISTS (SELECT 1 FROM rxnorm_relationships WHERE rela = ?)", p.Property).
+		Scan(&isRela)
+	if err != nil {
+		return nil, err
+	}
+
+	if isRela {
+		return ns.relatedSources([]string{p.Property}, parseRxcuis(values))
+	}
+
+	result := NewIntset()
+	for _, v := range values {
+		ids, err := queryIntset(ns.db, "SELECT rxcui FROM rxnorm_attributes WHERE atn = ? AND atv = ?", p.Property, v)
+		if err != nil {
+			return nil, err
+		}
+
+		result.AddSet(ids)
+	}
+
+	return result, nil
+}
+
+func (ns *RxnormNamespace) idsToContains(ids *Intset, text string) ([]VsExpansionContains, error) {
+	sorted := ids.ToInt64Slice()
+	sort.Sort(int64Slice(sorted))
+
+	displays := make(map[int64]string, len(sorted))
+	err := queryByIdChunks(ns.db,
+		"SELECT rxcui, str FROM rxnorm_concepts WHERE rxcui IN (%s)",
+		sorted, nil,
+		func(rows *sql.Rows) error {
+			var id int64
+			var str string
+			err := rows.Scan(&id, &str)
+			displays[id] = str
+			return err
+		})
+
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]VsExpansionContains, 0, len(sorted))
+	for _, id := range sorted {
+		display, found := displays[id]
+		if !found {
+			continue
+		}
+
+		result = append(result, VsExpansionContains{
+			System:  RxnormUrl,
+			Code:    strconv.FormatInt(id, 10),
+			Display: display,
+		})
+	}
+
+	return filterContainsByText(result, text), nil
+}
+
+func parseRxcuis(codes []string) *Intset {
+	result := NewIntset()
+
+	for _, c := range codes {
+		if id, err := strconv.ParseInt(c, 10, 64); err == nil {
+			result.Add(id)
+		}
+	}
+
+	return result
+}
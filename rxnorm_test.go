@@ -0,0 +1,94 @@
+package fhirterm
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// Metformin (6809) is ingredient of SCDC 316255, SCD 861007 consists
+// of it and SBD 151827 is its tradename. Ibuprofen (5640) is
+// ingredient of SCDC 316074 only.
+var rxnormStmts = []string{
+	"CREATE TABLE rxnorm_concepts (rxcui bigint NOT NULL PRIMARY KEY, tty text, str text)",
+	`INSERT INTO rxnorm_concepts VALUES (6809, 'IN', 'metformin'), (5640, 'IN', 'ibuprofen'),
+   (316255, 'SCDC', 'metformin hydrochloride 500 MG'), (316074, 'SCDC', 'ibuprofen 200 MG'),
+   (861007, 'SCD', 'metformin hydrochloride 500 MG Oral Tablet'),
+   (151827, 'SBD', 'metformin hydrochloride 500 MG Oral Tablet [Glucophage]')`,
+	"CREATE TABLE rxnorm_conso (rxcui bigint, rxaui text, tty text, code text, str text, suppress text)",
+	`INSERT INTO rxnorm_conso VALUES
+   (861007, 'A1', 'SCD', '861007', 'metformin hydrochloride 500 MG Oral Tablet', 'N'),
+   (861007, 'A2', 'SY', '861007', 'Metformin HCl 500 MG Oral Tablet', 'N'),
+   (861007, 'A3', 'PSN', '861007', 'Metformin HCl 500 MG Oral Tablet', 'N')`,
+	"CREATE TABLE rxnorm_relationships (source_rxcui bigint, rel text, rela text, target_rxcui bigint)",
+	`INSERT INTO rxnorm_relationships VALUES (316255, 'RO', 'has_ingredient', 6809),
+   (316074, 'RO', 'has_ingredient', 5640), (861007, 'RO', 'consists_of', 316255),
+   (151827, 'RB', 'tradename_of', 861007)`,
+	"CREATE TABLE rxnorm_attributes (rxcui bigint, atn text, atv text)",
+	"INSERT INTO rxnorm_attributes VALUES (861007, 'NDC', '00093104801'), (151827, 'NDC', '00087606010')",
+}
+
+func Test_RxnormFilter(t *testing.T) {
+	assert := assert.New(t)
+	db, closeDb := openTestDb(t, rxnormStmts...)
+	defer closeDb()
+
+	ns := NewRxnormNamespace(db)
+
+	tests := []struct {
+		include NsPredicate
+		exclude []NsPredicate
+		codes   []string
+	}{
+		{NsPredicate{}, nil, []string{"5640", "6809", "151827", "316074", "316255", "861007"}},
+		{NsPredicate{Property: "concept", Op: "=", Value: "6809"}, nil, []string{"6809"}},
+		{NsPredicate{Property: "concept", Op: "=", Value: "1"}, nil, []string{}},
+		{NsPredicate{Property: "concept", Op: "in", Value: "6809, 5640,abc"}, nil, []string{"5640", "6809"}},
+		{NsPredicate{Property: "concept", Op: "in", Concepts: []VsComposeIncludeConcept{{Code: "861007"}}}, nil,
+			[]string{"861007"}},
+		{NsPredicate{Property: "TTY", Op: "=", Value: "IN"}, nil, []string{"5640", "6809"}},
+		{NsPredicate{Property: "TTY", Op: "in", Value: "SCD, SBD"}, nil, []string{"151827", "861007"}},
+		{NsPredicate{Property: "ingredient", Op: "=", Value: "6809"}, nil, []string{"151827", "316255", "861007"}},
+		{NsPredicate{Property: "ingredient", Op: "in", Value: "6809,5640"}, nil,
+			[]string{"151827", "316074", "316255", "861007"}},
+		{NsPredicate{Property: "has_ingredient", Op: "=", Value: "5640"}, nil, []string{"316074"}},
+		{NsPredicate{Property: "tradename_of", Op: "=", Value: "861007"}, nil, []string{"151827"}},
+		{NsPredicate{Property: "NDC", Op: "=", Value: "00093104801"}, nil, []string{"861007"}},
+		{NsPredicate{Property: "NDC", Op: "in", Value: "00093104801,00087606010"}, nil, []string{"151827", "861007"}},
+		{NsPredicate{Property: "TTY", Op: "=", Value: "IN"},
+			[]NsPredicate{{Property: "concept", Op: "=", Value: "5640"}}, []string{"6809"}},
+	}
+
+	for _, test := range tests {
+		codes, err := filterCodes(ns, test.include, test.exclude...)
+		assert.Nil(err)
+		assert.Equal(test.codes, codes, "%+v", test.include)
+	}
+
+	_, err := filterCodes(ns, NsPredicate{Property: "concept", Op: "is-a", Value: "6809"})
+	assert.EqualError(err, "RxNorm does not support filter with property 'concept' and op 'is-a'")
+
+	contains, err := ns.Filter(&NsFilter{Include: [][]NsPredicate{{{Property: "TTY", Op: "=", Value: "SCD"}}}})
+	assert.Nil(err)
+	assert.Equal([]VsExpansionContains{{System: RxnormUrl, Code: "861007",
+		Display: "metformin hydrochloride 500 MG Oral Tablet"}}, contains)
+}
+
+func Test_RxnormLookup(t *testing.T) {
+	assert := assert.New(t)
+	db, closeDb := openTestDb(t, rxnormStmts...)
+	defer closeDb()
+
+	ns := NewRxnormNamespace(db)
+
+	concept, err := ns.Lookup("861007", "")
+	assert.Nil(err)
+	assert.Equal("metformin hydrochloride 500 MG Oral Tablet", concept.Display)
+	assert.ElementsMatch([]NsDesignation{{"en", "metformin hydrochloride 500 MG Oral Tablet"},
+		{"en", "Metformin HCl 500 MG Oral Tablet"}}, concept.Designations)
+
+	for _, code := range []string{"1", "abc", ""} {
+		concept, err = ns.Lookup(code, "")
+		assert.Nil(err)
+		assert.Nil(concept, code)
+	}
+}
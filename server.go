@@ -1,15 +1,71 @@
 package fhirterm
 
 import (
+	"encoding/json"
 	"fmt"
 	"github.com/codegangsta/negroni"
 	"github.com/julienschmidt/httprouter"
 	"github.com/rs/cors"
+	"io"
+	"io/ioutil"
 	"log"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 )
 
+// Terminology server holding databases, value set storage and
+// registry of namespaces. Server built with NewServer owns its
+// databases, fields can also be filled directly (for example with
+// fake Storage or namespaces in tests).
+type Server struct {
+	Config    *Config
+	Databases []Database
+	Storage   Storage
+	Registry  *Registry
+	Closures  ClosureTables
+}
+
+// Opens databases listed in config, registers namespaces of code
+// systems loaded into them and creates value set storage
+func NewServer(cfg *Config) (*Server, error) {
+	dbs, err := OpenDatabases(cfg.Databases, cfg.Sqlite)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Server{Config: cfg, Databases: dbs, Registry: NewRegistry()}
+
+	// when several databases contain the same code system, database
+	// listed first in config serves default release
+	for _, d := range dbs {
+		err = s.Registry.InitNamespaces(d.Db, d.Name())
+		if err != nil {
+			s.Close()
+			return nil, fmt.Errorf("Error initializing namespaces of %s: %s", d.Name(), err)
+		}
+	}
+
+	err = s.Registry.LoadIntoMemory(cfg.InMemory)
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+
+	s.Storage, err = makeStorage(cfg.Storage)
+	if err != nil {
+		s.Close()
+		return nil, fmt.Errorf("Error initializing Storage: %s", err)
+	}
+
+	return s, nil
+}
+
+func (s *Server) Close() error {
+	return CloseDatabases(s.Databases)
+}
+
 func Index(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 	page := `
 <!DOCTYPE html>
@@ -36,16 +92,389 @@ working. Further configuration is required. Normally, this page should not be pu
 	fmt.Fprint(w, page)
 }
 
-func ValueSetExpand(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	db := GetDb()
-	row := db.QueryRow("SELECT long_common_name FROM loinc_loincs LIMIT 1")
-	var display string
-	row.Scan(&display)
+func writeJson(w http.ResponseWriter, status int, obj interface{}) {
+	body, err := json.Marshal(obj)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json+fhir; charset=utf-8")
+	w.WriteHeader(status)
+	w.Write(body)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJson(w, status, JsonObject{
+		"resourceType": "OperationOutcome",
+		"issue": []JsonObject{
+			JsonObject{
+				"severity": "error",
+				"details":  err.Error(),
+			},
+		},
+	})
+}
+
+func intParam(r *http.Request, name string) (int, error) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return 0, nil
+	}
+
+	i, err := strconv.Atoi(v)
+	if err != nil || i < 0 {
+		return 0, fmt.Errorf("invalid value of '%s' parameter: %s", name, v)
+	}
+
+	return i, nil
+}
+
+func expandParams(r *http.Request) (ExpandParams, error) {
+	params := ExpandParams{
+		Filter:          r.URL.Query().Get("filter"),
+		DisplayLanguage: r.URL.Query().Get("displayLanguage"),
+	}
+	var err error
+
+	params.Offset, err = intParam(r, "offset")
+	if err != nil {
+		return params, err
+	}
+
+	params.Count, err = intParam(r, "count")
+	if err != nil {
+		return params, err
+	}
+
+	// may be repeated
+	for _, v := range r.URL.Query()["system-version"] {
+		err = addSystemVersion(&params, v)
+		if err != nil {
+			return params, err
+		}
+	}
+
+	return params, nil
+}
+
+// Value is like http://loinc.org|2.77
+func addSystemVersion(params *ExpandParams, v string) error {
+	parts := strings.SplitN(v, "|", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return fmt.Errorf("invalid value of 'system-version' parameter: %s", v)
+	}
+
+	if params.SystemVersions == nil {
+		params.SystemVersions = make(map[string]string)
+	}
+
+	params.SystemVersions[normalizeNsUrl(parts[0])] = parts[1]
+	return nil
+}
+
+func setIntParam(p Parameter, value *int) error {
+	if p.ValueInteger == nil || *p.ValueInteger < 0 {
+		return fmt.Errorf("invalid value of '%s' parameter", p.Name)
+	}
+
+	*value = *p.ValueInteger
+	return nil
+}
+
+// Body is either ValueSet resource or Parameters resource with value
+// set in valueSet parameter. Expansion parameters passed in
+// Parameters override ones from query string.
+func expandRequestBody(body io.Reader, params *ExpandParams) (*ValueSet, error) {
+	raw, err := ioutil.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+
+	var resource struct {
+		ResourceType string `json:"resourceType"`
+	}
+	err = json.Unmarshal(raw, &resource)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse request body: %s", err)
+	}
+
+	var vs *ValueSet
+
+	switch resource.ResourceType {
+	case "ValueSet":
+		err = json.Unmarshal(raw, &vs)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse ValueSet: %s", err)
+		}
+
+		return vs, nil
+	case "Parameters":
+	default:
+		return nil, fmt.Errorf("request body should be ValueSet or Parameters resource")
+	}
+
+	var parameters Parameters
+	err = json.Unmarshal(raw, &parameters)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse Parameters: %s", err)
+	}
+
+	for _, p := range parameters.Parameter {
+		switch p.Name {
+		case "valueSet":
+			err = json.Unmarshal(p.Resource, &vs)
+			if err != nil || vs == nil {
+				return nil, fmt.Errorf("'valueSet' parameter should contain ValueSet resource")
+			}
+		case "filter":
+			params.Filter = p.ValueString
+		case "displayLanguage":
+			params.DisplayLanguage = p.ValueCode
+			if params.DisplayLanguage == "" {
+				params.DisplayLanguage = p.ValueString
+			}
+		case "offset":
+			err = setIntParam(p, &params.Offset)
+		case "count":
+			err = setIntParam(p, &params.Count)
+		case "system-version":
+			err = addSystemVersion(params, p.ValueUri)
+		}
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if vs == nil {
+		return nil, fmt.Errorf("'valueSet' parameter is required")
+	}
+
+	return vs, nil
+}
+
+// Expands value set passed in request body, like draft which is
+// not saved into Storage yet
+func (s *Server) ValueSetExpandInline(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	params, err := expandParams(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	vs, err := expandRequestBody(r.Body, &params)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
 
-	vs, _ := ExpandValueSet(ps.ByName("id"))
+	vs, err = s.expandValueSetContent(vs, params)
+	if err != nil {
+		writeError(w, http.StatusUnprocessableEntity, err)
+		return
+	}
 
-	fmt.Fprintf(w, "hello, %s!\n%s", ps.ByName("id"), vs.Identifier)
-	fmt.Fprintf(w, "%s", display)
+	writeJson(w, http.StatusOK, vs)
+}
+
+// Serves both /ValueSet/:id/$expand and type-level
+// /ValueSet/$expand (value set is found by identifier)
+func (s *Server) ValueSetExpand(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	params, err := expandParams(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	var vs *ValueSet
+	if id := ps.ByName("id"); id == "$expand" {
+		// url is canonical identifier in later FHIR versions
+		identifier := r.URL.Query().Get("identifier")
+		if identifier == "" {
+			identifier = r.URL.Query().Get("url")
+		}
+
+		if identifier == "" {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("'identifier' parameter is required"))
+			return
+		}
+
+		vs, err = s.ExpandValueSetByIdentifier(identifier, params)
+	} else {
+		vs, err = s.ExpandValueSet(id, params)
+	}
+	if err != nil {
+		writeError(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	writeJson(w, http.StatusOK, vs)
+}
+
+func (s *Server) ValueSetLookup(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	query := r.URL.Query()
+	if query.Get("system") == "" || query.Get("code") == "" {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("'system' and 'code' parameters are required"))
+		return
+	}
+
+	params, err := s.LookupCode(query.Get("system"), query.Get("code"), query.Get("displayLanguage"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+
+	writeJson(w, http.StatusOK, params)
+}
+
+func (s *Server) CodeSystemSubsumes(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	query := r.URL.Query()
+	if query.Get("system") == "" || query.Get("codeA") == "" || query.Get("codeB") == "" {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("'system', 'codeA' and 'codeB' parameters are required"))
+		return
+	}
+
+	params, err := s.Subsumes(query.Get("system"), query.Get("codeA"), query.Get("codeB"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+
+	writeJson(w, http.StatusOK, params)
+}
+
+func (s *Server) ConceptMapTranslate(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	query := r.URL.Query()
+	if query.Get("system") == "" || query.Get("code") == "" {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("'system' and 'code' parameters are required"))
+		return
+	}
+
+	params, err := s.Translate(query.Get("system"), query.Get("code"), query.Get("target"))
+	if err != nil {
+		writeError(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	writeJson(w, http.StatusOK, params)
+}
+
+// Serves both type-level /ValueSet/$validate-code (system is required)
+// and /ValueSet/:id/$validate-code
+func (s *Server) ValueSetValidateCode(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	query := r.URL.Query()
+	id := ps.ByName("id")
+	var params *Parameters
+	var err error
+
+	if query.Get("code") == "" {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("'code' parameter is required"))
+		return
+	}
+
+	if id == "$validate-code" {
+		if query.Get("system") == "" {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("'system' parameter is required"))
+			return
+		}
+
+		params, err = s.ValidateCode(query.Get("system"), query.Get("code"), query.Get("display"))
+	} else {
+		params, err = s.ValidateCodeInValueSet(id, query.Get("system"), query.Get("code"), query.Get("display"))
+	}
+
+	if err != nil {
+		writeError(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	writeJson(w, http.StatusOK, params)
+}
+
+// Type-level operations share route with /ValueSet/:id
+func (s *Server) ValueSetTypeOperation(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	switch ps.ByName("id") {
+	case "$lookup":
+		s.ValueSetLookup(w, r, ps)
+	case "$validate-code":
+		s.ValueSetValidateCode(w, r, ps)
+	case "$expand":
+		s.ValueSetExpand(w, r, ps)
+	default:
+		writeError(w, http.StatusNotFound, fmt.Errorf("unknown operation: %s", ps.ByName("id")))
+	}
+}
+
+// Parameters resource is expected in request body
+func (s *Server) ClosureOperation(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var params Parameters
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil || params.ResourceType != "Parameters" {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("request body should be Parameters resource"))
+		return
+	}
+
+	var name string
+	var version *string
+	concepts := make([]Coding, 0)
+
+	for _, p := range params.Parameter {
+		switch p.Name {
+		case "name":
+			name = p.ValueString
+		case "version":
+			// version is id in FHIR spec, some clients send string
+			v := p.ValueId
+			if v == "" {
+				v = p.ValueString
+			}
+			version = &v
+		case "concept":
+			if p.ValueCoding != nil {
+				concepts = append(concepts, *p.ValueCoding)
+			}
+		}
+	}
+
+	cm, err := s.Closure(name, concepts, version)
+	if err != nil {
+		writeError(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	writeJson(w, http.StatusOK, cm)
+}
+
+func (s *Server) SnomedReplacementsHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	code := r.URL.Query().Get("code")
+	if code == "" {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("missing 'code' parameter"))
+		return
+	}
+
+	ns, found := s.Registry.FindNamespace(SnomedUrl)
+	if !found {
+		writeError(w, http.StatusNotFound, fmt.Errorf("SNOMED-CT is not loaded"))
+		return
+	}
+
+	// SNOMED-CT could be imported as FHIR CodeSystem without
+	// historical associations
+	snomed, ok := unwrapNamespace(ns).(*SnomedNamespace)
+	if !ok {
+		writeError(w, http.StatusUnprocessableEntity,
+			fmt.Errorf("SNOMED-CT is not served from RF2 release, historical associations are not available"))
+		return
+	}
+
+	replacements, err := FindSnomedReplacements(snomed.db, code)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+
+	writeJson(w, http.StatusOK, replacements)
 }
 
 type HttpLogger struct {
@@ -68,20 +497,36 @@ func setupCors(cfg *Config) *cors.Cors {
 	})
 }
 
-func StartServer(cfg *Config) {
-	addr := fmt.Sprintf("%s:%d", cfg.HttpHost, cfg.HttpPort)
+// Handler serving FHIR terminology API, can be mounted into
+// another http server when fhirterm is used as a library
+func (s *Server) Handler() http.Handler {
 	router := httprouter.New()
 
 	router.GET("/", Index)
-	router.GET("/ValueSet/:id/$expand", ValueSetExpand)
+	router.GET("/ValueSet/:id", s.ValueSetTypeOperation)
+	router.GET("/ValueSet/:id/$expand", s.ValueSetExpand)
+	router.GET("/ValueSet/:id/$validate-code", s.ValueSetValidateCode)
+	router.POST("/ValueSet/$expand", s.ValueSetExpandInline)
+	router.GET("/CodeSystem/$subsumes", s.CodeSystemSubsumes)
+	router.GET("/ConceptMap/$translate", s.ConceptMapTranslate)
+	router.POST("/$closure", s.ClosureOperation)
+	router.GET("/snomed/$replacements", s.SnomedReplacementsHandler)
 
 	n := negroni.New()
-	corsMw := setupCors(cfg)
-
 	n.Use(&HttpLogger{42})
-	n.Use(corsMw)
+
+	if s.Config != nil {
+		n.Use(setupCors(s.Config))
+	}
+
 	n.UseHandler(router)
 
+	return n
+}
+
+func (s *Server) ListenAndServe() error {
+	addr := fmt.Sprintf("%s:%d", s.Config.HttpHost, s.Config.HttpPort)
+
 	log.Printf("Starting FHIRterm server on %s", addr)
-	http.ListenAndServe(addr, n)
+	return http.ListenAndServe(addr, s.Handler())
 }
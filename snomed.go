@@ -0,0 +1,702 @@
+package fhirterm
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const SnomedUrl = "http://snomed.info/sct"
+
+var snomedHistoricalAssociations = map[int64]string{
+	900000000000527005: "SAME AS",
+	900000000000526001: "REPLACED BY",
+	900000000000523009: "POSSIBLY EQUIVALENT TO",
+}
+
+// Codes of $lookup properties listing replacements of inactive
+// concept
+var snomedReplacementProperties = map[string]string{
+	"SAME AS":                "sameAs",
+	"REPLACED BY":            "replacedBy",
+	"POSSIBLY EQUIVALENT TO": "possiblyEquivalentTo",
+}
+
+type SnomedReplacement struct {
+	Code        string `json:"code"`
+	Display     string `json:"display"`
+	Association string `json:"association"`
+}
+
+type SnomedReplacements struct {
+	Code         string              `json:"code"`
+	Active       bool                `json:"active"`
+	Replacements []SnomedReplacement `json:"replacements"`
+}
+
+func isSnomedConceptActive(db *DB, id int64) (bool, bool, error) {
+	row := db.QueryRow(`SELECT active FROM snomed_concepts WHERE id = ?
+                      ORDER BY effective_time DESC LIMIT 1`, id)
+
+	var active bool
+	err := row.Scan(&active)
+
+	if err == sql.ErrNoRows {
+		return false, false, nil
+	} else if err != nil {
+		return false, false, err
+	}
+
+	return active, true, nil
+}
+
+func snomedConceptDisplay(db *DB, id int64) (string, error) {
+	row := db.QueryRow("SELECT term FROM snomed_concepts_no_history WHERE concept_id = ?", id)
+
+	var term string
+	err := row.Scan(&term)
+
+	if err != nil && err != sql.ErrNoRows {
+		return "", err
+	}
+
+	return term, nil
+}
+
+type snomedAssociation struct {
+	targetId int64
+	refsetId int64
+}
+
+func snomedAssociationsOf(db *DB, id int64) ([]snomedAssociation, error) {
+	rows, err := db.Query(`SELECT target_id, refset_id FROM snomed_historical_associations
+                         WHERE source_id = ?`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make([]snomedAssociation, 0)
+	for rows.Next() {
+		var a snomedAssociation
+		err = rows.Scan(&a.targetId, &a.refsetId)
+		if err != nil {
+			return nil, err
+		}
+
+		result = append(result, a)
+	}
+
+	return result, rows.Err()
+}
+
+// Follows SAME AS, REPLACED BY and POSSIBLY EQUIVALENT TO associations
+// of an inactive concept until active concepts are reached. Association
+// of the first hop is reported for every replacement found.
+func FindSnomedReplacements(db *DB, code string) (*SnomedReplacements, error) {
+	id, err := strconv.ParseInt(code, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SNOMED-CT code: %s", code)
+	}
+
+	active, found, err := isSnomedConceptActive(db, id)
+	if err != nil {
+		return nil, err
+	} else if !found {
+		return nil, fmt.Errorf("unknown SNOMED-CT code: %s", code)
+	}
+
+	result := &SnomedReplacements{
+		Code:         code,
+		Active:       active,
+		Replacements: make([]SnomedReplacement, 0),
+	}
+
+	if active {
+		return result, nil
+	}
+
+	visited := NewIntsetFromSlice([]int64{id})
+
+	assocs, err := snomedAssociationsOf(db, id)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, a := range assocs {
+		queue := []int64{a.targetId}
+
+		for len(queue) > 0 {
+			current := queue[0]
+			queue = queue[1:]
+
+			if !visited.Add(current) {
+				continue
+			}
+
+			currentActive, _, err := isSnomedConceptActive(db, current)
+			if err != nil {
+				return nil, err
+			}
+
+			if currentActive {
+				display, err := snomedConceptDisplay(db, current)
+				if err != nil {
+					return nil, err
+				}
+
+				result.Replacements = append(result.Replacements, SnomedReplacement{
+					Code:        strconv.FormatInt(current, 10),
+					Display:     display,
+					Association: snomedHistoricalAssociations[a.refsetId],
+				})
+
+				continue
+			}
+
+			next, err := snomedAssociationsOf(db, current)
+			if err != nil {
+				return nil, err
+			}
+
+			for _, n := range next {
+				queue = append(queue, n.targetId)
+			}
+		}
+	}
+
+	return result, nil
+}
+
+const snomedIsATypeId = "116680003"
+
+type SnomedNamespace struct {
+	db *DB
+}
+
+func NewSnomedNamespace(db *DB) *SnomedNamespace {
+	return &SnomedNamespace{db: db}
+}
+
+func (ns *SnomedNamespace) Url() string {
+	return SnomedUrl
+}
+
+func (ns *SnomedNamespace) Filter(f *NsFilter) ([]VsExpansionContains, error) {
+	ids, err := evalNsFilter(f, ns)
+	if err != nil {
+		return nil, err
+	}
+
+	return ns.idsToContains(ids, f.Text)
+}
+
+// Supports ?fhir_vs, ?fhir_vs=isa/X, ?fhir_vs=refset/X and
+// ?fhir_vs=ecl/EXPRESSION
+func (ns *SnomedNamespace) ImplicitValueSet(identifier string) (*ValueSet, bool) {
+	param, found := implicitValueSetParam(identifier, SnomedUrl)
+	if !found {
+		return nil, false
+	}
+
+	include := VsComposeInclude{System: SnomedUrl}
+
+	switch {
+	case param == "":
+	case strings.HasPrefix(param, "isa/"):
+		include.Filter = []VsComposeIncludeFilter{
+			{Property: "concept", Op: "is-a", Value: strings.TrimPrefix(param, "isa/")},
+		}
+	case strings.HasPrefix(param, "refset/"):
+		include.Filter = []VsComposeIncludeFilter{
+			{Property: "constraint", Op: "=", Value: "^" + strings.TrimPrefix(param, "refset/")},
+		}
+	case strings.HasPrefix(param, "ecl/"):
+		include.Filter = []VsComposeIncludeFilter{
+			{Property: "constraint", Op: "=", Value: strings.TrimPrefix(param, "ecl/")},
+		}
+	default:
+		return nil, false
+	}
+
+	return makeImplicitValueSet(identifier, include), true
+}
+
+func (ns *SnomedNamespace) Lookup(code string, displayLanguage string) (*NsConcept, error) {
+	id, err := strconv.ParseInt(code, 10, 64)
+	if err != nil {
+		return nil, nil
+	}
+
+	var display string
+	err = ns.db.QueryRow("SELECT term FROM snomed_concepts_no_history WHERE concept_id = ?", id).Scan(&display)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	concept := &NsConcept{System: SnomedUrl, Code: code, Display: display}
+
+	rows, err := ns.db.Query(`SELECT d.language_code, d.term FROM snomed_descriptions AS d
+                            JOIN (SELECT id, max(effective_time) AS effective_time
+                                  FROM snomed_descriptions WHERE concept_id = ? GROUP BY id) AS l
+                            ON l.id = d.id AND l.effective_time = d.effective_time
+                            WHERE d.active = 1`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var d NsDesignation
+		err = rows.Scan(&d.Language, &d.Value)
+		if err != nil {
+			return nil, err
+		}
+
+		concept.Designations = append(concept.Designations, d)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	concept.Properties, err = ns.statusProperties(id)
+	if err != nil {
+		return nil, err
+	}
+
+	return concept, nil
+}
+
+// Inactive concepts are reported with their active replacements,
+// so retired codes of legacy data can be recoded
+func (ns *SnomedNamespace) statusProperties(id int64) ([]NsProperty, error) {
+	active, found, err := isSnomedConceptActive(ns.db, id)
+	if err != nil || !found {
+		return nil, err
+	}
+
+	result := []NsProperty{{Code: "inactive", Value: strconv.FormatBool(!active)}}
+	if active {
+		return result, nil
+	}
+
+	replacements, err := FindSnomedReplacements(ns.db, strconv.FormatInt(id, 10))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, r := range replacements.Replacements {
+		result = append(result, NsProperty{Code: snomedReplacementProperties[r.Association], Value: r.Code})
+	}
+
+	return result, nil
+}
+
+// Latest state of concept is inactive
+func (ns *SnomedNamespace) inactiveConcepts() (*Intset, error) {
+	return queryIntset(ns.db, `SELECT c.id FROM snomed_concepts AS c
+                             JOIN (SELECT id, max(effective_time) AS effective_time
+                                   FROM snomed_concepts GROUP BY id) AS l
+                             ON l.id = c.id AND l.effective_time = c.effective_time
+                             WHERE c.active = 0`)
+}
+
+func (ns *SnomedNamespace) allConcepts() (*Intset, error) {
+	return queryIntset(ns.db, "SELECT concept_id FROM snomed_concepts_no_history")
+}
+
+func (ns *SnomedNamespace) closure(column string, id int64) ([]int64, error) {
+	row := ns.db.QueryRow("SELECT "+column+" FROM snomed_ancestors_descendants WHERE concept_id = ?", id)
+
+	var blob []byte
+	err := row.Scan(&blob)
+	if err == sql.ErrNoRows {
+		return []int64{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return blobToInt64Slice(blob)
+}
+
+func (ns *SnomedNamespace) descendants(id int64) ([]int64, error) {
+	return ns.closure("descendants", id)
+}
+
+func (ns *SnomedNamespace) ancestors(id int64) ([]int64, error) {
+	return ns.closure("ancestors", id)
+}
+
+func (ns *SnomedNamespace) AncestorCodes(code string) ([]string, error) {
+	id, err := strconv.ParseInt(code, 10, 64)
+	if err != nil {
+		return []string{}, nil
+	}
+
+	ancestors, err := ns.ancestors(id)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]string, 0, len(ancestors))
+	for _, a := range ancestors {
+		result = append(result, strconv.FormatInt(a, 10))
+	}
+
+	return result, nil
+}
+
+func (ns *SnomedNamespace) queryIds(query string, args ...interface{}) ([]int64, error) {
+	set, err := queryIntset(ns.db, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	return set.ToInt64Slice(), nil
+}
+
+func (ns *SnomedNamespace) children(id int64) ([]int64, error) {
+	return ns.queryIds("SELECT source_id FROM snomed_is_a_relationships WHERE destination_id = ?", id)
+}
+
+func (ns *SnomedNamespace) parents(id int64) ([]int64, error) {
+	return ns.queryIds("SELECT destination_id FROM snomed_is_a_relationships WHERE source_id = ?", id)
+}
+
+func (ns *SnomedNamespace) refsetMembers(refsetId int64) ([]int64, error) {
+	return ns.queryIds(`SELECT referenced_component_id FROM snomed_refset_members
+                      WHERE refset_id = ?`, refsetId)
+}
+
+// Resolves value of is-a, descendent-of, = and in operations
+// to the set of concept ids
+func (ns *SnomedNamespace) subsumedIds(op string, value string) (*Intset, error) {
+	switch op {
+	case "=", "in":
+		return parseSnomedIds(strings.Split(value, ","))
+	case "is-a", "descendent-of":
+		ids, err := parseSnomedIds([]string{value})
+		if err != nil {
+			return nil, err
+		}
+
+		result := NewIntset()
+		for id := range ids.M {
+			descendants, err := ns.descendants(id)
+			if err != nil {
+				return nil, err
+			}
+
+			result.AddSlice(descendants)
+
+			if op == "is-a" {
+				result.Add(id)
+			}
+		}
+
+		return result, nil
+	}
+
+	return nil, fmt.Errorf("unsupported SNOMED-CT filter op: %s", op)
+}
+
+func (ns *SnomedNamespace) predicateToIntset(p NsPredicate) (*Intset, error) {
+	if p.Property == "constraint" {
+		if p.Op != "=" {
+			return nil, unsupportedPredicateError("SNOMED-CT", p)
+		}
+
+		expr, err := parseEcl(p.Value)
+		if err != nil {
+			return nil, err
+		}
+
+		return expr.eval(ns)
+	}
+
+	if p.Property == "concept" {
+		if p.Op == "in" && p.Concepts != nil {
+			codes := make([]string, 0, len(p.Concepts))
+			for _, c := range p.Concepts {
+				codes = append(codes, c.Code)
+			}
+
+			return parseSnomedIds(codes)
+		}
+
+		return ns.subsumedIds(p.Op, p.Value)
+	}
+
+	// any other property is treated as attribute type id,
+	// i.e. "363698007 is-a 39607008" means "finding site is lung
+	// structure or any of its descendants"
+	typeId, err := strconv.ParseInt(p.Property, 10, 64)
+	if err != nil || p.Property == snomedIsATypeId {
+		return nil, unsupportedPredicateError("SNOMED-CT", p)
+	}
+
+	destinations, err := ns.subsumedIds(p.Op, p.Value)
+	if err != nil {
+		return nil, err
+	}
+
+	return ns.attributeSources(NewIntsetFromSlice([]int64{typeId}), destinations, false)
+}
+
+// Returns concepts having attribute of any of specified types pointing
+// to any of destinations (or to anything else if negate is true)
+func (ns *SnomedNamespace) attributeSources(typeIds *Intset, destinations *Intset, negate bool) (*Intset, error) {
+	result := NewIntset()
+
+	if destinations == nil || negate {
+		err := queryByIdChunks(ns.db,
+			`SELECT source_id, destination_id FROM snomed_attribute_relationships
+       WHERE type_id IN (%s)`,
+			typeIds.ToInt64Slice(),
+			nil,
+			func(rows *sql.Rows) error {
+				var source, destination int64
+				err := rows.Scan(&source, &destination)
+
+				if destinations == nil || !destinations.Contains(destination) {
+					result.Add(source)
+				}
+
+				return err
+			})
+
+		if err != nil {
+			return nil, err
+		}
+
+		return result, nil
+	}
+
+	for typeId := range typeIds.M {
+		err := queryByIdChunks(ns.db,
+			`SELECT source_id FROM snomed_attribute_relationships
+       WHERE type_id = ? AND destination_id IN (%s)`,
+			destinations.ToInt64Slice(),
+			[]interface{}{typeId},
+			func(rows *sql.Rows) error {
+				var id int64
+				err := rows.Scan(&id)
+				result.Add(id)
+				return err
+			})
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return result, nil
+}
+
+func (ns *SnomedNamespace) idsToContains(ids *Intset, text string) ([]VsExpansionContains, error) {
+	sorted := ids.ToInt64Slice()
+	sort.Sort(int64Slice(sorted))
+
+	displays := make(map[int64]string, len(sorted))
+	err := queryByIdChunks(ns.db,
+		"SELECT concept_id, term FROM snomed_concepts_no_history WHERE concept_id IN (%s)",
+		sorted,
+		nil,
+		func(rows *sql.Rows) error {
+			var id int64
+			var term string
+			err := rows.Scan(&id, &term)
+			displays[id] = term
+			return err
+		})
+
+	if err != nil {
+		return nil, err
+	}
+
+	// ids of =, in and compose.include.concept are not checked
+	// before, unknown codes are skipped like in memory mode
+	result := make([]VsExpansionContains, 0, len(sorted))
+	for _, id := range sorted {
+		display, found := displays[id]
+		if !found {
+			continue
+		}
+
+		result = append(result, VsExpansionContains{
+			System:  SnomedUrl,
+			Code:    strconv.FormatInt(id, 10),
+			Display: display,
+		})
+	}
+
+	return filterContainsByText(result, text), nil
+}
+
+func parseSnomedIds(codes []string) (*Intset, error) {
+	result := NewIntset()
+
+	for _, c := range codes {
+		id, err := strconv.ParseInt(strings.TrimSpace(c), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid SNOMED-CT code: %s", c)
+		}
+
+		result.Add(id)
+	}
+
+	return result, nil
+}
+
+func (ns *SnomedNamespace) loadMemoryTerminology() (*memoryTerminology, error) {
+	t := newMemoryTerminology()
+
+	rows, err := ns.db.Query("SELECT concept_id, term FROM snomed_concepts_no_history ORDER BY concept_id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int64
+		var term string
+		err = rows.Scan(&id, &term)
+		if err != nil {
+			return nil, err
+		}
+
+		t.addConcept(id, strconv.FormatInt(id, 10), term)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	closureRows, err := ns.db.Query("SELECT concept_id, descendants FROM snomed_ancestors_descendants")
+	if err != nil {
+		return nil, err
+	}
+	defer closureRows.Close()
+
+	for closureRows.Next() {
+		var id int64
+		var blob []byte
+		err = closureRows.Scan(&id, &blob)
+		if err != nil {
+			return nil, err
+		}
+
+		i, found := t.idIndex[id]
+		if !found {
+			continue
+		}
+
+		descendants, err := blobToInt64Slice(blob)
+		if err != nil {
+			return nil, err
+		}
+
+		t.setDescendantIds(i, descendants)
+	}
+
+	if err = closureRows.Err(); err != nil {
+		return nil, err
+	}
+
+	inactive, err := ns.inactiveConcepts()
+	if err != nil {
+		return nil, err
+	}
+
+	t.setInactiveIds(inactive)
+	return t, nil
+}
+
+// Correlation of SNOMED-CT map rows, correlation of ICD-10 maps is
+// usually not specified, so such matches are inexact
+const snomedExactMatchCorrelationId = 447557004
+
+// Map rules of targets chosen without any condition
+var snomedUnconditionalMapRules = map[string]bool{
+	"":               true,
+	"TRUE":           true,
+	"OTHERWISE TRUE": true,
+}
+
+// Targets chosen by rule (like age or gender of patient) are inexact
+// and their rule is returned in comments together with map advice
+func snomedMapComments(rule string, advice string) string {
+	comments := make([]string, 0, 2)
+
+	if !snomedUnconditionalMapRules[strings.TrimSpace(rule)] {
+		comments = append(comments, "Map rule: "+rule)
+	}
+
+	if advice != "" {
+		comments = append(comments, "Map advice: "+advice)
+	}
+
+	return strings.Join(comments, "; ")
+}
+
+// Maps imported from extended map refsets (see ftdb
+// import-snomed-icd10-map action), targets are ordered by map group
+// and priority
+func (ns *SnomedNamespace) Translate(code string, target string) ([]TranslateMatch, error) {
+	id, err := strconv.ParseInt(code, 10, 64)
+	if err != nil {
+		return nil, nil
+	}
+
+	exists, err := TableExists(ns.db, "snomed_icd10_maps")
+	if err != nil || !exists {
+		return nil, err
+	}
+
+	rows, err := ns.db.Query(`SELECT refset_id, system, target, correlation_id,
+                                   COALESCE(map_rule, ''), COALESCE(map_advice, '')
+                            FROM snomed_icd10_maps
+                            WHERE source_id = ? ORDER BY refset_id, map_group, map_priority`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make([]TranslateMatch, 0)
+	seen := make(map[string]bool)
+
+	for rows.Next() {
+		var refsetId, correlationId int64
+		var system, targetCode, rule, advice string
+		err = rows.Scan(&refsetId, &system, &targetCode, &correlationId, &rule, &advice)
+		if err != nil {
+			return nil, err
+		}
+
+		// the same target can be listed in several map groups
+		key := system + "|" + targetCode
+		if (target != "" && normalizeNsUrl(system) != normalizeNsUrl(target)) || seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		equivalence := "inexact"
+		if correlationId == snomedExactMatchCorrelationId && snomedUnconditionalMapRules[strings.TrimSpace(rule)] {
+			equivalence = "equivalent"
+		}
+
+		result = append(result, TranslateMatch{
+			Equivalence: equivalence,
+			Concept:     Coding{System: system, Code: targetCode},
+			Source:      SnomedUrl + "?fhir_cm=" + strconv.FormatInt(refsetId, 10),
+			Comments:    snomedMapComments(rule, advice),
+		})
+	}
+
+	return result, rows.Err()
+}
@@ -0,0 +1,172 @@
+package fhirterm
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// 20 was replaced by 30 which is inactive since 2010 and is the
+// same as 40, 20 is also possibly equivalent to 50. 60 and 70 are
+// inactive and refer to each other.
+var snomedHistoryStmts = []string{
+	"CREATE TABLE snomed_concepts (id integer, effective_time integer, active integer)",
+	`INSERT INTO snomed_concepts VALUES (10, 2002, 1), (20, 2002, 1), (20, 2005, 0), (30, 2002, 1),
+   (30, 2010, 0), (40, 2002, 1), (50, 2002, 1), (60, 2002, 0), (70, 2002, 0)`,
+	"CREATE TABLE snomed_historical_associations (source_id bigint, target_id bigint, refset_id bigint)",
+	`INSERT INTO snomed_historical_associations VALUES (20, 30, 900000000000526001),
+   (30, 40, 900000000000527005), (20, 50, 900000000000523009), (60, 70, 900000000000527005),
+   (70, 60, 900000000000527005)`,
+	"CREATE TABLE snomed_concepts_no_history (concept_id bigint, effective_time integer, term text)",
+	`INSERT INTO snomed_concepts_no_history VALUES (10, 2002, 'Ten'), (20, 2005, 'Twenty'),
+   (30, 2010, 'Thirty'), (40, 2002, 'Forty'), (50, 2002, 'Fifty'), (60, 2002, 'Sixty'),
+   (70, 2002, 'Seventy')`,
+	`CREATE TABLE snomed_descriptions (id integer, effective_time integer, active integer,
+   concept_id integer, language_code text, term text)`,
+	"CREATE TABLE snomed_ancestors_descendants (concept_id bigint, ancestors blob, descendants blob)",
+}
+
+func Test_FindSnomedReplacements(t *testing.T) {
+	assert := assert.New(t)
+	db, closeDb := openTestDb(t, snomedHistoryStmts...)
+	defer closeDb()
+
+	r, err := FindSnomedReplacements(db, "20")
+	assert.Nil(err)
+	assert.False(r.Active)
+	assert.Equal([]SnomedReplacement{
+		{Code: "40", Display: "Forty", Association: "REPLACED BY"},
+		{Code: "50", Display: "Fifty", Association: "POSSIBLY EQUIVALENT TO"},
+	}, r.Replacements, "inactive targets are followed, association of first hop is reported")
+
+	r, err = FindSnomedReplacements(db, "10")
+	assert.Nil(err)
+	assert.True(r.Active)
+	assert.Equal(0, len(r.Replacements))
+
+	r, err = FindSnomedReplacements(db, "60")
+	assert.Nil(err)
+	assert.Equal(0, len(r.Replacements), "cycles of inactive concepts are not followed forever")
+
+	_, err = FindSnomedReplacements(db, "80")
+	assert.EqualError(err, "unknown SNOMED-CT code: 80")
+
+	_, err = FindSnomedReplacements(db, "x")
+	assert.EqualError(err, "invalid SNOMED-CT code: x")
+}
+
+func Test_SnomedInactiveConcepts(t *testing.T) {
+	assert := assert.New(t)
+	db, closeDb := openTestDb(t, snomedHistoryStmts...)
+	defer closeDb()
+
+	s := &Server{Registry: NewRegistry()}
+	s.Registry.RegisterNamespace(NewSnomedNamespace(db))
+
+	inactive := validationResult(true, "Code '20' is inactive in code system "+SnomedUrl, "Twenty")
+	inactive.Parameter = append(inactive.Parameter, conceptPropertiesToParameters(&NsConcept{
+		Properties: []NsProperty{
+			{Code: "inactive", Value: "true"},
+			{Code: "replacedBy", Value: "40"},
+			{Code: "possiblyEquivalentTo", Value: "50"},
+		},
+	})...)
+
+	for _, inMemory := range []bool{false, true} {
+		if inMemory {
+			assert.Nil(s.Registry.LoadIntoMemory([]string{SnomedUrl}))
+		}
+
+		result, err := s.ValidateCode(SnomedUrl, "20", "")
+		assert.Nil(err)
+		assert.Equal(inactive, result)
+
+		result, err = s.ValidateCode(SnomedUrl, "10", "ten")
+		assert.Nil(err)
+		assert.True(*result.Parameter[0].ValueBoolean)
+		assert.Equal("display", result.Parameter[1].Name)
+	}
+}
+
+func Test_SnomedReplacementsHandler(t *testing.T) {
+	assert := assert.New(t)
+	db, closeDb := openTestDb(t, snomedHistoryStmts...)
+	defer closeDb()
+
+	get := func(s *Server) int {
+		ts := httptest.NewServer(s.Handler())
+		defer ts.Close()
+
+		resp, err := http.Get(ts.URL + "/snomed/$replacements?code=20")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+
+		return resp.StatusCode
+	}
+
+	s := &Server{Registry: NewRegistry()}
+	s.Registry.RegisterNamespace(NewSnomedNamespace(db))
+	assert.Equal(http.StatusOK, get(s))
+
+	s = &Server{Registry: NewRegistry()}
+	s.Registry.RegisterNamespace(fakeNamespace{SnomedUrl, ""})
+	assert.Equal(http.StatusUnprocessableEntity, get(s), "SNOMED-CT imported as FHIR CodeSystem")
+}
+
+func Test_SnomedFilterUnknownCodes(t *testing.T) {
+	assert := assert.New(t)
+	db, closeDb := openTestDb(t, snomedHistoryStmts...)
+	defer closeDb()
+
+	r := NewRegistry()
+	r.RegisterNamespace(NewSnomedNamespace(db))
+
+	for _, inMemory := range []bool{false, true} {
+		if inMemory {
+			assert.Nil(r.LoadIntoMemory([]string{SnomedUrl}))
+		}
+
+		ns, _ := r.FindNamespace(SnomedUrl)
+		contains, err := ns.Filter(&NsFilter{Include: [][]NsPredicate{
+			{{Property: "concept", Op: "in", Value: "10,80"}},
+			{{Property: "concept", Op: "=", Value: "90"}},
+			{{Property: "concept", Op: "in", Concepts: []VsComposeIncludeConcept{{Code: "40"}, {Code: "100"}}}},
+		}})
+
+		assert.Nil(err)
+		assert.Equal([]VsExpansionContains{
+			{System: SnomedUrl, Code: "10", Display: "Ten"},
+			{System: SnomedUrl, Code: "40", Display: "Forty"},
+		}, contains)
+	}
+}
+
+func Test_SnomedTranslateMapRules(t *testing.T) {
+	assert := assert.New(t)
+	db, closeDb := openTestDb(t,
+		`CREATE TABLE snomed_icd10_maps (refset_id bigint, source_id bigint, system text, target text,
+     map_group integer, map_priority integer, map_rule text, map_advice text, correlation_id bigint)`,
+		`INSERT INTO snomed_icd10_maps VALUES
+     (447562003, 10, 'http://hl7.org/fhir/sid/icd-10', 'Y83.9', 2, 1, 'TRUE', '', 447557004),
+     (447562003, 10, 'http://hl7.org/fhir/sid/icd-10', 'N99.1', 1, 2, 'OTHERWISE TRUE', 'ALWAYS N99.1', 447561005),
+     (447562003, 10, 'http://hl7.org/fhir/sid/icd-10', 'N99.0', 1, 1, 'IFA 248152002 | Female (finding) |',
+      'IF FEMALE CHOOSE N99.0', 447557004)`)
+	defer closeDb()
+
+	source := SnomedUrl + "?fhir_cm=447562003"
+	matches, err := NewSnomedNamespace(db).Translate("10", "")
+	assert.Nil(err)
+	assert.Equal([]TranslateMatch{
+		{Equivalence: "inexact", Concept: Coding{System: Icd10Url, Code: "N99.0"}, Source: source,
+			Comments: "Map rule: IFA 248152002 | Female (finding) |; Map advice: IF FEMALE CHOOSE N99.0"},
+		{Equivalence: "inexact", Concept: Coding{System: Icd10Url, Code: "N99.1"}, Source: source,
+			Comments: "Map advice: ALWAYS N99.1"},
+		{Equivalence: "equivalent", Concept: Coding{System: Icd10Url, Code: "Y83.9"}, Source: source},
+	}, matches, "conditional targets are inexact and carry their rule")
+
+	params := matchToParameter(matches[0])
+	assert.Equal(Parameter{Name: "comments", ValueString: matches[0].Comments}, params.Part[len(params.Part)-1])
+}
@@ -6,6 +6,10 @@ import (
 
 type Storage interface {
 	FindValueSetById(id string) (*ValueSet, error)
+	// Canonical identifier is like http://hl7.org/fhir/ValueSet/c80-facilitycodes
+	FindValueSetByIdentifier(identifier string) (*ValueSet, error)
+	// Returns concept maps having mappings of code from system
+	FindConceptMaps(system string, code string) ([]*ConceptMap, error)
 }
 
 type storageFactoryFunc func(cfg JsonObject) (Storage, error)
@@ -14,8 +18,6 @@ var storageFactories = map[string]storageFactoryFunc{
 	"rest": MakeRestStorage,
 }
 
-var storage Storage = nil
-
 func makeStorage(cfg JsonObject) (Storage, error) {
 	if cfg == nil {
 		return nil, fmt.Errorf("passed config is null or not a JSON object")
@@ -33,18 +35,3 @@ func makeStorage(cfg JsonObject) (Storage, error) {
 
 	return factory(cfg)
 }
-
-func InitStorage(cfg JsonObject) error {
-	var err error
-	storage, err = makeStorage(cfg)
-
-	if err != nil {
-		return err
-	}
-
-	return nil
-}
-
-func GetStorage() Storage {
-	return storage
-}
@@ -0,0 +1,87 @@
+package fhirterm
+
+import (
+	"fmt"
+)
+
+// Outcomes of $subsumes operation
+const (
+	SubsumesEquivalent  = "equivalent"
+	SubsumesSubsumes    = "subsumes"
+	SubsumesSubsumedBy  = "subsumed-by"
+	SubsumesNotSubsumed = "not-subsumed"
+)
+
+// Namespaces keeping displays in memory are checked without
+// database lookup
+func isKnownCode(ns Namespace, code string) (bool, error) {
+	if d, ok := ns.(CodeDisplayer); ok {
+		_, found := d.CodeDisplay(code)
+		return found, nil
+	}
+
+	lookup, ok := ns.(ConceptLookup)
+	if !ok {
+		return true, nil
+	}
+
+	concept, err := lookup.Lookup(code, "")
+	return concept != nil, err
+}
+
+func hasAncestor(h HierarchyNamespace, code string, ancestor string) (bool, error) {
+	ancestors, err := h.AncestorCodes(code)
+	if err != nil {
+		return false, err
+	}
+
+	for _, a := range ancestors {
+		if a == ancestor {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// Tests whether code A subsumes code B, codes are compared using
+// concept hierarchy of namespace (see HierarchyNamespace)
+func (s *Server) Subsumes(system string, codeA string, codeB string) (*Parameters, error) {
+	ns, found := s.Registry.FindNamespace(system)
+	if !found {
+		return nil, fmt.Errorf("unknown code system: %s", system)
+	}
+
+	h, ok := ns.(HierarchyNamespace)
+	if !ok {
+		return nil, fmt.Errorf("code system %s does not support $subsumes", system)
+	}
+
+	for _, code := range []string{codeA, codeB} {
+		known, err := isKnownCode(ns, code)
+		if err != nil {
+			return nil, err
+		} else if !known {
+			return nil, fmt.Errorf("unknown code '%s' in code system %s", code, system)
+		}
+	}
+
+	outcome := SubsumesNotSubsumed
+
+	if codeA == codeB {
+		outcome = SubsumesEquivalent
+	} else if subsumes, err := hasAncestor(h, codeB, codeA); err != nil {
+		return nil, err
+	} else if subsumes {
+		outcome = SubsumesSubsumes
+	} else if subsumedBy, err := hasAncestor(h, codeA, codeB); err != nil {
+		return nil, err
+	} else if subsumedBy {
+		outcome = SubsumesSubsumedBy
+	}
+
+	return &Parameters{
+		ResourceType: "Parameters",
+		Parameter:    []Parameter{Parameter{Name: "outcome", ValueCode: outcome}},
+	}, nil
+}
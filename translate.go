@@ -0,0 +1,149 @@
+package fhirterm
+
+import (
+	"fmt"
+)
+
+type TranslateMatch struct {
+	Equivalence string
+	Concept     Coding
+	// url of concept map the match comes from
+	Source string
+	// conditions and advice of map, like map rule of SNOMED-CT maps
+	Comments string
+}
+
+// Namespaces having built-in maps to other code systems (like
+// SNOMED-CT to ICD-10 maps) implement this interface, empty target
+// means any code system
+type ConceptTranslator interface {
+	Translate(code string, target string) ([]TranslateMatch, error)
+}
+
+// Equivalences meaning that source concept has no match
+var unmatchedEquivalences = map[string]bool{
+	"unmatched": true,
+	"disjoint":  true,
+}
+
+func conceptMapMatches(cm *ConceptMap, system string, code string, target string) []TranslateMatch {
+	result := make([]TranslateMatch, 0)
+
+	for _, e := range cm.Element {
+		if e.Code != code || normalizeNsUrl(e.CodeSystem) != normalizeNsUrl(system) {
+			continue
+		}
+
+		for _, t := range e.Target {
+			if target != "" && normalizeNsUrl(t.CodeSystem) != normalizeNsUrl(target) {
+				continue
+			}
+
+			result = append(result, TranslateMatch{
+				Equivalence: t.Equivalence,
+				Concept:     Coding{System: t.CodeSystem, Code: t.Code},
+				Source:      cm.Url,
+				Comments:    t.Comments,
+			})
+		}
+	}
+
+	return result
+}
+
+// Display of code from namespace serving its code system, empty
+// string if code system is not served
+func (s *Server) codeDisplay(system string, code string) (string, error) {
+	ns, found := s.Registry.FindNamespace(system)
+	if !found {
+		return "", nil
+	}
+
+	if d, ok := ns.(CodeDisplayer); ok {
+		display, _ := d.CodeDisplay(code)
+		return display, nil
+	}
+
+	if lookup, ok := ns.(ConceptLookup); ok {
+		concept, err := lookup.Lookup(code, "")
+		if err != nil || concept == nil {
+			return "", err
+		}
+
+		return concept.Display, nil
+	}
+
+	return "", nil
+}
+
+func matchToParameter(m TranslateMatch) Parameter {
+	concept := m.Concept
+	parts := []Parameter{
+		Parameter{Name: "equivalence", ValueCode: m.Equivalence},
+		Parameter{Name: "concept", ValueCoding: &concept},
+	}
+
+	if m.Source != "" {
+		parts = append(parts, Parameter{Name: "source", ValueUri: m.Source})
+	}
+
+	if m.Comments != "" {
+		parts = append(parts, Parameter{Name: "comments", ValueString: m.Comments})
+	}
+
+	return Parameter{Name: "match", Part: parts}
+}
+
+// Translates code with built-in maps of code system namespace and
+// concept maps from Storage
+func (s *Server) Translate(system string, code string, target string) (*Parameters, error) {
+	matches := make([]TranslateMatch, 0)
+
+	if ns, found := s.Registry.FindNamespace(system); found {
+		if t, ok := ns.(ConceptTranslator); ok {
+			builtin, err := t.Translate(code, target)
+			if err != nil {
+				return nil, err
+			}
+
+			matches = append(matches, builtin...)
+		}
+	}
+
+	cms, err := s.Storage.FindConceptMaps(system, code)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, cm := range cms {
+		matches = append(matches, conceptMapMatches(cm, system, code, target)...)
+	}
+
+	result := false
+	params := make([]Parameter, 0, len(matches)+2)
+
+	for _, m := range matches {
+		if m.Concept.Display == "" && m.Concept.Code != "" {
+			m.Concept.Display, err = s.codeDisplay(m.Concept.System, m.Concept.Code)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		result = result || !unmatchedEquivalences[m.Equivalence]
+		params = append(params, matchToParameter(m))
+	}
+
+	head := []Parameter{Parameter{Name: "result", ValueBoolean: &result}}
+	if !result {
+		head = append(head, Parameter{
+			Name:        "message",
+			ValueString: fmt.Sprintf("No mappings found for code '%s' of code system %s", code, system),
+		})
+	}
+
+	return &Parameters{
+		ResourceType: "Parameters",
+		Parameter:    append(head, params...),
+	}, nil
+}
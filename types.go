@@ -1,5 +1,9 @@
 package fhirterm
 
+import (
+	"encoding/json"
+)
+
 type JsonObject map[string]interface{}
 
 type VsDefineConcept struct {
@@ -43,15 +47,58 @@ type VsCompose struct {
 	Exclude []VsComposeInclude `json:"exclude"`
 }
 
+type VsExpansionContains struct {
+	System  string `json:"system"`
+	Code    string `json:"code"`
+	Display string `json:"display"`
+}
+
+type VsExpansionParameter struct {
+	Name     string `json:"name"`
+	ValueUri string `json:"valueUri,omitempty"`
+}
+
+type VsExpansion struct {
+	Identifier string                 `json:"identifier,omitempty"`
+	Timestamp  string                 `json:"timestamp"`
+	Total      int                    `json:"total"`
+	Offset     int                    `json:"offset"`
+	Parameter  []VsExpansionParameter `json:"parameter,omitempty"`
+	Contains   []VsExpansionContains  `json:"contains"`
+}
+
 type ValueSet struct {
-	Id           string     `json:"id"`
-	ResourceType string     `json:"resourceType"`
-	Identifier   string     `json:"identifier"`
-	Name         string     `json:"name"`
-	Publisher    string     `json:"publisher"`
-	Description  string     `json:"description"`
-	Define       *VsDefine  `json:"define,omitempty"`
-	Compose      *VsCompose `json:"compose,omitempty"`
+	Id           string       `json:"id"`
+	ResourceType string       `json:"resourceType"`
+	Identifier   string       `json:"identifier"`
+	Name         string       `json:"name"`
+	Publisher    string       `json:"publisher"`
+	Description  string       `json:"description"`
+	Define       *VsDefine    `json:"define,omitempty"`
+	Compose      *VsCompose   `json:"compose,omitempty"`
+	Expansion    *VsExpansion `json:"expansion,omitempty"`
+}
+
+type CmTarget struct {
+	CodeSystem  string `json:"codeSystem"`
+	Code        string `json:"code"`
+	Equivalence string `json:"equivalence"`
+	Comments    string `json:"comments,omitempty"`
+}
+
+type CmElement struct {
+	CodeSystem string     `json:"codeSystem"`
+	Code       string     `json:"code"`
+	Target     []CmTarget `json:"target"`
+}
+
+type ConceptMap struct {
+	Id           string      `json:"id"`
+	ResourceType string      `json:"resourceType"`
+	Url          string      `json:"url,omitempty"`
+	Version      string      `json:"version,omitempty"`
+	Name         string      `json:"name"`
+	Element      []CmElement `json:"element"`
 }
 
 type NsPredicate struct {
@@ -62,9 +109,53 @@ type NsPredicate struct {
 }
 
 type NsFilter struct {
-	Text    string
-	Limit   int
-	Offset  int
-	Include [][]NsPredicate
-	Exclude [][]NsPredicate
+	Text            string
+	DisplayLanguage string
+	Limit           int
+	Offset          int
+	Include         [][]NsPredicate
+	Exclude         [][]NsPredicate
+}
+
+type Coding struct {
+	System  string `json:"system,omitempty"`
+	Code    string `json:"code,omitempty"`
+	Display string `json:"display,omitempty"`
+}
+
+type Parameter struct {
+	Name         string      `json:"name"`
+	ValueString  string      `json:"valueString,omitempty"`
+	ValueId      string      `json:"valueId,omitempty"`
+	ValueCode    string      `json:"valueCode,omitempty"`
+	ValueUri     string      `json:"valueUri,omitempty"`
+	ValueBoolean *bool       `json:"valueBoolean,omitempty"`
+	ValueInteger *int        `json:"valueInteger,omitempty"`
+	ValueCoding  *Coding     `json:"valueCoding,omitempty"`
+	Part         []Parameter `json:"part,omitempty"`
+	// resource of any type, decoded by operation
+	Resource json.RawMessage `json:"resource,omitempty"`
+}
+
+type Parameters struct {
+	ResourceType string      `json:"resourceType"`
+	Parameter    []Parameter `json:"parameter"`
+}
+
+type NsDesignation struct {
+	Language string
+	Value    string
+}
+
+type NsProperty struct {
+	Code  string
+	Value string
+}
+
+type NsConcept struct {
+	System       string
+	Code         string
+	Display      string
+	Designations []NsDesignation
+	Properties   []NsProperty
 }
@@ -0,0 +1,477 @@
+package fhirterm
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const UcumUrl = "http://unitsofmeasure.org"
+
+// Prefix and atom tables are generated from UCUM definitions,
+// ucum-essence.xml can be replaced with newer release from
+// unitsofmeasure.org and tables regenerated
+//go:generate go run ucum_gen.go -o ucum_essence.go ucum-essence.xml
+
+type ucumPrefix struct {
+	name  string
+	value float64
+}
+
+// Atom is defined as value times UCUM expression. Base atoms have
+// empty definition, as well as special (non-ratio) and arbitrary units
+// which can not be converted and are kept in canonical form as is.
+type ucumAtom struct {
+	name       string
+	metric     bool
+	value      float64
+	definition string
+}
+
+// essence names powers of ten "the number ten for arbitrary powers"
+var ucumAtomNames = map[string]string{"10*": "10", "10^": "10"}
+
+// base units in canonical form go in this order, then special units
+var ucumBaseOrder = map[string]int{"m": 0, "s": 1, "g": 2, "rad": 3, "K": 4, "C": 5, "cd": 6}
+
+type ucumUnitsOrder []string
+
+func (a ucumUnitsOrder) Len() int      { return len(a) }
+func (a ucumUnitsOrder) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a ucumUnitsOrder) Less(i, j int) bool {
+	oi, iBase := ucumBaseOrder[a[i]]
+	oj, jBase := ucumBaseOrder[a[j]]
+
+	if iBase && jBase {
+		return oi < oj
+	} else if iBase != jBase {
+		return iBase
+	}
+
+	return a[i] < a[j]
+}
+
+var ucumExponentRegexp = regexp.MustCompile("^(.*?[^0-9+-])([+-]?[0-9]+)$")
+var ucumDigitsRegexp = regexp.MustCompile("^[0-9]+$")
+
+// Parsed UCUM expression: magnitude and exponents of base units
+// (and of special units which are not converted)
+type UcumUnit struct {
+	Value float64
+	Units map[string]int
+	Name  string
+}
+
+func newUcumUnit(value float64) *UcumUnit {
+	return &UcumUnit{Value: value, Units: make(map[string]int)}
+}
+
+func (u *UcumUnit) multiply(o *UcumUnit, exponent int) {
+	u.Value *= math.Pow(o.Value, float64(exponent))
+
+	for unit, e := range o.Units {
+		u.Units[unit] += e * exponent
+		if u.Units[unit] == 0 {
+			delete(u.Units, unit)
+		}
+	}
+}
+
+// Canonical unit expression in base units, like m-3.g
+func (u *UcumUnit) CanonicalUnit() string {
+	units := make([]string, 0, len(u.Units))
+	for unit := range u.Units {
+		units = append(units, unit)
+	}
+
+	sort.Sort(ucumUnitsOrder(units))
+
+	parts := make([]string, 0, len(units))
+	for _, unit := range units {
+		if u.Units[unit] == 1 {
+			parts = append(parts, unit)
+		} else {
+			parts = append(parts, unit+strconv.Itoa(u.Units[unit]))
+		}
+	}
+
+	if len(parts) == 0 {
+		return "1"
+	}
+
+	return strings.Join(parts, ".")
+}
+
+// Canonical magnitude, rounded to hide floating point noise
+func (u *UcumUnit) CanonicalValue() string {
+	return strconv.FormatFloat(u.Value, 'g', 12, 64)
+}
+
+type ucumParser struct {
+	s   string
+	pos int
+	// guards against cycles in atom definitions
+	depth int
+}
+
+func (p *ucumParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("invalid UCUM expression '%s' at position %d: %s", p.s, p.pos, fmt.Sprintf(format, args...))
+}
+
+func (p *ucumParser) peek() byte {
+	if p.pos < len(p.s) {
+		return p.s[p.pos]
+	}
+
+	return 0
+}
+
+func (p *ucumParser) parseMain() (*UcumUnit, error) {
+	if p.peek() == '/' {
+		p.pos++
+		c, err := p.parseComponent()
+		if err != nil {
+			return nil, err
+		}
+
+		// leading division applies to first component only, like
+		// /[pi].A/m in definition of oersted
+		result := newUcumUnit(1)
+		result.multiply(c, -1)
+		result.Name = "per " + c.Name
+		return p.parseTermRest(result)
+	}
+
+	return p.parseTerm()
+}
+
+func (p *ucumParser) parseTerm() (*UcumUnit, error) {
+	result, err := p.parseComponent()
+	if err != nil {
+		return nil, err
+	}
+
+	return p.parseTermRest(result)
+}
+
+func (p *ucumParser) parseTermRest(result *UcumUnit) (*UcumUnit, error) {
+	for p.peek() == '.' || p.peek() == '/' {
+		op := p.peek()
+		p.pos++
+
+		c, err := p.parseComponent()
+		if err != nil {
+			return nil, err
+		}
+
+		if op == '.' {
+			result.multiply(c, 1)
+			result.Name = result.Name + " " + c.Name
+		} else {
+			result.multiply(c, -1)
+			result.Name = result.Name + " per " + c.Name
+		}
+	}
+
+	return result, nil
+}
+
+func (p *ucumParser) parseAnnotation() (string, error) {
+	end := strings.IndexByte(p.s[p.pos:], '}')
+	if end < 0 {
+		return "", p.errorf("unterminated annotation")
+	}
+
+	annotation := p.s[p.pos+1 : p.pos+end]
+	if strings.ContainsAny(annotation, "{") {
+		return "", p.errorf("nested annotation")
+	}
+
+	p.pos += end + 1
+	return annotation, nil
+}
+
+func (p *ucumParser) parseComponent() (*UcumUnit, error) {
+	switch p.peek() {
+	case 0:
+		return nil, p.errorf("unexpected end of expression")
+	case '(':
+		p.pos++
+		term, err := p.parseTerm()
+		if err != nil {
+			return nil, err
+		}
+
+		if p.peek() != ')' {
+			return nil, p.errorf("expected ')'")
+		}
+
+		p.pos++
+		term.Name = "(" + term.Name + ")"
+		return term, nil
+	case '{':
+		annotation, err := p.parseAnnotation()
+		if err != nil {
+			return nil, err
+		}
+
+		result := newUcumUnit(1)
+		result.Name = annotation
+		return result, nil
+	}
+
+	start := p.pos
+	for p.pos < len(p.s) {
+		c := p.s[p.pos]
+
+		if c == '[' {
+			end := strings.IndexByte(p.s[p.pos:], ']')
+			if end < 0 {
+				return nil, p.errorf("unterminated '['")
+			}
+
+			p.pos += end + 1
+			continue
+		}
+
+		if c == '.' || c == '/' || c == '(' || c == ')' || c == '{' {
+			break
+		}
+
+		if c <= ' ' || c > '~' {
+			return nil, p.errorf("invalid character '%c'", c)
+		}
+
+		p.pos++
+	}
+
+	symbol := p.s[start:p.pos]
+	if symbol == "" {
+		return nil, p.errorf("expected unit")
+	}
+
+	var result *UcumUnit
+
+	if ucumDigitsRegexp.MatchString(symbol) {
+		factor, err := strconv.ParseFloat(symbol, 64)
+		if err != nil {
+			return nil, p.errorf("invalid factor %s", symbol)
+		}
+
+		result = newUcumUnit(factor)
+		result.Name = symbol
+	} else {
+		exponent := 1
+		if m := ucumExponentRegexp.FindStringSubmatch(symbol); m != nil {
+			if _, isAtom := p.lookupSimpleUnit(symbol); !isAtom {
+				symbol = m[1]
+				exponent, _ = strconv.Atoi(m[2])
+			}
+		}
+
+		unit, err := p.simpleUnit(symbol)
+		if err != nil {
+			return nil, err
+		}
+
+		result = newUcumUnit(1)
+		result.multiply(unit, exponent)
+		result.Name = unit.Name
+
+		if exponent != 1 {
+			result.Name = fmt.Sprintf("%s^%d", unit.Name, exponent)
+		}
+	}
+
+	// annotation right after unit only adds to its name
+	if p.peek() == '{' {
+		annotation, err := p.parseAnnotation()
+		if err != nil {
+			return nil, err
+		}
+
+		result.Name = result.Name + " " + annotation
+	}
+
+	return result, nil
+}
+
+// Resolves atom with optional prefix, exact atom match wins
+// (cd is candela, not centi-day)
+func (p *ucumParser) lookupSimpleUnit(symbol string) (string, bool) {
+	if _, found := ucumAtoms[symbol]; found {
+		return "", true
+	}
+
+	// two-letter prefix (da) is tried before one-letter ones
+	for _, length := range []int{2, 1} {
+		if len(symbol) <= length {
+			continue
+		}
+
+		prefix := symbol[0:length]
+		if _, isPrefix := ucumPrefixes[prefix]; isPrefix {
+			atom, found := ucumAtoms[strings.TrimPrefix(symbol, prefix)]
+			if found && atom.metric {
+				return prefix, true
+			}
+		}
+	}
+
+	return "", false
+}
+
+func (p *ucumParser) simpleUnit(symbol string) (*UcumUnit, error) {
+	prefixCode, found := p.lookupSimpleUnit(symbol)
+	if !found {
+		return nil, p.errorf("unknown unit '%s'", symbol)
+	}
+
+	atomCode := strings.TrimPrefix(symbol, prefixCode)
+	atom := ucumAtoms[atomCode]
+	result := newUcumUnit(1)
+
+	if atom.definition == "" {
+		result.Units[atomCode] = 1
+	} else {
+		if p.depth > 10 {
+			return nil, p.errorf("too deep definition of '%s'", atomCode)
+		}
+
+		definition := &ucumParser{s: atom.definition, depth: p.depth + 1}
+		defined, err := definition.parseMain()
+		if err != nil {
+			return nil, err
+		}
+
+		result.multiply(defined, 1)
+		result.Value *= atom.value
+	}
+
+	result.Name = atom.name
+	if name, found := ucumAtomNames[atomCode]; found {
+		result.Name = name
+	}
+
+	if prefixCode != "" {
+		prefix := ucumPrefixes[prefixCode]
+		result.Value *= prefix.value
+		result.Name = prefix.name + result.Name
+	}
+
+	return result, nil
+}
+
+// Parses UCUM expression (case sensitive variant) and converts it
+// to base units, like mg/dL => 10 m-3.g
+func ParseUcum(s string) (*UcumUnit, error) {
+	p := &ucumParser{s: s}
+	result, err := p.parseMain()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.pos < len(s) {
+		return nil, p.errorf("unexpected '%c'", s[p.pos])
+	}
+
+	return result, nil
+}
+
+// UCUM is not enumerable, so only value sets listing concepts
+// explicitly can be expanded
+type UcumNamespace struct{}
+
+func NewUcumNamespace() *UcumNamespace {
+	return &UcumNamespace{}
+}
+
+func (ns *UcumNamespace) Url() string {
+	return UcumUrl
+}
+
+func (ns *UcumNamespace) ValidateCode(code string) error {
+	_, err := ParseUcum(code)
+	return err
+}
+
+func (ns *UcumNamespace) Lookup(code string, displayLanguage string) (*NsConcept, error) {
+	unit, err := ParseUcum(code)
+	if err != nil {
+		return nil, nil
+	}
+
+	return &NsConcept{
+		System:  UcumUrl,
+		Code:    code,
+		Display: unit.Name,
+		Properties: []NsProperty{
+			{Code: "canonical-unit", Value: unit.CanonicalUnit()},
+			{Code: "canonical-value", Value: unit.CanonicalValue()},
+		},
+	}, nil
+}
+
+func (ns *UcumNamespace) listedConcepts(group []NsPredicate) ([]VsExpansionContains, error) {
+	if len(group) != 1 || group[0].Property != "concept" || (group[0].Op != "in" && group[0].Op != "=") {
+		return nil, fmt.Errorf("UCUM code system is not enumerable, only explicit concept lists are supported")
+	}
+
+	concepts := group[0].Concepts
+	if concepts == nil {
+		for _, code := range strings.Split(group[0].Value, ",") {
+			concepts = append(concepts, VsComposeIncludeConcept{Code: strings.TrimSpace(code)})
+		}
+	}
+
+	result := make([]VsExpansionContains, 0, len(concepts))
+	for _, c := range concepts {
+		unit, err := ParseUcum(c.Code)
+		if err != nil {
+			return nil, err
+		}
+
+		display := c.Display
+		if display == "" {
+			display = unit.Name
+		}
+
+		result = append(result, VsExpansionContains{System: UcumUrl, Code: c.Code, Display: display})
+	}
+
+	return result, nil
+}
+
+func (ns *UcumNamespace) Filter(f *NsFilter) ([]VsExpansionContains, error) {
+	excluded := make(map[string]bool)
+	for _, group := range f.Exclude {
+		contains, err := ns.listedConcepts(group)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, c := range contains {
+			excluded[c.Code] = true
+		}
+	}
+
+	result := make([]VsExpansionContains, 0)
+	for _, group := range f.Include {
+		contains, err := ns.listedConcepts(group)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, c := range contains {
+			if !excluded[c.Code] {
+				excluded[c.Code] = true
+				result = append(result, c)
+			}
+		}
+	}
+
+	return filterContainsByText(result, f.Text), nil
+}
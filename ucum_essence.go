@@ -0,0 +1,342 @@
+// Code generated by ucum_gen.go from ucum-essence.xml (UCUM 2.1). DO NOT EDIT.
+
+package fhirterm
+
+var ucumPrefixes = map[string]ucumPrefix{
+	"Y":  {"yotta", 1e24},
+	"Z":  {"zetta", 1e21},
+	"E":  {"exa", 1e18},
+	"P":  {"peta", 1e15},
+	"T":  {"tera", 1e12},
+	"G":  {"giga", 1e9},
+	"M":  {"mega", 1e6},
+	"k":  {"kilo", 1e3},
+	"h":  {"hecto", 1e2},
+	"da": {"deka", 1e1},
+	"d":  {"deci", 1e-1},
+	"c":  {"centi", 1e-2},
+	"m":  {"milli", 1e-3},
+	"u":  {"micro", 1e-6},
+	"n":  {"nano", 1e-9},
+	"p":  {"pico", 1e-12},
+	"f":  {"femto", 1e-15},
+	"a":  {"atto", 1e-18},
+	"z":  {"zepto", 1e-21},
+	"y":  {"yocto", 1e-24},
+	"Ki": {"kibi", 1024},
+	"Mi": {"mebi", 1048576},
+	"Gi": {"gibi", 1073741824},
+	"Ti": {"tebi", 1099511627776},
+}
+
+var ucumAtoms = map[string]ucumAtom{
+	"m":               {name: "meter", metric: true},
+	"s":               {name: "second", metric: true},
+	"g":               {name: "gram", metric: true},
+	"rad":             {name: "radian", metric: true},
+	"K":               {name: "kelvin", metric: true},
+	"C":               {name: "coulomb", metric: true},
+	"cd":              {name: "candela", metric: true},
+	"10*":             {name: "the number ten for arbitrary powers", metric: false, value: 10, definition: "1"},
+	"10^":             {name: "the number ten for arbitrary powers", metric: false, value: 10, definition: "1"},
+	"[pi]":            {name: "the number pi", metric: false, value: 3.1415926535897932384626433832795028841971693993751058209749445923, definition: "1"},
+	"%":               {name: "percent", metric: false, value: 1, definition: "10*-2"},
+	"[ppth]":          {name: "parts per thousand", metric: false, value: 1, definition: "10*-3"},
+	"[ppm]":           {name: "parts per million", metric: false, value: 1, definition: "10*-6"},
+	"[ppb]":           {name: "parts per billion", metric: false, value: 1, definition: "10*-9"},
+	"[pptr]":          {name: "parts per trillion", metric: false, value: 1, definition: "10*-12"},
+	"mol":             {name: "mole", metric: true, value: 6.0221367, definition: "10*23"},
+	"sr":              {name: "steradian", metric: true, value: 1, definition: "rad2"},
+	"Hz":              {name: "hertz", metric: true, value: 1, definition: "s-1"},
+	"N":               {name: "newton", metric: true, value: 1, definition: "kg.m/s2"},
+	"Pa":              {name: "pascal", metric: true, value: 1, definition: "N/m2"},
+	"J":               {name: "joule", metric: true, value: 1, definition: "N.m"},
+	"W":               {name: "watt", metric: true, value: 1, definition: "J/s"},
+	"A":               {name: "ampere", metric: true, value: 1, definition: "C/s"},
+	"V":               {name: "volt", metric: true, value: 1, definition: "J/C"},
+	"F":               {name: "farad", metric: true, value: 1, definition: "C/V"},
+	"Ohm":             {name: "ohm", metric: true, value: 1, definition: "V/A"},
+	"S":               {name: "siemens", metric: true, value: 1, definition: "Ohm-1"},
+	"Wb":              {name: "weber", metric: true, value: 1, definition: "V.s"},
+	"Cel":             {name: "degree Celsius", metric: true},
+	"T":               {name: "tesla", metric: true, value: 1, definition: "Wb/m2"},
+	"H":               {name: "henry", metric: true, value: 1, definition: "Wb/A"},
+	"lm":              {name: "lumen", metric: true, value: 1, definition: "cd.sr"},
+	"lx":              {name: "lux", metric: true, value: 1, definition: "lm/m2"},
+	"Bq":              {name: "becquerel", metric: true, value: 1, definition: "s-1"},
+	"Gy":              {name: "gray", metric: true, value: 1, definition: "J/kg"},
+	"Sv":              {name: "sievert", metric: true, value: 1, definition: "J/kg"},
+	"gon":             {name: "gon", metric: false, value: 0.9, definition: "deg"},
+	"deg":             {name: "degree", metric: false, value: 2, definition: "[pi].rad/360"},
+	"'":               {name: "minute", metric: false, value: 1, definition: "deg/60"},
+	"''":              {name: "second", metric: false, value: 1, definition: "'/60"},
+	"l":               {name: "liter", metric: true, value: 1, definition: "dm3"},
+	"L":               {name: "liter", metric: true, value: 1, definition: "l"},
+	"ar":              {name: "are", metric: true, value: 100, definition: "m2"},
+	"min":             {name: "minute", metric: false, value: 60, definition: "s"},
+	"h":               {name: "hour", metric: false, value: 60, definition: "min"},
+	"d":               {name: "day", metric: false, value: 24, definition: "h"},
+	"a_t":             {name: "tropical year", metric: false, value: 365.24219, definition: "d"},
+	"a_j":             {name: "mean Julian year", metric: false, value: 365.25, definition: "d"},
+	"a_g":             {name: "mean Gregorian year", metric: false, value: 365.2425, definition: "d"},
+	"a":               {name: "year", metric: false, value: 1, definition: "a_j"},
+	"wk":              {name: "week", metric: false, value: 7, definition: "d"},
+	"mo_s":            {name: "synodal month", metric: false, value: 29.53059, definition: "d"},
+	"mo_j":            {name: "mean Julian month", metric: false, value: 1, definition: "a_j/12"},
+	"mo_g":            {name: "mean Gregorian month", metric: false, value: 1, definition: "a_g/12"},
+	"mo":              {name: "month", metric: false, value: 1, definition: "mo_j"},
+	"t":               {name: "tonne", metric: true, value: 1e3, definition: "kg"},
+	"bar":             {name: "bar", metric: true, value: 1e5, definition: "Pa"},
+	"u":               {name: "unified atomic mass unit", metric: true, value: 1.6605402e-24, definition: "g"},
+	"eV":              {name: "electronvolt", metric: true, value: 1, definition: "[e].V"},
+	"AU":              {name: "astronomic unit", metric: false, value: 149597.870691, definition: "Mm"},
+	"pc":              {name: "parsec", metric: true, value: 3.085678e16, definition: "m"},
+	"[c]":             {name: "velocity of light", metric: true, value: 299792458, definition: "m/s"},
+	"[h]":             {name: "Planck constant", metric: true, value: 6.6260755e-34, definition: "J.s"},
+	"[k]":             {name: "Boltzmann constant", metric: true, value: 1.380658e-23, definition: "J/K"},
+	"[eps_0]":         {name: "permittivity of vacuum", metric: true, value: 8.854187817e-12, definition: "F/m"},
+	"[mu_0]":          {name: "permeability of vacuum", metric: true, value: 1, definition: "4.[pi].10*-7.N/A2"},
+	"[e]":             {name: "elementary charge", metric: true, value: 1.60217733e-19, definition: "C"},
+	"[m_e]":           {name: "electron mass", metric: true, value: 9.1093897e-28, definition: "g"},
+	"[m_p]":           {name: "proton mass", metric: true, value: 1.6726231e-24, definition: "g"},
+	"[G]":             {name: "Newtonian constant of gravitation", metric: true, value: 6.67259e-11, definition: "m3.kg-1.s-2"},
+	"[g]":             {name: "standard acceleration of free fall", metric: true, value: 9.80665, definition: "m/s2"},
+	"atm":             {name: "standard atmosphere", metric: false, value: 101325, definition: "Pa"},
+	"[ly]":            {name: "light-year", metric: true, value: 1, definition: "[c].a_j"},
+	"gf":              {name: "gram-force", metric: true, value: 1, definition: "g.[g]"},
+	"[lbf_av]":        {name: "pound force", metric: false, value: 1, definition: "[lb_av].[g]"},
+	"Ky":              {name: "Kayser", metric: true, value: 1, definition: "cm-1"},
+	"Gal":             {name: "Gal", metric: true, value: 1, definition: "cm/s2"},
+	"dyn":             {name: "dyne", metric: true, value: 1, definition: "g.cm/s2"},
+	"erg":             {name: "erg", metric: true, value: 1, definition: "dyn.cm"},
+	"P":               {name: "Poise", metric: true, value: 1, definition: "dyn.s/cm2"},
+	"St":              {name: "Stokes", metric: true, value: 1, definition: "cm2/s"},
+	"Mx":              {name: "Maxwell", metric: true, value: 1e-8, definition: "Wb"},
+	"G":               {name: "Gauss", metric: true, value: 1e-4, definition: "T"},
+	"Oe":              {name: "Oersted", metric: true, value: 250, definition: "/[pi].A/m"},
+	"Gb":              {name: "Gilbert", metric: true, value: 1, definition: "Oe.cm"},
+	"sb":              {name: "stilb", metric: true, value: 1, definition: "cd/cm2"},
+	"Lmb":             {name: "Lambert", metric: true, value: 1, definition: "cd/cm2/[pi]"},
+	"ph":              {name: "phot", metric: true, value: 1e-4, definition: "lx"},
+	"Ci":              {name: "Curie", metric: true, value: 37e9, definition: "Bq"},
+	"R":               {name: "Roentgen", metric: true, value: 2.58e-4, definition: "C/kg"},
+	"RAD":             {name: "radiation absorbed dose", metric: true, value: 100, definition: "erg/g"},
+	"REM":             {name: "radiation equivalent man", metric: true, value: 1, definition: "RAD"},
+	"[in_i]":          {name: "inch", metric: false, value: 2.54, definition: "cm"},
+	"[ft_i]":          {name: "foot", metric: false, value: 12, definition: "[in_i]"},
+	"[yd_i]":          {name: "yard", metric: false, value: 3, definition: "[ft_i]"},
+	"[mi_i]":          {name: "mile", metric: false, value: 5280, definition: "[ft_i]"},
+	"[fth_i]":         {name: "fathom", metric: false, value: 6, definition: "[ft_i]"},
+	"[nmi_i]":         {name: "nautical mile", metric: false, value: 1852, definition: "m"},
+	"[kn_i]":          {name: "knot", metric: false, value: 1, definition: "[nmi_i]/h"},
+	"[sin_i]":         {name: "square inch", metric: false, value: 1, definition: "[in_i]2"},
+	"[sft_i]":         {name: "square foot", metric: false, value: 1, definition: "[ft_i]2"},
+	"[syd_i]":         {name: "square yard", metric: false, value: 1, definition: "[yd_i]2"},
+	"[cin_i]":         {name: "cubic inch", metric: false, value: 1, definition: "[in_i]3"},
+	"[cft_i]":         {name: "cubic foot", metric: false, value: 1, definition: "[ft_i]3"},
+	"[cyd_i]":         {name: "cubic yard", metric: false, value: 1, definition: "[yd_i]3"},
+	"[bf_i]":          {name: "board foot", metric: false, value: 144, definition: "[in_i]3"},
+	"[cr_i]":          {name: "cord", metric: false, value: 128, definition: "[ft_i]3"},
+	"[mil_i]":         {name: "mil", metric: false, value: 1e-3, definition: "[in_i]"},
+	"[cml_i]":         {name: "circular mil", metric: false, value: 1, definition: "[pi]/4.[mil_i]2"},
+	"[hd_i]":          {name: "hand", metric: false, value: 4, definition: "[in_i]"},
+	"[ft_us]":         {name: "foot", metric: false, value: 1200, definition: "m/3937"},
+	"[yd_us]":         {name: "yard", metric: false, value: 3, definition: "[ft_us]"},
+	"[in_us]":         {name: "inch", metric: false, value: 1, definition: "[ft_us]/12"},
+	"[rd_us]":         {name: "rod", metric: false, value: 16.5, definition: "[ft_us]"},
+	"[ch_us]":         {name: "Gunter's chain", metric: false, value: 4, definition: "[rd_us]"},
+	"[lk_us]":         {name: "link for Gunter's chain", metric: false, value: 1, definition: "[ch_us]/100"},
+	"[rch_us]":        {name: "Ramden's chain", metric: false, value: 100, definition: "[ft_us]"},
+	"[rlk_us]":        {name: "link for Ramden's chain", metric: false, value: 1, definition: "[rch_us]/100"},
+	"[fth_us]":        {name: "fathom", metric: false, value: 6, definition: "[ft_us]"},
+	"[fur_us]":        {name: "furlong", metric: false, value: 40, definition: "[rd_us]"},
+	"[mi_us]":         {name: "mile", metric: false, value: 8, definition: "[fur_us]"},
+	"[acr_us]":        {name: "acre", metric: false, value: 160, definition: "[rd_us]2"},
+	"[srd_us]":        {name: "square rod", metric: false, value: 1, definition: "[rd_us]2"},
+	"[smi_us]":        {name: "square mile", metric: false, value: 1, definition: "[mi_us]2"},
+	"[sct]":           {name: "section", metric: false, value: 1, definition: "[mi_us]2"},
+	"[twp]":           {name: "township", metric: false, value: 36, definition: "[sct]"},
+	"[mil_us]":        {name: "mil", metric: false, value: 1e-3, definition: "[in_us]"},
+	"[in_br]":         {name: "inch", metric: false, value: 2.539998, definition: "cm"},
+	"[ft_br]":         {name: "foot", metric: false, value: 12, definition: "[in_br]"},
+	"[rd_br]":         {name: "rod", metric: false, value: 16.5, definition: "[ft_br]"},
+	"[ch_br]":         {name: "Gunter's chain", metric: false, value: 4, definition: "[rd_br]"},
+	"[lk_br]":         {name: "link for Gunter's chain", metric: false, value: 1, definition: "[ch_br]/100"},
+	"[fth_br]":        {name: "fathom", metric: false, value: 6, definition: "[ft_br]"},
+	"[pc_br]":         {name: "pace", metric: false, value: 2.5, definition: "[ft_br]"},
+	"[yd_br]":         {name: "yard", metric: false, value: 3, definition: "[ft_br]"},
+	"[mi_br]":         {name: "mile", metric: false, value: 5280, definition: "[ft_br]"},
+	"[nmi_br]":        {name: "nautical mile", metric: false, value: 6080, definition: "[ft_br]"},
+	"[kn_br]":         {name: "knot", metric: false, value: 1, definition: "[nmi_br]/h"},
+	"[acr_br]":        {name: "acre", metric: false, value: 4840, definition: "[yd_br]2"},
+	"[gal_us]":        {name: "Queen Anne's wine gallon", metric: false, value: 231, definition: "[in_i]3"},
+	"[bbl_us]":        {name: "barrel", metric: false, value: 42, definition: "[gal_us]"},
+	"[qt_us]":         {name: "quart", metric: false, value: 1, definition: "[gal_us]/4"},
+	"[pt_us]":         {name: "pint", metric: false, value: 1, definition: "[qt_us]/2"},
+	"[gil_us]":        {name: "gill", metric: false, value: 1, definition: "[pt_us]/4"},
+	"[foz_us]":        {name: "fluid ounce", metric: false, value: 1, definition: "[gil_us]/4"},
+	"[fdr_us]":        {name: "fluid dram", metric: false, value: 1, definition: "[foz_us]/8"},
+	"[min_us]":        {name: "minim", metric: false, value: 1, definition: "[fdr_us]/60"},
+	"[crd_us]":        {name: "cord", metric: false, value: 128, definition: "[ft_i]3"},
+	"[bu_us]":         {name: "bushel", metric: false, value: 2150.42, definition: "[in_i]3"},
+	"[gal_wi]":        {name: "historical winchester gallon", metric: false, value: 1, definition: "[bu_us]/8"},
+	"[pk_us]":         {name: "peck", metric: false, value: 1, definition: "[bu_us]/4"},
+	"[dqt_us]":        {name: "dry quart", metric: false, value: 1, definition: "[pk_us]/8"},
+	"[dpt_us]":        {name: "dry pint", metric: false, value: 1, definition: "[dqt_us]/2"},
+	"[tbs_us]":        {name: "tablespoon", metric: false, value: 1, definition: "[foz_us]/2"},
+	"[tsp_us]":        {name: "teaspoon", metric: false, value: 1, definition: "[tbs_us]/3"},
+	"[cup_us]":        {name: "cup", metric: false, value: 16, definition: "[tbs_us]"},
+	"[foz_m]":         {name: "metric fluid ounce", metric: false, value: 30, definition: "mL"},
+	"[cup_m]":         {name: "metric cup", metric: false, value: 240, definition: "mL"},
+	"[tsp_m]":         {name: "metric teaspoon", metric: false, value: 5, definition: "mL"},
+	"[tbs_m]":         {name: "metric tablespoon", metric: false, value: 15, definition: "mL"},
+	"[gal_br]":        {name: "gallon", metric: false, value: 4.54609, definition: "l"},
+	"[pk_br]":         {name: "peck", metric: false, value: 2, definition: "[gal_br]"},
+	"[bu_br]":         {name: "bushel", metric: false, value: 4, definition: "[pk_br]"},
+	"[qt_br]":         {name: "quart", metric: false, value: 1, definition: "[gal_br]/4"},
+	"[pt_br]":         {name: "pint", metric: false, value: 1, definition: "[qt_br]/2"},
+	"[gil_br]":        {name: "gill", metric: false, value: 1, definition: "[pt_br]/4"},
+	"[foz_br]":        {name: "fluid ounce", metric: false, value: 1, definition: "[gil_br]/5"},
+	"[fdr_br]":        {name: "fluid dram", metric: false, value: 1, definition: "[foz_br]/8"},
+	"[min_br]":        {name: "minim", metric: false, value: 1, definition: "[fdr_br]/60"},
+	"[gr]":            {name: "grain", metric: false, value: 64.79891, definition: "mg"},
+	"[lb_av]":         {name: "pound", metric: false, value: 7000, definition: "[gr]"},
+	"[oz_av]":         {name: "ounce", metric: false, value: 1, definition: "[lb_av]/16"},
+	"[dr_av]":         {name: "dram", metric: false, value: 1, definition: "[oz_av]/16"},
+	"[scwt_av]":       {name: "short hundredweight", metric: false, value: 100, definition: "[lb_av]"},
+	"[lcwt_av]":       {name: "long hunderdweight", metric: false, value: 112, definition: "[lb_av]"},
+	"[ston_av]":       {name: "short ton", metric: false, value: 20, definition: "[scwt_av]"},
+	"[lton_av]":       {name: "long ton", metric: false, value: 20, definition: "[lcwt_av]"},
+	"[stone_av]":      {name: "stone", metric: false, value: 14, definition: "[lb_av]"},
+	"[pwt_tr]":        {name: "pennyweight", metric: false, value: 24, definition: "[gr]"},
+	"[oz_tr]":         {name: "ounce", metric: false, value: 20, definition: "[pwt_tr]"},
+	"[lb_tr]":         {name: "pound", metric: false, value: 12, definition: "[oz_tr]"},
+	"[sc_ap]":         {name: "scruple", metric: false, value: 20, definition: "[gr]"},
+	"[dr_ap]":         {name: "dram", metric: false, value: 3, definition: "[sc_ap]"},
+	"[oz_ap]":         {name: "ounce", metric: false, value: 8, definition: "[dr_ap]"},
+	"[lb_ap]":         {name: "pound", metric: false, value: 12, definition: "[oz_ap]"},
+	"[oz_m]":          {name: "metric ounce", metric: false, value: 28, definition: "g"},
+	"[lne]":           {name: "line", metric: false, value: 1, definition: "[in_i]/12"},
+	"[pnt]":           {name: "point", metric: false, value: 1, definition: "[lne]/6"},
+	"[pca]":           {name: "pica", metric: false, value: 12, definition: "[pnt]"},
+	"[pnt_pr]":        {name: "Printer's point", metric: false, value: 0.013837, definition: "[in_i]"},
+	"[pca_pr]":        {name: "Printer's pica", metric: false, value: 12, definition: "[pnt_pr]"},
+	"[pied]":          {name: "pied", metric: false, value: 32.48, definition: "cm"},
+	"[pouce]":         {name: "pouce", metric: false, value: 1, definition: "[pied]/12"},
+	"[ligne]":         {name: "ligne", metric: false, value: 1, definition: "[pouce]/12"},
+	"[didot]":         {name: "didot", metric: false, value: 1, definition: "[ligne]/6"},
+	"[cicero]":        {name: "cicero", metric: false, value: 12, definition: "[didot]"},
+	"[degF]":          {name: "degree Fahrenheit", metric: false},
+	"[degR]":          {name: "degree Rankine", metric: false, value: 5, definition: "K/9"},
+	"[degRe]":         {name: "degree Reaumur", metric: false},
+	"cal_[15]":        {name: "calorie at 15 °C", metric: true, value: 4.18580, definition: "J"},
+	"cal_[20]":        {name: "calorie at 20 °C", metric: true, value: 4.18190, definition: "J"},
+	"cal_m":           {name: "mean calorie", metric: true, value: 4.19002, definition: "J"},
+	"cal_IT":          {name: "international table calorie", metric: true, value: 4.1868, definition: "J"},
+	"cal_th":          {name: "thermochemical calorie", metric: true, value: 4.184, definition: "J"},
+	"cal":             {name: "calorie", metric: true, value: 1, definition: "cal_th"},
+	"[Cal]":           {name: "nutrition label Calories", metric: false, value: 1, definition: "kcal_th"},
+	"[Btu_39]":        {name: "British thermal unit at 39 °F", metric: false, value: 1.05967, definition: "kJ"},
+	"[Btu_59]":        {name: "British thermal unit at 59 °F", metric: false, value: 1.05480, definition: "kJ"},
+	"[Btu_60]":        {name: "British thermal unit at 60 °F", metric: false, value: 1.05468, definition: "kJ"},
+	"[Btu_m]":         {name: "mean British thermal unit", metric: false, value: 1.05587, definition: "kJ"},
+	"[Btu_IT]":        {name: "international table British thermal unit", metric: false, value: 1.05505585262, definition: "kJ"},
+	"[Btu_th]":        {name: "thermochemical British thermal unit", metric: false, value: 1.054350, definition: "kJ"},
+	"[Btu]":           {name: "British thermal unit", metric: false, value: 1, definition: "[Btu_th]"},
+	"[HP]":            {name: "horsepower", metric: false, value: 550, definition: "[ft_i].[lbf_av]/s"},
+	"tex":             {name: "tex", metric: true, value: 1, definition: "g/km"},
+	"[den]":           {name: "Denier", metric: false, value: 1, definition: "g/9/km"},
+	"m[H2O]":          {name: "meter of water column", metric: true, value: 980665e-5, definition: "kPa"},
+	"m[Hg]":           {name: "meter of mercury column", metric: true, value: 133.3220, definition: "kPa"},
+	"[in_i'H2O]":      {name: "inch of water column", metric: false, value: 1, definition: "m[H2O].[in_i]/m"},
+	"[in_i'Hg]":       {name: "inch of mercury column", metric: false, value: 1, definition: "m[Hg].[in_i]/m"},
+	"[PRU]":           {name: "peripheral vascular resistance unit", metric: false, value: 1, definition: "mm[Hg].s/ml"},
+	"[wood'U]":        {name: "Wood unit", metric: false, value: 1, definition: "mm[Hg].min/L"},
+	"[diop]":          {name: "diopter", metric: false, value: 1, definition: "/m"},
+	"[p'diop]":        {name: "prism diopter", metric: false},
+	"%[slope]":        {name: "percent of slope", metric: false},
+	"[mesh_i]":        {name: "mesh", metric: false, value: 1, definition: "/[in_i]"},
+	"[Ch]":            {name: "Charrière", metric: false, value: 1, definition: "mm/3"},
+	"[drp]":           {name: "drop", metric: false, value: 1, definition: "ml/20"},
+	"[hnsf'U]":        {name: "Hounsfield unit", metric: false},
+	"[MET]":           {name: "metabolic equivalent", metric: false, value: 3.5, definition: "mL/min/kg"},
+	"[hp'_X]":         {name: "homeopathic potency of decimal series (retired)", metric: false},
+	"[hp'_C]":         {name: "homeopathic potency of centesimal series (retired)", metric: false},
+	"[hp'_M]":         {name: "homeopathic potency of millesimal series (retired)", metric: false},
+	"[hp'_Q]":         {name: "homeopathic potency of quintamillesimal series (retired)", metric: false},
+	"[hp_X]":          {name: "homeopathic potency of decimal hahnemannian series", metric: false},
+	"[hp_C]":          {name: "homeopathic potency of centesimal hahnemannian series", metric: false},
+	"[hp_M]":          {name: "homeopathic potency of millesimal hahnemannian series", metric: false},
+	"[hp_Q]":          {name: "homeopathic potency of quintamillesimal hahnemannian series", metric: false},
+	"[kp_X]":          {name: "homeopathic potency of decimal korsakovian series", metric: false},
+	"[kp_C]":          {name: "homeopathic potency of centesimal korsakovian series", metric: false},
+	"[kp_M]":          {name: "homeopathic potency of millesimal korsakovian series", metric: false},
+	"[kp_Q]":          {name: "homeopathic potency of quintamillesimal korsakovian series", metric: false},
+	"eq":              {name: "equivalents", metric: true, value: 1, definition: "mol"},
+	"osm":             {name: "osmole", metric: true, value: 1, definition: "mol"},
+	"[pH]":            {name: "pH", metric: false},
+	"g%":              {name: "gram percent", metric: true, value: 1, definition: "g/dl"},
+	"[S]":             {name: "Svedberg unit", metric: false, value: 1, definition: "10*-13.s"},
+	"[HPF]":           {name: "high power field", metric: false, value: 1, definition: "1"},
+	"[LPF]":           {name: "low power field", metric: false, value: 100, definition: "1"},
+	"kat":             {name: "katal", metric: true, value: 1, definition: "mol/s"},
+	"U":               {name: "Unit", metric: true, value: 1, definition: "umol/min"},
+	"[iU]":            {name: "international unit", metric: true},
+	"[IU]":            {name: "international unit", metric: true},
+	"[arb'U]":         {name: "arbitary unit", metric: false},
+	"[USP'U]":         {name: "United States Pharmacopeia unit", metric: false},
+	"[GPL'U]":         {name: "GPL unit", metric: false},
+	"[MPL'U]":         {name: "MPL unit", metric: false},
+	"[APL'U]":         {name: "APL unit", metric: false},
+	"[beth'U]":        {name: "Bethesda unit", metric: false},
+	"[anti'Xa'U]":     {name: "anti factor Xa unit", metric: false},
+	"[todd'U]":        {name: "Todd unit", metric: false},
+	"[dye'U]":         {name: "Dye unit", metric: false},
+	"[smgy'U]":        {name: "Somogyi unit", metric: false},
+	"[bdsk'U]":        {name: "Bodansky unit", metric: false},
+	"[ka'U]":          {name: "King-Armstrong unit", metric: false},
+	"[knk'U]":         {name: "Kunkel unit", metric: false},
+	"[mclg'U]":        {name: "Mac Lagan unit", metric: false},
+	"[tb'U]":          {name: "tuberculin unit", metric: false},
+	"[CCID_50]":       {name: "50% cell culture infectious dose", metric: true},
+	"[TCID_50]":       {name: "50% tissue culture infectious dose", metric: true},
+	"[EID_50]":        {name: "50% embryo infectious dose", metric: true},
+	"[PFU]":           {name: "plaque forming units", metric: true},
+	"[FFU]":           {name: "focus forming units", metric: true},
+	"[CFU]":           {name: "colony forming units", metric: true},
+	"[IR]":            {name: "index of reactivity", metric: true},
+	"[BAU]":           {name: "bioequivalent allergen unit", metric: true},
+	"[AU]":            {name: "allergen unit", metric: true},
+	"[Amb'a'1'U]":     {name: "allergen unit for Ambrosia artemisiifolia", metric: true},
+	"[PNU]":           {name: "protein nitrogen unit", metric: true},
+	"[Lf]":            {name: "Limit of flocculation", metric: true},
+	"[D'ag'U]":        {name: "D-antigen unit", metric: false},
+	"[FEU]":           {name: "fibrinogen equivalent unit", metric: true},
+	"[ELU]":           {name: "ELISA unit", metric: true},
+	"[EU]":            {name: "Ehrlich unit", metric: true},
+	"Np":              {name: "neper", metric: true},
+	"B":               {name: "bel", metric: true},
+	"B[SPL]":          {name: "bel sound pressure", metric: true},
+	"B[V]":            {name: "bel volt", metric: true},
+	"B[mV]":           {name: "bel millivolt", metric: true},
+	"B[uV]":           {name: "bel microvolt", metric: true},
+	"B[10.nV]":        {name: "bel 10 nanovolt", metric: true},
+	"B[W]":            {name: "bel watt", metric: true},
+	"B[kW]":           {name: "bel kilowatt", metric: true},
+	"st":              {name: "stere", metric: true, value: 1, definition: "m3"},
+	"Ao":              {name: "Ångström", metric: false, value: 0.1, definition: "nm"},
+	"b":               {name: "barn", metric: false, value: 100, definition: "fm2"},
+	"att":             {name: "technical atmosphere", metric: false, value: 1, definition: "kgf/cm2"},
+	"mho":             {name: "mho", metric: true, value: 1, definition: "S"},
+	"[psi]":           {name: "pound per sqare inch", metric: false, value: 1, definition: "[lbf_av]/[in_i]2"},
+	"circ":            {name: "circle", metric: false, value: 2, definition: "[pi].rad"},
+	"sph":             {name: "spere", metric: false, value: 4, definition: "[pi].sr"},
+	"[car_m]":         {name: "metric carat", metric: false, value: 2e-1, definition: "g"},
+	"[car_Au]":        {name: "carat of gold alloys", metric: false, value: 1, definition: "/24"},
+	"[smoot]":         {name: "Smoot", metric: false, value: 67, definition: "[in_i]"},
+	"[m/s2/Hz^(1/2)]": {name: "meter per square seconds per square root of hertz", metric: false},
+	"bit_s":           {name: "bit", metric: false},
+	"bit":             {name: "bit", metric: true, value: 1, definition: "1"},
+	"By":              {name: "byte", metric: true, value: 8, definition: "bit"},
+	"Bd":              {name: "baud", metric: true, value: 1, definition: "/s"},
+}
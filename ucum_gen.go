@@ -0,0 +1,126 @@
+//go:build ignore
+// +build ignore
+
+// Generates UCUM prefix and atom tables from ucum-essence.xml:
+//
+//	go run ucum_gen.go -o ucum_essence.go ucum-essence.xml
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"go/format"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+)
+
+type essenceValue struct {
+	Unit  string `xml:"Unit,attr"`
+	Value string `xml:"value,attr"`
+}
+
+type essencePrefix struct {
+	Code  string       `xml:"Code,attr"`
+	Name  string       `xml:"name"`
+	Value essenceValue `xml:"value"`
+}
+
+type essenceUnit struct {
+	Code        string       `xml:"Code,attr"`
+	IsMetric    string       `xml:"isMetric,attr"`
+	IsSpecial   string       `xml:"isSpecial,attr"`
+	IsArbitrary string       `xml:"isArbitrary,attr"`
+	Name        string       `xml:"name"`
+	Value       essenceValue `xml:"value"`
+}
+
+type essence struct {
+	Version   string          `xml:"version,attr"`
+	Prefixes  []essencePrefix `xml:"prefix"`
+	BaseUnits []essenceUnit   `xml:"base-unit"`
+	Units     []essenceUnit   `xml:"unit"`
+}
+
+func parseValue(code, s string) float64 {
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		log.Fatalf("Invalid value of %s: '%s'", code, s)
+	}
+
+	return v
+}
+
+func main() {
+	output := flag.String("o", "ucum_essence.go", "output file")
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		log.Fatalf("Usage: go run ucum_gen.go -o ucum_essence.go ucum-essence.xml")
+	}
+
+	data, err := ioutil.ReadFile(flag.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// official file is declared as ascii, which is its subset
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	decoder.CharsetReader = func(charset string, input io.Reader) (io.Reader, error) {
+		return input, nil
+	}
+
+	var e essence
+	if err := decoder.Decode(&e); err != nil {
+		log.Fatalf("Failed to parse %s: %s", flag.Arg(0), err)
+	}
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "// Code generated by ucum_gen.go from ucum-essence.xml (UCUM %s). DO NOT EDIT.\n\n", e.Version)
+	fmt.Fprintf(&b, "package fhirterm\n\n")
+
+	fmt.Fprintf(&b, "var ucumPrefixes = map[string]ucumPrefix{\n")
+	for _, p := range e.Prefixes {
+		fmt.Fprintf(&b, "%q: {%q, %s},\n", p.Code, p.Name, p.Value.Value)
+		parseValue(p.Code, p.Value.Value)
+	}
+	fmt.Fprintf(&b, "}\n\n")
+
+	fmt.Fprintf(&b, "var ucumAtoms = map[string]ucumAtom{\n")
+	for _, u := range e.BaseUnits {
+		fmt.Fprintf(&b, "%q: {name: %q, metric: true},\n", u.Code, u.Name)
+	}
+
+	for _, u := range e.Units {
+		metric := u.IsMetric == "yes"
+
+		// special and arbitrary units are not converted
+		if u.IsSpecial == "yes" || u.IsArbitrary == "yes" {
+			fmt.Fprintf(&b, "%q: {name: %q, metric: %t},\n", u.Code, u.Name, metric)
+			continue
+		}
+
+		value := strings.TrimSpace(u.Value.Value)
+		parseValue(u.Code, value)
+		fmt.Fprintf(&b, "%q: {name: %q, metric: %t, value: %s, definition: %q},\n",
+			u.Code, u.Name, metric, value, u.Value.Unit)
+	}
+	fmt.Fprintf(&b, "}\n")
+
+	src, err := format.Source(b.Bytes())
+	if err != nil {
+		log.Fatalf("Failed to format generated code: %s", err)
+	}
+
+	if err := ioutil.WriteFile(*output, src, 0644); err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Fprintf(os.Stderr, "Written %d prefixes and %d atoms to %s\n",
+		len(e.Prefixes), len(e.BaseUnits)+len(e.Units), *output)
+}
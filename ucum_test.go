@@ -0,0 +1,69 @@
+package fhirterm
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func Test_UcumCanonicalForm(t *testing.T) {
+	assert := assert.New(t)
+
+	for expr, expected := range map[string][2]string{
+		"mg/dL":      {"10", "m-3.g"},
+		"10*3/uL":    {"1e+12", "m-3"},
+		"mm[Hg]":     {"133322", "m-1.s-2.g"},
+		"kg.m/s2":    {"1000", "m.s-2.g"},
+		"/min":       {"0.0166666666667", "s-1"},
+		"%":          {"0.01", "1"},
+		"mmol/L":     {"6.0221367e+23", "m-3"},
+		"[in_i]":     {"0.0254", "m"},
+		"{cells}/uL": {"1000000000", "m-3"},
+		"mL{total}":  {"1e-06", "m3"},
+		"[IU]/L":     {"1000", "m-3.[IU]"},
+		"Cel":        {"1", "Cel"},
+		"cd":         {"1", "cd"},
+		"(kg.m)/s2":  {"1000", "m.s-2.g"},
+		"g/(24.h)":   {"1.15740740741e-05", "s-1.g"},
+		"daL":        {"0.01", "m3"},
+		"atm":        {"101325000", "m-1.s-2.g"},
+		"Oe":         {"79.5774715459", "m-1.s-1.C"},
+		"KiBy":       {"8192", "1"},
+	} {
+		unit, err := ParseUcum(expr)
+		if assert.Nil(err, "Failed to parse %s", expr) {
+			assert.Equal(expected[0], unit.CanonicalValue(), expr)
+			assert.Equal(expected[1], unit.CanonicalUnit(), expr)
+		}
+	}
+}
+
+func Test_UcumNames(t *testing.T) {
+	assert := assert.New(t)
+
+	unit, _ := ParseUcum("mg/dL")
+	assert.Equal("milligram per deciliter", unit.Name)
+
+	unit, _ = ParseUcum("mm[Hg]")
+	assert.Equal("millimeter of mercury column", unit.Name)
+}
+
+func Test_UcumValidUnits(t *testing.T) {
+	for _, s := range []string{"/[HPF]", "[CFU]/mL", "[ka'U]", "a_j", "mo_j", "atm", "[psi]", "[mi_i]", "[foz_us]", "gf"} {
+		_, err := ParseUcum(s)
+		assert.Nil(t, err, "Expected '%s' to be valid", s)
+	}
+}
+
+func Test_UcumAtomDefinitions(t *testing.T) {
+	for code := range ucumAtoms {
+		_, err := ParseUcum(code)
+		assert.Nil(t, err, "Failed to resolve definition of '%s'", code)
+	}
+}
+
+func Test_UcumSyntaxErrors(t *testing.T) {
+	for _, s := range []string{"", "mg/", "foo", "m g", "(mg", "mg)", "{cells", "[in_i", "k[in_i]", "mg//dL"} {
+		_, err := ParseUcum(s)
+		assert.NotNil(t, err, "Expected error for '%s'", s)
+	}
+}
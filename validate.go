@@ -0,0 +1,151 @@
+package fhirterm
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Namespaces which can explain why a code is invalid (like syntax
+// errors in UCUM expressions) implement this interface
+type CodeValidator interface {
+	ValidateCode(code string) error
+}
+
+// Namespaces keeping codes and displays in memory implement this
+// interface, $validate-code doesn't query database unless display
+// should be compared with designations
+type CodeDisplayer interface {
+	CodeDisplay(code string) (string, bool)
+}
+
+// Implemented by in-memory namespaces, status and replacements of
+// inactive concepts are looked up in database
+type inactiveCodeChecker interface {
+	isInactive(code string) bool
+}
+
+func isInactiveCode(ns Namespace, code string) bool {
+	c, ok := ns.(inactiveCodeChecker)
+	return ok && c.isInactive(code)
+}
+
+// Inactive concepts are valid codes, but $validate-code warns
+// about them (see inactive property of $lookup)
+func inactiveConceptMessage(c *NsConcept) string {
+	for _, p := range c.Properties {
+		if p.Code == "inactive" && p.Value == "true" {
+			return fmt.Sprintf("Code '%s' is inactive in code system %s", c.Code, c.System)
+		}
+	}
+
+	return ""
+}
+
+func validationResult(result bool, message string, display string) *Parameters {
+	params := []Parameter{Parameter{Name: "result", ValueBoolean: &result}}
+
+	if message != "" {
+		params = append(params, Parameter{Name: "message", ValueString: message})
+	}
+
+	if display != "" {
+		params = append(params, Parameter{Name: "display", ValueString: display})
+	}
+
+	return &Parameters{
+		ResourceType: "Parameters",
+		Parameter:    params,
+	}
+}
+
+// Empty display always matches, otherwise it is compared with
+// display and designations ignoring case
+func displayMatches(display string, c *NsConcept) bool {
+	if display == "" || strings.EqualFold(display, c.Display) {
+		return true
+	}
+
+	for _, d := range c.Designations {
+		if strings.EqualFold(display, d.Value) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (s *Server) ValidateCode(system string, code string, display string) (*Parameters, error) {
+	ns, found := s.Registry.FindNamespace(system)
+	if !found {
+		return nil, fmt.Errorf("unknown code system: %s", system)
+	}
+
+	if v, ok := ns.(CodeValidator); ok {
+		if err := v.ValidateCode(code); err != nil {
+			return validationResult(false, err.Error(), ""), nil
+		}
+	}
+
+	if d, ok := ns.(CodeDisplayer); ok {
+		conceptDisplay, found := d.CodeDisplay(code)
+		if !found {
+			return validationResult(false, fmt.Sprintf("Unknown code '%s' in code system %s", code, system), ""), nil
+		} else if displayMatches(display, &NsConcept{Display: conceptDisplay}) && !isInactiveCode(ns, code) {
+			return validationResult(true, "", conceptDisplay), nil
+		}
+	}
+
+	lookup, ok := ns.(ConceptLookup)
+	if !ok {
+		return nil, fmt.Errorf("code system %s does not support $validate-code", system)
+	}
+
+	concept, err := lookup.Lookup(code, "")
+	if err != nil {
+		return nil, err
+	} else if concept == nil {
+		return validationResult(false, fmt.Sprintf("Unknown code '%s' in code system %s", code, system), ""), nil
+	}
+
+	if !displayMatches(display, concept) {
+		message := fmt.Sprintf("Display '%s' does not match any display of code '%s'", display, code)
+		return validationResult(false, message, concept.Display), nil
+	}
+
+	result := validationResult(true, inactiveConceptMessage(concept), concept.Display)
+	result.Parameter = append(result.Parameter, conceptPropertiesToParameters(concept)...)
+	return result, nil
+}
+
+// Empty system matches code from any system of value set
+func (s *Server) validateCodeInValueSet(vs *ValueSet, system string, code string, display string) (*Parameters, error) {
+	expanded, err := s.expandValueSetContent(vs, ExpandParams{})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, c := range expanded.Expansion.Contains {
+		if c.Code != code || (system != "" && normalizeNsUrl(c.System) != normalizeNsUrl(system)) {
+			continue
+		}
+
+		if !displayMatches(display, &NsConcept{Display: c.Display}) {
+			message := fmt.Sprintf("Display '%s' does not match display of code '%s'", display, code)
+			return validationResult(false, message, c.Display), nil
+		}
+
+		return validationResult(true, "", c.Display), nil
+	}
+
+	message := fmt.Sprintf("Code '%s' from system %s is not in value set %s", code, system, vs.Identifier)
+	return validationResult(false, message, ""), nil
+}
+
+func (s *Server) ValidateCodeInValueSet(id string, system string, code string, display string) (*Parameters, error) {
+	vs, err := s.Storage.FindValueSetById(id)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.validateCodeInValueSet(vs, system, code, display)
+}
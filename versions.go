@@ -0,0 +1,77 @@
+package fhirterm
+
+import (
+	"database/sql"
+)
+
+// Release of code system loaded into database, recorded by
+// ftdb importers in terminology_versions table
+type TerminologyVersion struct {
+	System      string
+	Version     string
+	ReleaseDate string
+	ImportedAt  string
+	RowCount    int
+	Checksum    string
+	Source      string
+}
+
+// Databases created by previous fhirterm versions have only
+// system, version and imported_at columns
+const legacyVersionsStmt = `
+SELECT system, version, imported_at, NULL, NULL, NULL, NULL
+FROM terminology_versions`
+
+const versionsStmt = `
+SELECT system, version, imported_at, release_date, row_count, checksum, source
+FROM terminology_versions`
+
+// Returns releases recorded in database by code system url
+func ReadTerminologyVersions(db *DB) (map[string]TerminologyVersion, error) {
+	result := make(map[string]TerminologyVersion)
+
+	exists, err := TableExists(db, "terminology_versions")
+	if err != nil || !exists {
+		return result, err
+	}
+
+	var current bool
+	err = db.QueryRow("SELECT COUNT(*) > 0 FROM ("+db.Dialect.ColumnsQuery+") AS c WHERE name = 'checksum'",
+		"terminology_versions").Scan(&current)
+	if err != nil {
+		return nil, err
+	}
+
+	query := legacyVersionsStmt
+	if current {
+		query = versionsStmt
+	}
+
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var version, importedAt, releaseDate, checksum, source sql.NullString
+		var rowCount sql.NullInt64
+		var v TerminologyVersion
+
+		err = rows.Scan(&v.System, &version, &importedAt, &releaseDate, &rowCount, &checksum, &source)
+		if err != nil {
+			return nil, err
+		}
+
+		v.Version = version.String
+		v.ImportedAt = importedAt.String
+		v.ReleaseDate = releaseDate.String
+		v.RowCount = int(rowCount.Int64)
+		v.Checksum = checksum.String
+		v.Source = source.String
+
+		result[normalizeNsUrl(v.System)] = v
+	}
+
+	return result, rows.Err()
+}